@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lauslim12/fullstack-otp/internal/otp"
+)
+
+// Exit codes of the CLI subcommands.
+const (
+	exitValid   = 0
+	exitInvalid = 1
+	exitError   = 2
+)
+
+// Subcommand 'generate', prints a new OTP.
+func runGenerate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	secret := flags.String("secret", "", "OTP shared secret (base32 encoded).")
+	period := flags.Int64("period", 30, "Period of the token in seconds.")
+	digits := flags.Int("digits", 6, "Digits of the OTP.")
+	algo := flags.String("algo", "sha1", "Hash algorithm of the OTP (sha1, sha256, or sha512).")
+	timestamp := flags.Int64("timestamp", time.Now().Unix(), "Timestamp in UNIX time, defaults to now.")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if *period <= 0 {
+		fmt.Fprintln(stderr, "period must be a positive number of seconds")
+		return exitError
+	}
+
+	hasher, err := otp.HasherFromName(*algo)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	token, err := otp.Generate(otp.TOTPConfig{
+		Secret:    *secret,
+		Period:    *period,
+		Timestamp: *timestamp,
+		Digits:    *digits,
+		Hasher:    hasher,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	fmt.Fprintln(stdout, token)
+	return exitValid
+}
+
+// Subcommand 'verify', prints the validity of an OTP and exits with 0 if it is valid, 1 if it is not.
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	secret := flags.String("secret", "", "OTP shared secret (base32 encoded).")
+	token := flags.String("otp", "", "OTP to be verified.")
+	window := flags.Int64("window", 1, "How many periods before and after the current one should be tolerated.")
+	period := flags.Int64("period", 30, "Period of the token in seconds.")
+	digits := flags.Int("digits", 6, "Digits of the OTP.")
+	algo := flags.String("algo", "sha1", "Hash algorithm of the OTP (sha1, sha256, or sha512).")
+	timestamp := flags.Int64("timestamp", time.Now().Unix(), "Timestamp in UNIX time, defaults to now.")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if *period <= 0 {
+		fmt.Fprintln(stderr, "period must be a positive number of seconds")
+		return exitError
+	}
+
+	hasher, err := otp.HasherFromName(*algo)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	config := otp.TOTPConfig{
+		Secret:    *secret,
+		Period:    *period,
+		Timestamp: *timestamp,
+		Digits:    *digits,
+		Hasher:    hasher,
+	}
+	valid, err := otp.Verify(*token, config.WithWindow(*window))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	if !valid {
+		fmt.Fprintln(stdout, "invalid")
+		return exitInvalid
+	}
+
+	fmt.Fprintln(stdout, "valid")
+	return exitValid
+}
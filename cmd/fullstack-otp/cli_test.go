@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base32"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCLI(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("The quick brown fox jumps over the lazy dog."))
+
+	t.Run("test_generate", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runGenerate([]string{"-secret", secret, "-digits", "10", "-algo", "sha512", "-timestamp", "1629795960"}, &stdout, &stderr)
+
+		assert.Equal(t, exitValid, code)
+		assert.Equal(t, "2053730166\n", stdout.String())
+		assert.Empty(t, stderr.String())
+	})
+
+	t.Run("test_generate_invalid_secret", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runGenerate([]string{"-secret", "invalid_base32"}, &stdout, &stderr)
+
+		assert.Equal(t, exitError, code)
+		assert.NotEmpty(t, stderr.String())
+	})
+
+	t.Run("test_generate_unknown_algorithm", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runGenerate([]string{"-secret", secret, "-algo", "md5"}, &stdout, &stderr)
+
+		assert.Equal(t, exitError, code)
+		assert.Equal(t, "unknown algorithm \"md5\"\n", stderr.String())
+	})
+
+	t.Run("test_generate_invalid_period", func(t *testing.T) {
+		for _, period := range []string{"0", "-30"} {
+			var stdout, stderr bytes.Buffer
+			code := runGenerate([]string{"-secret", secret, "-period", period}, &stdout, &stderr)
+
+			assert.Equal(t, exitError, code)
+			assert.Equal(t, "period must be a positive number of seconds\n", stderr.String())
+		}
+	})
+
+	t.Run("test_generate_then_verify", func(t *testing.T) {
+		var generated, stdout, stderr bytes.Buffer
+		runGenerate([]string{"-secret", secret}, &generated, &stderr)
+
+		code := runVerify([]string{"-secret", secret, "-otp", strings.TrimSpace(generated.String())}, &stdout, &stderr)
+		assert.Equal(t, exitValid, code)
+		assert.Equal(t, "valid\n", stdout.String())
+	})
+
+	t.Run("test_verify_valid", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runVerify([]string{"-secret", secret, "-otp", "2053730166", "-digits", "10", "-algo", "sha512", "-timestamp", "1629795965"}, &stdout, &stderr)
+
+		assert.Equal(t, exitValid, code)
+		assert.Equal(t, "valid\n", stdout.String())
+	})
+
+	t.Run("test_verify_invalid", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runVerify([]string{"-secret", secret, "-otp", "1234567890", "-digits", "10", "-algo", "sha512", "-timestamp", "1629795965"}, &stdout, &stderr)
+
+		assert.Equal(t, exitInvalid, code)
+		assert.Equal(t, "invalid\n", stdout.String())
+	})
+
+	t.Run("test_verify_invalid_period", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runVerify([]string{"-secret", secret, "-otp", "123456", "-period", "0"}, &stdout, &stderr)
+
+		assert.Equal(t, exitError, code)
+		assert.Equal(t, "period must be a positive number of seconds\n", stderr.String())
+	})
+
+	t.Run("test_verify_wrong_length", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runVerify([]string{"-secret", secret, "-otp", "1234"}, &stdout, &stderr)
+
+		assert.Equal(t, exitError, code)
+		assert.NotEmpty(t, stderr.String())
+	})
+}
@@ -10,8 +10,8 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/lauslim12/fullstack-otp/internal/application"
+	"github.com/lauslim12/fullstack-otp/internal/config"
 )
 
 // Get port from environment variable. If it does not exist, use '8080'.
@@ -24,17 +24,40 @@ func getPort() string {
 	return fmt.Sprintf(":%s", port)
 }
 
-// Starting point, initialize server.
+// Starting point, run a CLI subcommand if requested, otherwise initialize server.
 func main() {
-	// Add dependency: Redis.
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "generate":
+			os.Exit(runGenerate(os.Args[2:], os.Stdout, os.Stderr))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
+	serve()
+}
+
+// Initialize and run the server until it is shut down.
+func serve() {
+	// Load configurations from the environment variables.
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Add dependency: Redis. The server does not start if the Redis is unreachable.
+	redisOptions := application.DefaultRedisOptions()
+	redisOptions.Address = cfg.RedisAddress
+	redisOptions.Password = cfg.RedisPassword
+	rdb, err := application.NewRedis(redisOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// HTTP server initialization with dependency injection.
-	server := &http.Server{Addr: getPort(), Handler: application.Configure(rdb)}
+	app := application.Configure(rdb, application.WithConfig(cfg))
+	server := &http.Server{Addr: getPort(), Handler: app.Handler()}
 
 	// Prepare context for graceful shutdown.
 	serverCtx, serverStopCtx := context.WithCancel(context.Background())
@@ -62,12 +85,15 @@ func main() {
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Fatalf("Could not gracefully shutdown the server: %v\n", err)
 		}
+		if err := app.Shutdown(shutdownCtx); err != nil {
+			log.Fatalf("Could not gracefully shutdown the application: %v\n", err)
+		}
 		serverStopCtx()
 	}()
 
 	// Run our server and print out starting message.
 	log.Printf("Server has started on port %s!", getPort())
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
@@ -0,0 +1,73 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/lauslim12/fullstack-otp/internal/session"
+)
+
+// Application represents a configured application, ready to be served and shut down gracefully.
+type Application struct {
+	handler  http.Handler
+	store    session.Store
+	inFlight sync.WaitGroup
+	mu       sync.RWMutex
+	closed   bool
+}
+
+// ServeHTTP serves a request while keeping track of it, so it can be drained during shutdown.
+// Requests that come after the application has been shut down will fail fast.
+func (a *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		sendFailureResponse(w, r, NewFailureResponse(http.StatusServiceUnavailable, "Application is shutting down!").WithErrorCode(ErrorCodeShuttingDown))
+		return
+	}
+	a.inFlight.Add(1)
+	a.mu.RUnlock()
+	defer a.inFlight.Done()
+
+	a.handler.ServeHTTP(w, r)
+}
+
+// Handler returns the application as an HTTP handler.
+func (a *Application) Handler() http.Handler {
+	return a
+}
+
+// Shutdown stops accepting new requests, waits for in-flight requests to finish, and closes the session store.
+// If the context expires before all requests are drained, the session store is still closed and the context error is returned.
+// Without a Redis client nor a custom session store, there is nothing to close, so only the requests are drained.
+func (a *Application) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+
+	// Wait for all in-flight requests to finish.
+	drained := make(chan struct{})
+	go func() {
+		a.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return a.closeStore()
+	case <-ctx.Done():
+		a.closeStore()
+		return ctx.Err()
+	}
+}
+
+// Utility function to close the session store, ignoring a Redis store that has no client to close.
+func (a *Application) closeStore() error {
+	if err := a.store.Close(); err != nil && !errors.Is(err, session.ErrNilClient) {
+		return err
+	}
+
+	return nil
+}
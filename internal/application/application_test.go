@@ -0,0 +1,52 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	rdb := initializeTestRedis()
+	app := Configure(rdb)
+
+	t.Run("test_before_shutdown", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1", nil)
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("test_shutdown_closes_client", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		err := app.Shutdown(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, redis.ErrClosed, rdb.Ping(context.Background()).Err())
+	})
+
+	t.Run("test_after_shutdown", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1", nil)
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusServiceUnavailable, "Application is shutting down!").WithErrorCode(ErrorCodeShuttingDown)), withoutRequestID(w.Body.String()))
+	})
+}
+
+func TestShutdownWithoutClient(t *testing.T) {
+	app := Configure(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.Nil(t, app.Shutdown(ctx))
+}
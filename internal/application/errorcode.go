@@ -0,0 +1,41 @@
+package application
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Machine-readable error codes, so clients are able to branch on errors without matching the messages.
+// Failures without a specific error code fall back to the name of their HTTP status, such as 'BAD_REQUEST'.
+const (
+	ErrorCodeMissingAuthorization = "MISSING_AUTHORIZATION"
+	ErrorCodeInvalidCredentials   = "INVALID_CREDENTIALS"
+	ErrorCodeInvalidOTP           = "INVALID_OTP"
+	ErrorCodeInvalidOTPLength     = "INVALID_OTP_LENGTH"
+	ErrorCodeOTPReused            = "OTP_REUSED"
+	ErrorCodeInvalidBackupCode    = "INVALID_BACKUP_CODE"
+	ErrorCodeSessionNotFound      = "SESSION_NOT_FOUND"
+	ErrorCodeUserNotFound         = "USER_NOT_FOUND"
+	ErrorCodeRateLimited          = "RATE_LIMITED"
+	ErrorCodeInvalidCSRFToken     = "INVALID_CSRF_TOKEN"
+	ErrorCodeOriginNotAllowed     = "ORIGIN_NOT_ALLOWED"
+	ErrorCodeShuttingDown         = "SHUTTING_DOWN"
+	ErrorCodeTimeout              = "TIMEOUT"
+	ErrorCodeValidationFailed     = "VALIDATION_FAILED"
+	ErrorCodeAccountLocked        = "ACCOUNT_LOCKED"
+	ErrorCodeUserExists           = "USER_EXISTS"
+	ErrorCodeNotEnrolled          = "NOT_ENROLLED"
+	ErrorCodeAlreadyEnrolled      = "ALREADY_ENROLLED"
+	ErrorCodeHTTPSRequired        = "HTTPS_REQUIRED"
+	ErrorCodeInvalidEnrollToken   = "INVALID_ENROLLMENT_TOKEN"
+)
+
+// Utility function to create the default error code of an HTTP status, for example 'Not Found' becomes 'NOT_FOUND'.
+func defaultErrorCode(statusCode int) string {
+	statusText := http.StatusText(statusCode)
+	if statusText == "" {
+		return "UNKNOWN_ERROR"
+	}
+
+	return strings.ToUpper(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(statusText))
+}
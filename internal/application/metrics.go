@@ -0,0 +1,80 @@
+package application
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics represents the counters of the authentication outcomes of the application.
+// A nil 'metrics' is valid, and does not record anything.
+type metrics struct {
+	registry     *prometheus.Registry
+	otpGenerated prometheus.Counter
+	otpVerified  *prometheus.CounterVec
+	login        *prometheus.CounterVec
+}
+
+// Utility function to create and register all of the counters to the given registry.
+func newMetrics(registry *prometheus.Registry) *metrics {
+	m := &metrics{
+		registry: registry,
+		otpGenerated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "otp_generated_total",
+			Help: "Total number of generated OTPs.",
+		}),
+		otpVerified: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otp_verified_total",
+			Help: "Total number of OTP verifications, partitioned by the result.",
+		}, []string{"result"}),
+		login: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "login_total",
+			Help: "Total number of logins, partitioned by the result.",
+		}, []string{"result"}),
+	}
+	registry.MustRegister(m.otpGenerated, m.otpVerified, m.login)
+
+	return m
+}
+
+// Utility function to get the label of a result.
+func resultLabel(valid bool) string {
+	if valid {
+		return "valid"
+	}
+
+	return "invalid"
+}
+
+// Records a newly generated OTP.
+func (m *metrics) observeOTPGenerated() {
+	if m == nil {
+		return
+	}
+
+	m.otpGenerated.Inc()
+}
+
+// Records the result of an OTP verification.
+func (m *metrics) observeOTPVerified(valid bool) {
+	if m == nil {
+		return
+	}
+
+	m.otpVerified.WithLabelValues(resultLabel(valid)).Inc()
+}
+
+// Records the result of a login.
+func (m *metrics) observeLogin(valid bool) {
+	if m == nil {
+		return
+	}
+
+	m.login.WithLabelValues(resultLabel(valid)).Inc()
+}
+
+// Handler to expose all of the metrics in the registry.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,83 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	handler := Configure(initializeTestRedis(), WithMetrics(prometheus.NewRegistry()))
+
+	scrape := func() string {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	login := func(body string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+	}
+
+	t.Run("test_metrics_before_login", func(t *testing.T) {
+		assert.Contains(t, scrape(), "otp_generated_total 0")
+	})
+
+	t.Run("test_metrics_after_login", func(t *testing.T) {
+		login(`{"username":"kaede","password":"kaede"}`)
+		login(`{"username":"kimura","password":"kaori"}`)
+
+		body := scrape()
+		assert.Contains(t, body, "otp_generated_total 1")
+		assert.Contains(t, body, `login_total{result="valid"} 1`)
+		assert.Contains(t, body, `login_total{result="invalid"} 1`)
+	})
+
+	t.Run("test_metrics_after_verification", func(t *testing.T) {
+		verifyTestUser(handler)
+
+		assert.Contains(t, scrape(), `otp_verified_total{result="valid"} 1`)
+	})
+
+	t.Run("test_metrics_replayed_otp", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kaede","password":"kaede"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Idempotency-Key", "key")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+
+		assert.Contains(t, scrape(), "otp_generated_total 2")
+	})
+
+	t.Run("test_metrics_disabled", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		Configure(initializeTestRedis()).ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestNilMetricsRegistry(t *testing.T) {
+	var handler http.Handler
+	assert.NotPanics(t, func() {
+		handler = Configure(initializeTestRedis(), WithMetrics(nil))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
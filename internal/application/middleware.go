@@ -0,0 +1,276 @@
+package application
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// CORSOptions is used to configure which cross-origin requests are allowed to access the API.
+type CORSOptions struct {
+	AllowedOrigins   []string // Origins that are allowed to access the API. Use '*' to allow every origin, without credentials.
+	AllowedMethods   []string // Methods that are allowed in cross-origin requests.
+	AllowedHeaders   []string // Headers that are allowed in cross-origin requests.
+	AllowCredentials bool     // Whether cookies are allowed to be sent in cross-origin requests from the listed origins.
+	MaxAge           int      // How long the result of a preflight request can be cached in seconds.
+}
+
+// DefaultCORSOptions returns the default CORS options, which only allows same-origin requests.
+func DefaultCORSOptions() CORSOptions {
+	return CORSOptions{
+		AllowedOrigins:   []string{},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowCredentials: false,
+		MaxAge:           0,
+	}
+}
+
+// Utility function to get the 'Access-Control-Allow-Origin' of an origin, and whether credentials are allowed for it.
+// Listed origins are echoed back. Origins that are only allowed through '*' get a literal '*' without credentials, so
+// that any website is not able to make credentialed requests, even if 'AllowCredentials' is set.
+func (c CORSOptions) allowOrigin(origin string) (string, bool, bool) {
+	wildcard := false
+	for _, allowedOrigin := range c.AllowedOrigins {
+		if allowedOrigin == origin {
+			return origin, c.AllowCredentials, true
+		}
+		if allowedOrigin == "*" {
+			wildcard = true
+		}
+	}
+
+	if wildcard {
+		return "*", false, true
+	}
+
+	return "", false, false
+}
+
+// Middleware to handle CORS requests, including preflight requests.
+func corsMiddleware(corsOptions CORSOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Same-origin requests do not need any special handling.
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			allowOrigin, allowCredentials, allowed := corsOptions.allowOrigin(origin)
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// Handle preflight requests.
+			if preflight {
+				if !allowed {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusForbidden, "Origin is not allowed to access this resource!").WithErrorCode(ErrorCodeOriginNotAllowed))
+					return
+				}
+
+				w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsOptions.AllowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsOptions.AllowedHeaders, ", "))
+				if allowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if corsOptions.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsOptions.MaxAge))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			// Handle actual cross-origin requests. Disallowed origins are left to the browser to block.
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+				if allowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Context key to pass around the address of the peer that sent the request, before it is replaced by 'middleware.RealIP'.
+type peerAddrKey struct{}
+
+// Middleware to remember the address of the peer, as 'middleware.RealIP' replaces it with the forwarded headers,
+// which the client is able to set. Must be used before 'middleware.RealIP'.
+func peerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Utility function to check if the peer of a request is one of the trusted proxies.
+func fromTrustedProxy(r *http.Request, trustedProxies []*net.IPNet) bool {
+	peerAddr, ok := r.Context().Value(peerAddrKey{}).(string)
+	if !ok {
+		peerAddr = r.RemoteAddr
+	}
+	host, _, err := net.SplitHostPort(peerAddr)
+	if err != nil {
+		host = peerAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, trustedProxy := range trustedProxies {
+		if trustedProxy.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware to refuse plaintext HTTP requests, so the session cookies are never sent over an insecure connection.
+// Requests behind a TLS-terminating proxy are allowed if the proxy sets 'X-Forwarded-Proto' to 'https', but only if
+// the proxy is trusted, as any other client is able to set the header as well.
+func httpsMiddleware(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardedHTTPS := strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") && fromTrustedProxy(r, trustedProxies)
+			if r.TLS == nil && !forwardedHTTPS {
+				sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "This route is only available over HTTPS!").WithErrorCode(ErrorCodeHTTPSRequired))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware to protect cookie-authenticated routes from CSRF with the double-submit cookie pattern.
+// State-changing requests must send the value of the 'csrf' cookie in the 'X-CSRF-Token' header.
+func csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Safe methods do not change state, so they are allowed.
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Compare the cookie and the header in constant time.
+		csrfCookie, err := r.Cookie("csrf")
+		csrfHeader := r.Header.Get("X-CSRF-Token")
+		if err != nil || csrfCookie.Value == "" || subtle.ConstantTimeCompare([]byte(csrfCookie.Value), []byte(csrfHeader)) != 1 {
+			sendFailureResponse(w, r, NewFailureResponse(http.StatusForbidden, "Invalid or missing CSRF token!").WithErrorCode(ErrorCodeInvalidCSRFToken))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Utility function to check if an 'Accept' header allows a JSON response.
+func acceptsJSON(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(mediaRange, ";", 2)[0]))
+		if mediaType == "application/json" || mediaType == "application/*" || mediaType == "*/*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware to reject requests that explicitly do not accept JSON, as the API always responds with JSON.
+// Requests without an 'Accept' header are allowed.
+func acceptMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept")
+		if accept != "" && !acceptsJSON(accept) {
+			sendFailureResponse(w, r, NewFailureResponse(http.StatusNotAcceptable, "This API only responds with 'application/json'!"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware to bound how long a request may take. The deadline is passed via the context of the request,
+// so slow operations, such as Redis calls, are cancelled instead of hanging the handlers indefinitely.
+func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Middleware to recover from panics, so clients still receive a JSON response instead of a plaintext one.
+// The stack is logged with the structured logger if set, or with the standard logger otherwise.
+func recoverMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+
+				// Aborted handlers are not errors, and have to be propagated to the server.
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+
+				if logger != nil {
+					logger.Error(
+						"request panicked",
+						slog.Any("panic", rvr),
+						slog.String("stack", string(debug.Stack())),
+						slog.String("requestId", middleware.GetReqID(r.Context())),
+					)
+				} else {
+					log.Printf("panic: %v\n%s", rvr, debug.Stack())
+				}
+
+				sendFailureResponse(w, r, NewFailureResponse(http.StatusInternalServerError, "Internal server error! Please try again later!"))
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware to log every request with structured fields, to be consumed by log aggregators.
+func structuredLoggerMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				logger.Info(
+					"request completed",
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.Int("status", ww.Status()),
+					slog.Duration("duration", time.Since(start)),
+					slog.String("requestId", middleware.GetReqID(r.Context())),
+				)
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
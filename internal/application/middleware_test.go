@@ -0,0 +1,372 @@
+package application
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := Configure(initializeTestRedis(), WithCORS(CORSOptions{
+		AllowedOrigins:   []string{"https://frontend.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}))
+
+	t.Run("test_preflight_allowed_origin", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/api/v1/auth/login", nil)
+		w := httptest.NewRecorder()
+		r.Header.Set("Origin", "https://frontend.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://frontend.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("test_preflight_disallowed_origin", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/api/v1/auth/login", nil)
+		w := httptest.NewRecorder()
+		r.Header.Set("Origin", "https://evil.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusForbidden, "Origin is not allowed to access this resource!").WithErrorCode(ErrorCodeOriginNotAllowed)), withoutRequestID(w.Body.String()))
+	})
+
+	t.Run("test_simple_request_allowed_origin", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1", nil)
+		w := httptest.NewRecorder()
+		r.Header.Set("Origin", "https://frontend.example.com")
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://frontend.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("test_wildcard_with_credentials", func(t *testing.T) {
+		wildcardHandler := Configure(initializeTestRedis(), WithCORS(CORSOptions{
+			AllowedOrigins:   []string{"https://frontend.example.com", "*"},
+			AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+			AllowCredentials: true,
+		}))
+
+		tests := []struct {
+			name        string
+			method      string
+			origin      string
+			allowOrigin string
+			credentials string
+		}{
+			{name: "test_preflight_arbitrary_origin", method: http.MethodOptions, origin: "https://evil.example.com", allowOrigin: "*", credentials: ""},
+			{name: "test_simple_arbitrary_origin", method: http.MethodGet, origin: "https://evil.example.com", allowOrigin: "*", credentials: ""},
+			{name: "test_simple_listed_origin", method: http.MethodGet, origin: "https://frontend.example.com", allowOrigin: "https://frontend.example.com", credentials: "true"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(tt.method, "/api/v1", nil)
+				w := httptest.NewRecorder()
+				r.Header.Set("Origin", tt.origin)
+				if tt.method == http.MethodOptions {
+					r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+				}
+				wildcardHandler.ServeHTTP(w, r)
+
+				assert.Equal(t, tt.allowOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, tt.credentials, w.Header().Get("Access-Control-Allow-Credentials"))
+			})
+		}
+	})
+
+	t.Run("test_default_same_origin_only", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/api/v1/auth/login", nil)
+		w := httptest.NewRecorder()
+		r.Header.Set("Origin", "https://frontend.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		Configure(initializeTestRedis()).ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	handler := csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Passed!", nil))
+	}))
+
+	tests := []struct {
+		name           string
+		method         string
+		cookie         string
+		header         string
+		expectedStatus int
+	}{
+		{
+			name:           "test_safe_method",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_matching_token",
+			method:         http.MethodPost,
+			cookie:         "token",
+			header:         "token",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_mismatched_token",
+			method:         http.MethodPost,
+			cookie:         "token",
+			header:         "another",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "test_missing_header",
+			method:         http.MethodDelete,
+			cookie:         "token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "test_missing_cookie",
+			method:         http.MethodPost,
+			header:         "token",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/sessions", nil)
+			w := httptest.NewRecorder()
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "csrf", Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set("X-CSRF-Token", tt.header)
+			}
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestStructuredLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	handler := Configure(initializeTestRedis(), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/404", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	logLine := map[string]interface{}{}
+	err := json.Unmarshal(buf.Bytes(), &logLine)
+	assert.Nil(t, err)
+	assert.Equal(t, http.MethodGet, logLine["method"])
+	assert.Equal(t, "/api/v1/404", logLine["path"])
+	assert.Equal(t, float64(http.StatusNotFound), logLine["status"])
+	assert.Contains(t, logLine, "duration")
+	assert.NotEmpty(t, logLine["requestId"])
+}
+
+func TestAcceptMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		strict         bool
+		accept         string
+		expectedStatus int
+	}{
+		{name: "test_accept_json", strict: true, accept: "application/json", expectedStatus: http.StatusOK},
+		{name: "test_accept_wildcard", strict: true, accept: "text/html, */*;q=0.8", expectedStatus: http.StatusOK},
+		{name: "test_accept_absent", strict: true, accept: "", expectedStatus: http.StatusOK},
+		{name: "test_accept_html", strict: true, accept: "text/html", expectedStatus: http.StatusNotAcceptable},
+		{name: "test_accept_html_not_strict", strict: false, accept: "text/html", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), WithStrictAccept(tt.strict))
+			r := httptest.NewRequest(http.MethodGet, "/api/v1", nil)
+			w := httptest.NewRecorder()
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusNotAcceptable {
+				assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusNotAcceptable, "This API only responds with 'application/json'!")), withoutRequestID(w.Body.String()))
+			}
+		})
+	}
+}
+
+func TestHTTPSMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		require        bool
+		target         string
+		tls            bool
+		forwardedProto string
+		trustedProxies []string
+		expectedStatus int
+	}{
+		{name: "test_http_rejected", require: true, target: "/api/v1/auth/login", expectedStatus: http.StatusBadRequest},
+		{name: "test_forwarded_http_rejected", require: true, target: "/api/v1/auth/login", forwardedProto: "http", trustedProxies: []string{"192.0.2.0/24"}, expectedStatus: http.StatusBadRequest},
+		{name: "test_forwarded_https_allowed", require: true, target: "/api/v1/auth/login", forwardedProto: "https", trustedProxies: []string{"192.0.2.0/24"}, expectedStatus: http.StatusOK},
+		{name: "test_forwarded_https_single_ip", require: true, target: "/api/v1/auth/login", forwardedProto: "https", trustedProxies: []string{"192.0.2.1"}, expectedStatus: http.StatusOK},
+		{name: "test_forwarded_https_untrusted", require: true, target: "/api/v1/auth/login", forwardedProto: "https", expectedStatus: http.StatusBadRequest},
+		{name: "test_forwarded_https_other_proxy", require: true, target: "/api/v1/auth/login", forwardedProto: "https", trustedProxies: []string{"10.0.0.0/8", "invalid"}, expectedStatus: http.StatusBadRequest},
+		{name: "test_tls_allowed", require: true, target: "/api/v1/auth/login", tls: true, expectedStatus: http.StatusOK},
+		{name: "test_http_not_required", require: false, target: "/api/v1/auth/login", expectedStatus: http.StatusOK},
+		{name: "test_http_outside_auth_routes", require: true, target: "/api/v1/time", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), WithRequireHTTPS(tt.require), WithTrustedProxies(tt.trustedProxies...))
+			method := http.MethodPost
+			if !strings.HasPrefix(tt.target, "/api/v1/auth") {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.target, strings.NewReader(structToJSON(AuthRequestBody{Username: "kaede", Password: "kaede"})))
+			w := httptest.NewRecorder()
+			r.Header.Set("Content-Type", "application/json")
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwardedProto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusBadRequest {
+				assert.Contains(t, w.Body.String(), ErrorCodeHTTPSRequired)
+			}
+		})
+	}
+
+	t.Run("test_forwarded_for_does_not_spoof_proxy", func(t *testing.T) {
+		handler := Configure(initializeTestRedis(), WithRequireHTTPS(true), WithTrustedProxies("10.0.0.1"))
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(structToJSON(AuthRequestBody{Username: "kaede", Password: "kaede"})))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-For", "10.0.0.1")
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		name             string
+		options          []Option
+		acceptEncoding   string
+		expectedEncoding string
+	}{
+		{
+			name:             "test_gzip_supported",
+			options:          nil,
+			acceptEncoding:   "gzip",
+			expectedEncoding: "gzip",
+		},
+		{
+			name:             "test_gzip_not_supported",
+			options:          nil,
+			acceptEncoding:   "",
+			expectedEncoding: "",
+		},
+		{
+			name:             "test_gzip_disabled",
+			options:          []Option{WithCompression(false)},
+			acceptEncoding:   "gzip",
+			expectedEncoding: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), tt.options...)
+			r := httptest.NewRequest(http.MethodGet, "/api/v1", nil)
+			w := httptest.NewRecorder()
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectedEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var body io.Reader = w.Body
+			if tt.expectedEncoding == "gzip" {
+				gz, err := gzip.NewReader(w.Body)
+				assert.Nil(t, err)
+				body = gz
+			}
+
+			decompressed, err := io.ReadAll(body)
+			assert.Nil(t, err)
+			assert.JSONEq(t, structToJSON(NewSuccessResponse(http.StatusOK, "Welcome to 'net/http' API!", nil)), withoutRequestID(string(decompressed)))
+		})
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	panics := withValidateOTP(func(passcode string, secret string, t time.Time, opts totp.ValidateOpts) (bool, int64, error) {
+		panic("unexpected panic")
+	})
+
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{
+			name:    "test_recover_default_logger",
+			options: nil,
+		},
+		{
+			name:    "test_recover_structured_logger",
+			options: []Option{WithLogger(slog.New(slog.NewJSONHandler(io.Discard, nil)))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), append(tt.options, panics)...)
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("kaede", generateTestOTP(DefaultOTPOptions()))
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusInternalServerError, "Internal server error! Please try again later!")), withoutRequestID(w.Body.String()))
+			assert.Contains(t, w.Body.String(), `"requestId"`)
+		})
+	}
+}
@@ -4,19 +4,25 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/subtle"
-	"encoding/base32"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-redis/redis/v8"
+	rfcotp "github.com/lauslim12/fullstack-otp/internal/otp"
 	"github.com/lauslim12/fullstack-otp/internal/session"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
@@ -24,10 +30,11 @@ import (
 
 // SuccessResponse is used to handle successful requests.
 type SuccessResponse struct {
-	Status  string      `json:"status"`
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+	Status    string      `json:"status"`
+	Code      int         `json:"code"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
 }
 
 // NewSuccessResponse is used to create a default, new success response.
@@ -42,18 +49,64 @@ func NewSuccessResponse(code int, message string, data interface{}) *SuccessResp
 
 // FailureResponse is used to handle failed requests.
 type FailureResponse struct {
-	Status  string `json:"status"`
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Status         string       `json:"status"`
+	Code           int          `json:"code"`
+	ErrorCode      string       `json:"errorCode"`
+	Message        string       `json:"message"`
+	Errors         []FieldError `json:"errors,omitempty"`
+	AllowedMethods []string     `json:"allowedMethods,omitempty"`
+	RequestID      string       `json:"requestId,omitempty"`
 }
 
-// NewFailureResponse is used to create a default, new failure response.
+// NewFailureResponse is used to create a default, new failure response. Error code defaults to the HTTP status.
 func NewFailureResponse(code int, message string) *FailureResponse {
 	return &FailureResponse{
-		Status:  "fail",
-		Code:    code,
-		Message: message,
+		Status:    "fail",
+		Code:      code,
+		ErrorCode: defaultErrorCode(code),
+		Message:   message,
+	}
+}
+
+// Utility function to create the failure response of an unexpected error. If the request or one of its operations has
+// run out of time, clients are told to try again later instead. The error itself is only logged, as it might contain
+// internal details, such as the addresses of the dependencies.
+func serverFailure(r *http.Request, err error) *FailureResponse {
+	if isTimeout(r, err) {
+		return NewFailureResponse(http.StatusServiceUnavailable, "Request timed out! Please try again later!").WithErrorCode(ErrorCodeTimeout)
+	}
+
+	log.Printf("error: %v (request ID: %s)", err, middleware.GetReqID(r.Context()))
+	return NewFailureResponse(http.StatusInternalServerError, "Internal server error! Please try again later!")
+}
+
+// Utility function to check if an error is caused by a timeout. The deadline of a socket, such as the one of the Redis
+// client, might be exceeded slightly before the deadline of the request, so the error is checked as well.
+func isTimeout(r *http.Request, err error) bool {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
 	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// WithErrorCode is used to set a specific, machine-readable error code of a failure response.
+func (f *FailureResponse) WithErrorCode(errorCode string) *FailureResponse {
+	f.ErrorCode = errorCode
+	return f
+}
+
+// WithFieldErrors is used to list every invalid field of a request body in a failure response.
+func (f *FailureResponse) WithFieldErrors(fieldErrors []FieldError) *FailureResponse {
+	f.Errors = fieldErrors
+	return f
+}
+
+// WithAllowedMethods is used to list the methods that are allowed in a route, for '405 Method Not Allowed'.
+func (f *FailureResponse) WithAllowedMethods(methods []string) *FailureResponse {
+	f.AllowedMethods = methods
+	return f
 }
 
 // AuthRequestBody is to create the basic type of an incoming authentication request body.
@@ -62,34 +115,64 @@ type AuthRequestBody struct {
 	Password string `json:"password"`
 }
 
+// EnrollConfirmRequestBody is the request body to confirm an enrollment with an OTP from the pending secret.
+type EnrollConfirmRequestBody struct {
+	AuthRequestBody
+	Code string `json:"code"`
+}
+
+// VerificationRequestBody is the request body to verify an OTP, as an alternative to the basic authentication.
+type VerificationRequestBody struct {
+	Username string `json:"username"`
+	OTP      string `json:"otp"`
+}
+
 // ContextKey is used to pass around userID in requests.
 type ContextKey struct{}
 
-// Utility function to send succesful response.
-func sendSuccessResponse(w http.ResponseWriter, successResponse *SuccessResponse) {
+// Context key to pass around the username that a pre-enrollment token is issued for.
+type enrollUsernameKey struct{}
+
+// Maximum length of an OTP sent by clients. Anything longer is obviously invalid, and is rejected before any hashing.
+const maxPasscodeLength = 64
+
+// Utility function to send succesful response. Request ID is attached, so clients are able to correlate requests.
+func sendSuccessResponse(w http.ResponseWriter, r *http.Request, successResponse *SuccessResponse) {
+	successResponse.RequestID = middleware.GetReqID(r.Context())
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(successResponse.Code)
 	json.NewEncoder(w).Encode(successResponse)
 }
 
-// Utility function to send failure response.
-func sendFailureResponse(w http.ResponseWriter, failureResponse *FailureResponse) {
+// Utility function to send failure response. Request ID is attached, so clients are able to correlate errors.
+func sendFailureResponse(w http.ResponseWriter, r *http.Request, failureResponse *FailureResponse) {
+	failureResponse.RequestID = middleware.GetReqID(r.Context())
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(failureResponse.Code)
 	json.NewEncoder(w).Encode(failureResponse)
 }
 
-// Utility function to decode a JSON request body.
-func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) *FailureResponse {
+// Utility function to check whether a 'Content-Type' header is JSON. Parameters such as the charset are ignored,
+// and the media type is case-insensitive.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// Utility function to decode a JSON request body, with 'maxBytes' as the maximum size of the body.
+// If 'strict' is true, fields that do not exist in 'dst' are rejected instead of being ignored.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64, strict bool) *FailureResponse {
 	// Check if Header is 'Content-Type: application/json'.
-	if r.Header.Get("Content-Type") != "application/json" {
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
 		return NewFailureResponse(http.StatusUnsupportedMediaType, "The 'Content-Type' header is not 'application/json'!")
 	}
 
-	// Parse body, and set max bytes reader (512 bytes).
-	r.Body = http.MaxBytesReader(w, r.Body, 512)
+	// Parse body, and set max bytes reader.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
 	if err := decoder.Decode(dst); err != nil {
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
@@ -123,12 +206,12 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) *Fa
 
 		// Handle too large body.
 		case err.Error() == "http: request body too large":
-			errorMessage := "Request body must not be larger than 512 bytes!"
+			errorMessage := fmt.Sprintf("Request body must not be larger than %d bytes!", maxBytes)
 			return NewFailureResponse(http.StatusRequestEntityTooLarge, errorMessage)
 
 		// Handle other errors.
 		default:
-			return NewFailureResponse(http.StatusInternalServerError, err.Error())
+			return serverFailure(r, err)
 		}
 	}
 	defer r.Body.Close()
@@ -143,73 +226,572 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) *Fa
 	return nil
 }
 
+// Utility function to get the IP of the client. 'RealIP' middleware has already replaced the remote address if proxied.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Utility function to build the 'WWW-Authenticate' header, asking the client for the basic authentication.
+func basicAuthChallenge(realm string) string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm)
+}
+
+// Utility function to build the provisioning URI of a user, to be rendered by authenticator apps.
+func provisioningURI(options *options, user *User) (string, error) {
+	validateOpts := options.otp.validateOptsFor(user)
+	algorithm, err := rfcotp.ParseAlgorithm(validateOpts.Algorithm.String())
+	if err != nil {
+		return "", err
+	}
+
+	return rfcotp.GenerateProvisioningURI(rfcotp.ProvisioningConfig{
+		Issuer:      "Fullstack OTP",
+		AccountName: user.Username,
+		Secret:      user.Secret,
+		Period:      int64(validateOpts.Period),
+		Digits:      validateOpts.Digits.Length(),
+		Algorithm:   algorithm,
+	})
+}
+
+// Utility function to validate an OTP like 'totp.ValidateCustom', while reporting the offset of the period the OTP was
+// generated in, so the clock skew of the client is known without validating the OTP twice. A positive offset means the
+// clock of the client is most likely ahead. Skews larger than the maximum window of 'rfcotp' are still validated,
+// but without an offset.
+func validateWithOffset(passcode, secret string, t time.Time, opts totp.ValidateOpts) (bool, int64, error) {
+	algorithm, err := rfcotp.ParseAlgorithm(opts.Algorithm.String())
+	if err != nil || opts.Skew > rfcotp.MaxWindow {
+		valid, err := totp.ValidateCustom(passcode, secret, t, opts)
+		return valid, 0, err
+	}
+
+	// Keep the errors and the results of 'totp.ValidateCustom', which only trims the passcode, and accepts unpadded secrets.
+	passcode = strings.TrimSpace(passcode)
+	if len(passcode) != opts.Digits.Length() {
+		return false, 0, otp.ErrValidateInputInvalidLength
+	}
+	for i := 0; i < len(passcode); i++ {
+		if passcode[i] < '0' || passcode[i] > '9' {
+			return false, 0, nil
+		}
+	}
+	secret = strings.TrimSpace(secret)
+	if n := len(secret) % 8; n != 0 {
+		secret += strings.Repeat("=", 8-n)
+	}
+
+	return rfcotp.VerifyWithOffset(passcode, rfcotp.TOTPValidateConfig{
+		Secret:    secret,
+		Period:    int64(opts.Period),
+		Timestamp: t.Unix(),
+		Digits:    opts.Digits.Length(),
+		Algorithm: algorithm,
+		Window:    int64(opts.Skew),
+	})
+}
+
+// Utility function to send the session and the CSRF cookies, which expire at the same time as the session.
+// CSRF cookie is not 'HttpOnly', as the client has to read it for the double-submit cookie pattern.
+func setSessionCookies(w http.ResponseWriter, options *options, sessionKey, csrfToken string) time.Time {
+	expiresAt := time.Now().Add(options.sessionTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     options.cookieName,
+		Value:    sessionKey,
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(options.sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   options.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf",
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(options.sessionTTL.Seconds()),
+		Secure:   options.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return expiresAt
+}
+
+// Maximum length of the 'Idempotency-Key' header of the login route.
+const maxIdempotencyKeyLength = 255
+
+// Maximum attempts to generate an unused session ID.
+const maxSessionIDAttempts = 3
+
+// Utility function to create a new session for the user, and send the session and the CSRF cookies.
+// The role of the user is stored in the session for display only, as authorization always uses the user store.
+// If the sessions of a user are limited, the oldest ones are evicted atomically while creating the new session.
+func startSession(w http.ResponseWriter, r *http.Request, sess session.Store, options *options, user *User) (string, error) {
+	// Session IDs are regenerated on the vanishingly unlikely collision, instead of overwriting another session.
+	var sessionKey string
+	for attempt := 0; ; attempt++ {
+		var err error
+		sessionKey, err = session.GenerateSessionID(32)
+		if err != nil {
+			return "", err
+		}
+
+		err = sess.CreateWithLimit(r.Context(), sessionKey, user.Username, session.Metadata{UserAgent: r.UserAgent(), IP: clientIP(r), Role: user.role()}, options.maxSessions)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, session.ErrSessionExists) || attempt+1 >= maxSessionIDAttempts {
+			return "", err
+		}
+	}
+
+	csrfToken, err := session.GenerateSessionID(32)
+	if err != nil {
+		return "", err
+	}
+
+	setSessionCookies(w, options, sessionKey, csrfToken)
+
+	return sessionKey, nil
+}
+
+// Utility function to reject an OTP attempt if the account of the user is locked, or if the user has made too many
+// attempts, when enabled. Returns false if the attempt has been rejected, and the failure response has been sent.
+func allowAttempt(w http.ResponseWriter, r *http.Request, sess session.Store, options *options, username string) bool {
+	if options.lockout.MaxFailures > 0 {
+		locked, err := sess.IsLocked(r.Context(), username)
+		if err != nil {
+			sendFailureResponse(w, r, serverFailure(r, err))
+			return false
+		}
+		if locked {
+			sendFailureResponse(w, r, NewFailureResponse(http.StatusLocked, "Your account is temporarily locked due to too many failed attempts!").WithErrorCode(ErrorCodeAccountLocked))
+			return false
+		}
+	}
+
+	if options.rateLimit.MaxAttempts > 0 {
+		attempts, err := sess.IncrementAttempts(r.Context(), username, options.rateLimit.Window)
+		if err != nil {
+			sendFailureResponse(w, r, serverFailure(r, err))
+			return false
+		}
+
+		if attempts > options.rateLimit.MaxAttempts {
+			ttl, err := sess.AttemptsTTL(r.Context(), username)
+			if err != nil {
+				sendFailureResponse(w, r, serverFailure(r, err))
+				return false
+			}
+
+			// Round up, so clients never retry before the window has passed.
+			retryAfter := int64(math.Ceil(ttl.Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			sendFailureResponse(w, r, NewFailureResponse(http.StatusTooManyRequests, "Too many verification attempts! Please try again later!").WithErrorCode(ErrorCodeRateLimited))
+			return false
+		}
+	}
+
+	return true
+}
+
+// Utility function to count a failed verification of a user, and lock the account once there are too many of them.
+func recordFailure(ctx context.Context, sess session.Store, lockout LockoutOptions, userID string) error {
+	failures, err := sess.IncrementFailures(ctx, userID, lockout.Duration)
+	if err != nil {
+		return err
+	}
+	if failures < lockout.MaxFailures {
+		return nil
+	}
+
+	if err := sess.LockAccount(ctx, userID, lockout.Duration); err != nil {
+		return err
+	}
+
+	return sess.ResetFailures(ctx, userID)
+}
+
+// Default and maximum number of audit events returned to a user.
+const (
+	defaultAuditLimit = 20
+	maxAuditLimit     = session.MaxAuditEvents
+)
+
+// Methods that are checked when looking for the allowed methods of a route.
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// Utility function to check if a route matches a method and a path. Chi matches every method on the roots of the
+// subrouters, such as '/api/v1', so subrouters are descended manually, just like the way requests are routed.
+func matchRoute(routes chi.Routes, method, path string) bool {
+	for _, route := range routes.Routes() {
+		prefix := strings.TrimSuffix(route.Pattern, "/*")
+		if route.SubRoutes == nil || prefix == route.Pattern {
+			continue
+		}
+
+		if path == prefix || path == prefix+"/" {
+			return matchRoute(route.SubRoutes, method, "/")
+		}
+		if strings.HasPrefix(path, prefix+"/") {
+			return matchRoute(route.SubRoutes, method, strings.TrimPrefix(path, prefix))
+		}
+	}
+
+	return routes.Match(chi.NewRouteContext(), method, path)
+}
+
+// Utility function to find the methods that are allowed in the route of a request.
+func allowedMethods(r *http.Request) []string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return nil
+	}
+
+	var methods []string
+	for _, method := range routeMethods {
+		if matchRoute(rctx.Routes, method, r.URL.Path) {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
+
+// Utility function to record an authentication event of a user in the audit log. Failures to record are only logged,
+// so the audit log being unavailable never fails an authentication that has already happened, such as a new session.
+func audit(r *http.Request, sess session.Store, userID, eventType, outcome string) {
+	err := sess.AppendAudit(r.Context(), userID, session.AuditEvent{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		IP:        clientIP(r),
+		Outcome:   outcome,
+	})
+	if err != nil {
+		log.Printf("error: failed to audit the %s of %q: %v (request ID: %s)", eventType, userID, err, middleware.GetReqID(r.Context()))
+	}
+}
+
+// Middleware to check authorization in Redis session, with the session key in the cookie of the given name.
+// User ID is passed via context.
+func sessionMiddleware(sess session.Store, cookieName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Check session cookie.
+			sessionKey, err := r.Cookie(cookieName)
+			if err != nil {
+				sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "No session found. Please log in again!").WithErrorCode(ErrorCodeSessionNotFound))
+				return
+			}
+
+			// Check if session exists, and keep it alive while it is being used.
+			userID, err := sess.Touch(r.Context(), sessionKey.Value)
+			if err != nil {
+				sendFailureResponse(w, r, serverFailure(r, err))
+				return
+			}
+			if userID == "" {
+				sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "User with your session ID is not found! Please log in again!").WithErrorCode(ErrorCodeSessionNotFound))
+				return
+			}
+
+			// Allow next, pass user ID via context.
+			ctx := context.WithValue(r.Context(), ContextKey{}, userID)
+			next.ServeHTTP(w, r.Clone(ctx))
+		})
+	}
+}
+
+// Middleware to only allow admins to access a route. Must be used after 'sessionMiddleware'.
+func adminMiddleware(users UserStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The user ID is guaranteed to exist by the session middleware.
+			userID, _ := r.Context().Value(ContextKey{}).(string)
+			if !isAdmin(users, userID) {
+				sendFailureResponse(w, r, NewFailureResponse(http.StatusForbidden, "Only admins are allowed to access this route!"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware to authenticate the enrollment of a user, either with a pre-enrollment token or as an admin.
+// Requests with a bearer token do not carry cookies, so they do not need the CSRF protection of admin sessions.
+func enrollMiddleware(sess session.Store, options *options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		adminOnly := csrfMiddleware(sessionMiddleware(sess, options.cookieName)(adminMiddleware(options.users)(next)))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				adminOnly.ServeHTTP(w, r)
+				return
+			}
+
+			username, found := enrollTokenUsername(options.enrollTokens, token)
+			if !found {
+				sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Invalid enrollment token!").WithErrorCode(ErrorCodeInvalidEnrollToken))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), enrollUsernameKey{}, username)
+			next.ServeHTTP(w, r.Clone(ctx))
+		})
+	}
+}
+
+// Utility function to find the username of a pre-enrollment token. Every token is compared in constant time,
+// so the time taken does not reveal which tokens exist.
+func enrollTokenUsername(tokens map[string]string, token string) (string, bool) {
+	tokenHash := sha256.Sum256([]byte(token))
+	username, found := "", 0
+	for candidate, candidateUsername := range tokens {
+		candidateHash := sha256.Sum256([]byte(candidate))
+		if subtle.ConstantTimeCompare(tokenHash[:], candidateHash[:]) == 1 {
+			username, found = candidateUsername, 1
+		}
+	}
+
+	return username, found == 1
+}
+
+// Utility function to check if a user is an admin. The role is always looked up from the user store instead of the
+// session, so a demoted admin loses access immediately instead of when their sessions expire.
+func isAdmin(users UserStore, userID string) bool {
+	user, found := users.Get(userID)
+	return found && user.role() == RoleAdmin
+}
+
 // Configure is used to configure the application (server is initialized in 'main').
-func Configure(rdb *redis.Client) http.Handler {
+// The Redis client may be nil if the sessions are stored elsewhere with 'WithSessionStore'.
+func Configure(rdb *redis.Client, opts ...Option) *Application {
+	// Apply all of the passed options.
+	options := newOptions(opts...)
+
+	// Create a single session store, shared by all handlers. Sessions are stored in the Redis, unless a store is passed.
+	sess := options.sessionStore
+	if sess == nil {
+		sess = session.NewWithPrefix(rdb, options.sessionTTL, options.sessionPrefix)
+	}
+
 	// Create a Chi instance.
 	r := chi.NewRouter()
 
 	// Set up Chi's natural middlewares.
 	r.Use(middleware.RequestID)
+	r.Use(peerMiddleware)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	if options.logger != nil {
+		r.Use(structuredLoggerMiddleware(options.logger))
+	} else {
+		r.Use(middleware.Logger)
+	}
+	r.Use(recoverMiddleware(options.logger))
+	if options.requestTimeout > 0 {
+		r.Use(timeoutMiddleware(options.requestTimeout))
+	}
+	r.Use(corsMiddleware(options.cors))
+	if options.compress {
+		r.Use(middleware.Compress(5, "application/json"))
+	}
 
 	// Set up custom middleware.
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add("X-Application-Name", "Fullstack OTP")
 			w.Header().Add("Server", "net/http")
+			w.Header().Set("X-Request-Id", middleware.GetReqID(r.Context()))
 			next.ServeHTTP(w, r)
 		})
 	})
 
+	// Expose metrics, if enabled.
+	if options.metrics != nil {
+		r.Method(http.MethodGet, "/metrics", options.metrics.handler())
+	}
+
 	// Group routes.
 	r.Route("/api/v1", func(r chi.Router) {
+		// Negotiate content, if enabled.
+		if options.strictAccept {
+			r.Use(acceptMiddleware)
+		}
+
 		// Sample GET route.
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 			res := NewSuccessResponse(http.StatusOK, "Welcome to 'net/http' API!", nil)
-			sendSuccessResponse(w, res)
+			sendSuccessResponse(w, r, res)
+		})
+
+		// Time route, so clients are able to detect if their clocks are off, as it would make the TOTPs invalid.
+		r.Get("/time", func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now().UTC()
+			responseData := struct {
+				Unix int64  `json:"unix"`
+				ISO  string `json:"iso"`
+			}{
+				Unix: now.Unix(),
+				ISO:  now.Format(time.RFC3339),
+			}
+			sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Current time of the server.", responseData))
+		})
+
+		// Health check route, verifies that all of our dependencies are reachable. The route is public, so the errors
+		// are only logged, as they might contain internal addresses.
+		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+			if err := sess.Ping(r.Context()); err != nil {
+				log.Printf("error: health check failed: %v (request ID: %s)", err, middleware.GetReqID(r.Context()))
+				sendFailureResponse(w, r, NewFailureResponse(http.StatusServiceUnavailable, "Application is unhealthy! Please try again later!"))
+				return
+			}
+
+			responseData := struct {
+				Redis string `json:"redis"`
+			}{
+				Redis: "ok",
+			}
+			sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Application is healthy!", responseData))
 		})
 
 		// Subrouter: '/api/v1/auth'.
 		r.Route("/auth", func(r chi.Router) {
+			// Refuse plaintext HTTP, if enabled.
+			if options.requireHTTPS {
+				r.Use(httpsMiddleware(options.trustedProxies))
+			}
+
 			// Login route.
 			r.Post("/login", func(w http.ResponseWriter, r *http.Request) {
 				authRequestBody := &AuthRequestBody{}
-				failureResponse := decodeJSONBody(w, r, authRequestBody)
+				failureResponse := decodeJSONBody(w, r, authRequestBody, options.maxBodyBytes, options.strictJSON)
 				if failureResponse != nil {
-					sendFailureResponse(w, failureResponse)
+					sendFailureResponse(w, r, failureResponse)
+					return
+				}
+
+				// Report every invalid field at once.
+				if fieldErrors := authRequestBody.Validate(); fieldErrors != nil {
+					res := NewFailureResponse(http.StatusBadRequest, "Request body contains invalid fields!")
+					sendFailureResponse(w, r, res.WithErrorCode(ErrorCodeValidationFailed).WithFieldErrors(fieldErrors))
 					return
 				}
 
+				// Find the user. Unknown users are compared against an empty user, so the comparison still happens.
+				user, found := options.users.Get(authRequestBody.Username)
+				if !found {
+					user = &User{}
+				}
+
 				// Calculate SHA256 hash to prevent 'ConstantTimeCompare' leaking the length of passwords / usernames.
 				// SHA256 is used to quickly generate and verify the hashes - SHA512 would take a bit longer.
 				usernameHash := sha256.Sum256([]byte(authRequestBody.Username))
 				passwordHash := sha256.Sum256([]byte(authRequestBody.Password))
-				expectedUsernameHash := sha256.Sum256([]byte("kaede"))
-				expectedPasswordHash := sha256.Sum256([]byte("kaede"))
+				expectedUsernameHash := sha256.Sum256([]byte(user.Username))
+				expectedPasswordHash := sha256.Sum256([]byte(user.Password))
 
 				// Compare if username and passwords match.
-				// Let's claim that the username and password are 'OTP_EXPECTED_USERNAME/PASSWORD' for now.
 				usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
 				passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
-				if !usernameMatch || !passwordMatch {
-					sendFailureResponse(w, NewFailureResponse(http.StatusUnauthorized, "Username or password do not match!"))
+				if !found || !usernameMatch || !passwordMatch {
+					options.metrics.observeLogin(false)
+
+					// Unknown usernames are audited as the empty user, so they take as long as the known ones,
+					// while they still cannot fill the Redis.
+					audit(r, sess, user.Username, session.AuditTypeLogin, session.AuditOutcomeFailure)
+
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Username or password do not match!").WithErrorCode(ErrorCodeInvalidCredentials))
+					return
+				}
+
+				// Users with an unconfirmed enrollment do not have an active secret to generate the OTP with.
+				if !user.enrolled() {
+					options.metrics.observeLogin(false)
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusForbidden, "Please confirm your enrollment before logging in!").WithErrorCode(ErrorCodeNotEnrolled))
 					return
 				}
 
 				// After this, we should check Redis and verify if there is a cache with this user.
-				// If not, simply send them an OTP. The secret, same as above, is 'kaedeKIMURA' for now.
-				// 'KIMURA' is the shared secret, 'kaede' is the username. We concatenate them together.
-				sharedSecret := base32.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%s", authRequestBody.Username, "KIMURA")))
-				otp, err := totp.GenerateCodeCustom(sharedSecret, time.Now(), totp.ValidateOpts{
-					Period:    30,
-					Skew:      1,
-					Digits:    10,
-					Algorithm: otp.AlgorithmSHA512,
-				})
+				// If not, simply send them an OTP, generated with the user's own secret, digits, and algorithm.
+				sharedSecret := user.Secret
+				validateOpts := options.otp.validateOptsFor(user)
+				generatedAt := time.Now()
+				otp, err := totp.GenerateCodeCustom(sharedSecret, generatedAt, validateOpts)
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusBadRequest, err.Error()))
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, err.Error()))
+					return
+				}
+
+				// Repeated logins with the same idempotency key, such as double clicks, get the same OTP until the end of
+				// its period, after which a new OTP is generated anyway. Replayed OTPs are neither counted nor sent again.
+				replayed := false
+				idempotencyKey := r.Header.Get("Idempotency-Key")
+				if idempotencyKey != "" {
+					if len(idempotencyKey) > maxIdempotencyKeyLength {
+						errorMessage := fmt.Sprintf("The 'Idempotency-Key' header must not be longer than %d characters!", maxIdempotencyKeyLength)
+						sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, errorMessage).WithErrorCode(ErrorCodeValidationFailed))
+						return
+					}
+
+					ttl := time.Duration(rfcotp.SecondsRemaining(generatedAt.Unix(), int64(validateOpts.Period))) * time.Second
+					otp, replayed, err = sess.RememberOTP(r.Context(), user.Username, idempotencyKey, otp, ttl)
+					if err != nil {
+						sendFailureResponse(w, r, serverFailure(r, err))
+						return
+					}
+				}
+				if !replayed {
+					options.metrics.observeOTPGenerated()
+				}
+
+				audit(r, sess, user.Username, session.AuditTypeLogin, session.AuditOutcomeSuccess)
+
+				// Anonymous struct. Fields containing the OTP are omitted if the OTP is delivered out-of-band.
+				responseData := struct {
+					OTP              string `json:"otp,omitempty"`
+					Username         string `json:"user"`
+					BasicAuthContent string `json:"basicAuth,omitempty"`
+					DecodedBasicAuth string `json:"decodedBasic,omitempty"`
+					SharedSecret     string `json:"sharedSecret,omitempty"`
+					LoginTime        int64  `json:"loginTime"`
+				}{
+					Username:  authRequestBody.Username,
+					LoginTime: time.Now().Unix(),
+				}
+
+				// Deliver the OTP out-of-band, if a notifier is set.
+				if !isDevNotifier(options.notifier) {
+					if !replayed {
+						if err := options.notifier.Send(r.Context(), authRequestBody.Username, otp); err != nil {
+							// Forget the OTP, so a retry with the same idempotency key sends it again.
+							if idempotencyKey != "" {
+								if err := sess.ForgetOTP(r.Context(), user.Username, idempotencyKey); err != nil {
+									log.Printf("error: failed to forget the OTP of %q: %v (request ID: %s)", user.Username, err, middleware.GetReqID(r.Context()))
+								}
+							}
+
+							sendFailureResponse(w, r, serverFailure(r, err))
+							return
+						}
+					}
+
+					options.metrics.observeLogin(true)
+					sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Sucessfully logged in! Your OTP has been sent!", responseData))
 					return
 				}
 
@@ -218,177 +800,648 @@ func Configure(rdb *redis.Client) http.Handler {
 				basicAuthContent := fmt.Sprintf("%s%s", "Basic ", basicAuthInformation)
 				decodedBasicAuth, err := base64.StdEncoding.DecodeString(basicAuthInformation)
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusInternalServerError, err.Error()))
+					sendFailureResponse(w, r, serverFailure(r, err))
 					return
 				}
 
-				// Anonymous struct.
+				responseData.OTP = otp
+				responseData.BasicAuthContent = basicAuthContent
+				responseData.DecodedBasicAuth = string(decodedBasicAuth)
+				responseData.SharedSecret = sharedSecret
+				options.metrics.observeLogin(true)
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Sucessfully logged in!", responseData))
+			})
+
+			// Verification route.
+			r.Post("/verification", func(w http.ResponseWriter, r *http.Request) {
+				// Get the username and the OTP, either from a JSON body or from the Authorization Header.
+				// Both of them go through the same validations below.
+				var username, password string
+				if isJSONContentType(r.Header.Get("Content-Type")) {
+					verificationRequestBody := &VerificationRequestBody{}
+					failureResponse := decodeJSONBody(w, r, verificationRequestBody, options.maxBodyBytes, options.strictJSON)
+					if failureResponse != nil {
+						sendFailureResponse(w, r, failureResponse)
+						return
+					}
+					username, password = verificationRequestBody.Username, verificationRequestBody.OTP
+				} else {
+					var ok bool
+					username, password, ok = r.BasicAuth()
+					if !ok {
+						w.Header().Set("WWW-Authenticate", basicAuthChallenge(options.realm))
+						sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Please provide an 'Authorization' header!").WithErrorCode(ErrorCodeMissingAuthorization))
+						return
+					}
+				}
+
+				// Trim the OTP once, just like it is validated, so every check below sees the same OTP, and it cannot be
+				// replayed or pass the length checks by padding it with whitespaces.
+				password = strings.TrimSpace(password)
+
+				// Reject obviously invalid OTPs early, so huge inputs do not waste any resources.
+				if len(password) > maxPasscodeLength {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "Your OTP does not conform to the length requirements of the validation server!").WithErrorCode(ErrorCodeInvalidOTPLength))
+					return
+				}
+
+				// Find the user. Unknown users are compared against a dummy user, so the comparison still happens.
+				user, found := options.users.Get(username)
+				if !found {
+					user = dummyUser(options.otp)
+				}
+
+				// Calculate SHA256 of the 'username', and check if the username is valid in constant time.
+				usernameHash := sha256.Sum256([]byte(username))
+				expectedUsernameHash := sha256.Sum256([]byte(user.Username))
+				usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+				if !found || !usernameMatch || !user.enrolled() {
+					// Perform a dummy validation, so unknown usernames take as long as the known ones.
+					options.validateOTP(password, user.Secret, time.Now(), options.otp.validateOptsFor(user))
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Username does not match with the database!").WithErrorCode(ErrorCodeInvalidCredentials))
+					return
+				}
+
+				// Locked accounts cannot log in, even with a valid OTP, and the attempts of the user are limited.
+				if !allowAttempt(w, r, sess, options, username) {
+					return
+				}
+
+				// Reject OTPs that are too short with a specific message, as users might still use legacy, shorter OTPs.
+				if digits := options.otp.validateOptsFor(user).Digits.Length(); len(password) < digits {
+					errorMessage := fmt.Sprintf("OTP too short, expected %d digits!", digits)
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, errorMessage).WithErrorCode(ErrorCodeInvalidOTPLength))
+					return
+				}
+
+				// Verify OTP with the user's own secret, digits, and algorithm, only after the username matches.
+				sharedSecret := user.Secret
+				validOTP, offset, err := options.validateOTP(password, sharedSecret, time.Now(), options.otp.validateOptsFor(user))
+				if err != nil && err == otp.ErrValidateInputInvalidLength {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "Your OTP does not conform to the length requirements of the validation server!").WithErrorCode(ErrorCodeInvalidOTPLength))
+					return
+				}
+				if err != nil {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, err.Error()))
+					return
+				}
+				options.metrics.observeOTPVerified(validOTP)
+
+				// Check if OTP is valid. Lock the account after too many failures, if enabled.
+				if !validOTP {
+					audit(r, sess, username, session.AuditTypeVerification, session.AuditOutcomeFailure)
+
+					if options.lockout.MaxFailures > 0 {
+						if err := recordFailure(r.Context(), sess, options.lockout, username); err != nil {
+							sendFailureResponse(w, r, serverFailure(r, err))
+							return
+						}
+					}
+
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Invalid token, wrong TOTP code!").WithErrorCode(ErrorCodeInvalidOTP))
+					return
+				}
+
+				// In dry-run mode, only report the validity, so the OTP can still be used for a real verification afterwards.
+				if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun")); dryRun {
+					blacklistedOTP, err := sess.CheckBlacklistOTP(r.Context(), username, password)
+					if err != nil {
+						sendFailureResponse(w, r, serverFailure(r, err))
+						return
+					}
+					if blacklistedOTP {
+						sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "The OTP that you entered has been used before!").WithErrorCode(ErrorCodeOTPReused))
+						return
+					}
+
+					responseData := struct {
+						User     string `json:"user"`
+						ValidOTP bool   `json:"validOTP"`
+						DryRun   bool   `json:"dryRun"`
+					}{
+						User:     username,
+						ValidOTP: validOTP,
+						DryRun:   true,
+					}
+
+					sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "OTP and user are valid!", responseData))
+					return
+				}
+
+				// Blacklist the OTP, unless it has been used before. Checking and blacklisting happen atomically,
+				// so concurrent requests with the same OTP cannot both pass.
+				claimedOTP, err := sess.ClaimOTP(r.Context(), username, password, options.otp.Period, options.otp.Skew)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+				if !claimedOTP {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "The OTP that you entered has been used before!").WithErrorCode(ErrorCodeOTPReused))
+					return
+				}
+
+				// Set user cache and cookies.
+				sessionKey, err := startSession(w, r, sess, options, user)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				audit(r, sess, username, session.AuditTypeVerification, session.AuditOutcomeSuccess)
+
+				// Forget the failures of the user, so only consecutive failures lock the account.
+				if options.lockout.MaxFailures > 0 {
+					if err := sess.ResetFailures(r.Context(), username); err != nil {
+						sendFailureResponse(w, r, serverFailure(r, err))
+						return
+					}
+				}
+
+				// If successful, dump the user data and everything.
 				responseData := struct {
 					OTP              string `json:"otp"`
-					Username         string `json:"user"`
-					BasicAuthContent string `json:"basicAuth"`
-					DecodedBasicAuth string `json:"decodedBasic"`
+					User             string `json:"user"`
+					OK               bool   `json:"ok"`
+					ValidOTP         bool   `json:"validOTP"`
 					SharedSecret     string `json:"sharedSecret"`
-					LoginTime        int64  `json:"loginTime"`
+					SessionKey       string `json:"sessionKey"`
+					VerifyTime       int64  `json:"verifyTime"`
+					ClockSkewSeconds int64  `json:"clockSkewSeconds,omitempty"`
 				}{
-					OTP:              otp,
-					Username:         authRequestBody.Username,
-					BasicAuthContent: basicAuthContent,
-					DecodedBasicAuth: string(decodedBasicAuth),
+					OTP:              password,
+					User:             username,
+					OK:               true,
+					ValidOTP:         validOTP,
 					SharedSecret:     sharedSecret,
-					LoginTime:        time.Now().Unix(),
+					SessionKey:       sessionKey,
+					VerifyTime:       time.Now().Unix(),
+					ClockSkewSeconds: offset * int64(options.otp.validateOptsFor(user).Period),
 				}
-				sendSuccessResponse(w, NewSuccessResponse(http.StatusOK, "Sucessfully logged in!", responseData))
+
+				// Send back response.
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "OTP and user successfully verified!", responseData))
 			})
 
-			// Verification route.
-			r.Post("/verification", func(w http.ResponseWriter, r *http.Request) {
+			// Recovery route, for users who lost their authenticator. A backup code is used instead of an OTP.
+			r.Post("/recovery", func(w http.ResponseWriter, r *http.Request) {
 				// Get the Authorization Header.
-				username, password, ok := r.BasicAuth()
+				username, backupCode, ok := r.BasicAuth()
 				if !ok {
-					w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
-					sendFailureResponse(w, NewFailureResponse(http.StatusUnauthorized, "Please provide an 'Authorization' header!"))
+					w.Header().Set("WWW-Authenticate", basicAuthChallenge(options.realm))
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Please provide an 'Authorization' header!").WithErrorCode(ErrorCodeMissingAuthorization))
 					return
 				}
 
-				// Calculate SHA256 of the 'username'.
+				// Find the user, and check if the username is valid in constant time.
+				user, found := options.users.Get(username)
+				if !found {
+					user = dummyUser(options.otp)
+				}
 				usernameHash := sha256.Sum256([]byte(username))
-				expectedUsernameHash := sha256.Sum256([]byte("kaede"))
-
-				// Verify OTP.
-				sharedSecret := base32.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%sKIMURA", username)))
-				validOTP, err := totp.ValidateCustom(password, sharedSecret, time.Now(), totp.ValidateOpts{
-					Period:    30,
-					Skew:      1,
-					Digits:    10,
-					Algorithm: otp.AlgorithmSHA512,
-				})
-				if err != nil && err == otp.ErrValidateInputInvalidLength {
-					sendFailureResponse(w, NewFailureResponse(http.StatusBadRequest, "Your OTP does not conform to the length requirements of the validation server!"))
+				expectedUsernameHash := sha256.Sum256([]byte(user.Username))
+				usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+				validUser := found && usernameMatch && user.enrolled()
+
+				// Invalid users consume a backup code of the dummy user, which has none, so the hashing and the round trip
+				// to the store are still performed.
+				if !validUser {
+					if _, err := sess.ConsumeBackupCode(r.Context(), dummyUser(options.otp).Username, strings.TrimSpace(backupCode)); err != nil {
+						sendFailureResponse(w, r, serverFailure(r, err))
+						return
+					}
+
+					audit(r, sess, user.Username, session.AuditTypeRecovery, session.AuditOutcomeFailure)
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Username does not match with the database!").WithErrorCode(ErrorCodeInvalidCredentials))
+					return
+				}
+
+				// Backup codes are a second factor as well, so they are limited just like the OTPs.
+				if !allowAttempt(w, r, sess, options, username) {
 					return
 				}
+
+				// Consume the backup code, so it cannot be used twice. Lock the account after too many failures, if enabled.
+				consumed, err := sess.ConsumeBackupCode(r.Context(), user.Username, strings.TrimSpace(backupCode))
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusBadRequest, err.Error()))
+					sendFailureResponse(w, r, serverFailure(r, err))
 					return
 				}
+				if !consumed {
+					audit(r, sess, username, session.AuditTypeRecovery, session.AuditOutcomeFailure)
 
-				// Check if OTP and username are valid.
-				usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
-				if !usernameMatch {
-					sendFailureResponse(w, NewFailureResponse(http.StatusUnauthorized, "Username does not match with the database!"))
+					if options.lockout.MaxFailures > 0 {
+						if err := recordFailure(r.Context(), sess, options.lockout, username); err != nil {
+							sendFailureResponse(w, r, serverFailure(r, err))
+							return
+						}
+					}
+
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Invalid or already used backup code!").WithErrorCode(ErrorCodeInvalidBackupCode))
 					return
 				}
-				if !validOTP {
-					sendFailureResponse(w, NewFailureResponse(http.StatusUnauthorized, "Invalid token, wrong TOTP code!"))
+
+				// Set user cache and cookies.
+				sessionKey, err := startSession(w, r, sess, options, user)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
 					return
 				}
 
-				// Check if OTP is blacklisted.
-				sess := session.New(rdb, time.Minute*15)
-				blacklistedOTP, err := sess.CheckBlacklistOTP(password)
+				audit(r, sess, username, session.AuditTypeRecovery, session.AuditOutcomeSuccess)
+
+				// Forget the failures of the user, so only consecutive failures lock the account.
+				if options.lockout.MaxFailures > 0 {
+					if err := sess.ResetFailures(r.Context(), username); err != nil {
+						sendFailureResponse(w, r, serverFailure(r, err))
+						return
+					}
+				}
+
+				responseData := struct {
+					User       string `json:"user"`
+					SessionKey string `json:"sessionKey"`
+				}{
+					User:       username,
+					SessionKey: sessionKey,
+				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "User successfully recovered with a backup code!", responseData))
+			})
+
+			// Validation route, for API gateways to check a session cookie before proxying a request.
+			// Unlike the session middleware, the session is not refreshed, and no cookies are set.
+			r.Get("/validate", func(w http.ResponseWriter, r *http.Request) {
+				sessionKey, err := r.Cookie(options.cookieName)
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusInternalServerError, err.Error()))
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "No session found. Please log in again!").WithErrorCode(ErrorCodeSessionNotFound))
 					return
 				}
-				if blacklistedOTP {
-					sendFailureResponse(w, NewFailureResponse(http.StatusBadRequest, "The OTP that you entered has been used before!"))
+
+				userID, err := sess.Get(r.Context(), sessionKey.Value)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
 					return
 				}
+				if userID == "" {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "User with your session ID is not found! Please log in again!").WithErrorCode(ErrorCodeSessionNotFound))
+					return
+				}
+
+				responseData := struct {
+					User string `json:"user"`
+				}{
+					User: userID,
+				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Session is valid!", responseData))
+			})
 
-				// Blacklist OTP.
-				err = sess.BlacklistOTP(password)
+			// Refresh route, to keep the session of an active user alive without logging in again.
+			// Unlike the other authenticated routes, a missing session means the client is no longer authorized.
+			r.With(csrfMiddleware).Post("/refresh", func(w http.ResponseWriter, r *http.Request) {
+				sessionKey, err := r.Cookie(options.cookieName)
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusInternalServerError, err.Error()))
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "No session found. Please log in again!").WithErrorCode(ErrorCodeSessionNotFound))
 					return
 				}
 
-				// Set user cache.
-				sessionKey, err := session.GenerateSessionID(32)
+				// Refresh the session in the Redis, and check if it still exists.
+				userID, err := sess.Touch(r.Context(), sessionKey.Value)
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusInternalServerError, err.Error()))
+					sendFailureResponse(w, r, serverFailure(r, err))
 					return
 				}
+				if userID == "" {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "User with your session ID is not found! Please log in again!").WithErrorCode(ErrorCodeSessionNotFound))
+					return
+				}
+
+				// Refresh the cookies. CSRF cookie always exists, as it has been checked by the middleware.
+				csrfToken, _ := r.Cookie("csrf")
+				expiresAt := setSessionCookies(w, options, sessionKey.Value, csrfToken.Value)
+
+				responseData := struct {
+					ExpiresAt time.Time `json:"expiresAt"`
+				}{
+					ExpiresAt: expiresAt,
+				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Session successfully refreshed!", responseData))
+			})
 
-				err = sess.Set(sessionKey, username)
+			// Secret rotation route, for authenticated users who suspect their secret is compromised.
+			r.With(csrfMiddleware, sessionMiddleware(sess, options.cookieName)).Post("/secret/rotate", func(w http.ResponseWriter, r *http.Request) {
+				userID := r.Context().Value(ContextKey{}).(string)
+				user, found := options.users.Get(userID)
+				if !found {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusNotFound, "User with your session ID is not found!").WithErrorCode(ErrorCodeUserNotFound))
+					return
+				}
+
+				// Mint a new secret. The user is copied, as it might be read by other requests at the same time.
+				secret, err := rfcotp.NewSecret()
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusInternalServerError, err.Error()))
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+				rotatedUser := *user
+				rotatedUser.Secret = secret
+
+				// Store the new secret, so the old one stops validating immediately.
+				if err := options.users.Save(&rotatedUser); err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				// Old backup codes belong to the old enrollment, so they are invalidated.
+				if err := sess.StoreBackupCodes(r.Context(), userID, nil); err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				uri, err := provisioningURI(options, &rotatedUser)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
 					return
 				}
 
-				// If successful, dump the user data and everything.
 				responseData := struct {
-					OTP          string `json:"otp"`
-					User         string `json:"user"`
-					OK           bool   `json:"ok"`
-					ValidOTP     bool   `json:"validOTP"`
-					SharedSecret string `json:"sharedSecret"`
-					SessionKey   string `json:"sessionKey"`
-					VerifyTime   int64  `json:"verifyTime"`
+					URI string `json:"uri"`
 				}{
-					OTP:          password,
-					User:         username,
-					OK:           ok,
-					ValidOTP:     validOTP,
-					SharedSecret: sharedSecret,
-					SessionKey:   sessionKey,
-					VerifyTime:   time.Now().Unix(),
+					URI: uri,
 				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Secret successfully rotated! Please enroll your authenticator again!", responseData))
+			})
 
-				// Send back response.
-				http.SetCookie(w, &http.Cookie{
-					Name:     "sess",
-					Value:    sessionKey,
-					Path:     "/",
-					Expires:  time.Now().Add(15 * time.Minute),
-					HttpOnly: true,
-				})
-				sendSuccessResponse(w, NewSuccessResponse(http.StatusOK, "OTP and user successfully verified!", responseData))
+			// Enrollment route, for admins to create new users. The secret is not active until the user confirms it.
+			r.With(enrollMiddleware(sess, options)).Post("/enroll", func(w http.ResponseWriter, r *http.Request) {
+				authRequestBody := &AuthRequestBody{}
+				failureResponse := decodeJSONBody(w, r, authRequestBody, options.maxBodyBytes, options.strictJSON)
+				if failureResponse != nil {
+					sendFailureResponse(w, r, failureResponse)
+					return
+				}
+
+				// Report every invalid field at once.
+				if fieldErrors := authRequestBody.Validate(); fieldErrors != nil {
+					res := NewFailureResponse(http.StatusBadRequest, "Request body contains invalid fields!")
+					sendFailureResponse(w, r, res.WithErrorCode(ErrorCodeValidationFailed).WithFieldErrors(fieldErrors))
+					return
+				}
+
+				// Pre-enrollment tokens are only able to enroll the username they are issued for.
+				if username, ok := r.Context().Value(enrollUsernameKey{}).(string); ok && username != authRequestBody.Username {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusForbidden, "This enrollment token is not issued for this username!").WithErrorCode(ErrorCodeInvalidEnrollToken))
+					return
+				}
+
+				// Existing users should rotate their secrets instead.
+				if _, found := options.users.Get(authRequestBody.Username); found {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusConflict, "User with the same username already exists!").WithErrorCode(ErrorCodeUserExists))
+					return
+				}
+
+				// Mint a new secret, following the OTP options of the application.
+				algorithm, err := rfcotp.ParseAlgorithm(options.otp.Algorithm.String())
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+				secret, uri, backupCodes, err := rfcotp.EnrollWithBackupCodes("Fullstack OTP", authRequestBody.Username, rfcotp.TOTPConfig{
+					Period:    int64(options.otp.Period),
+					Digits:    options.otp.Digits.Length(),
+					Algorithm: algorithm,
+				}, options.backupCodes)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				// Store the user with a pending secret, so they cannot log in until the enrollment is confirmed.
+				// The user is created atomically, so a concurrent enrollment of the same username is rejected as well.
+				user := &User{
+					Username:      authRequestBody.Username,
+					Password:      authRequestBody.Password,
+					PendingSecret: secret,
+					Digits:        options.otp.Digits,
+					Algorithm:     options.otp.Algorithm,
+				}
+				err = options.users.Create(user)
+				if errors.Is(err, ErrUserExists) {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusConflict, "User with the same username already exists!").WithErrorCode(ErrorCodeUserExists))
+					return
+				}
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				hashes := make([]string, len(backupCodes))
+				for i, code := range backupCodes {
+					hashes[i] = session.HashBackupCode(code)
+				}
+				if err := sess.StoreBackupCodes(r.Context(), user.Username, hashes); err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				responseData := struct {
+					Username    string   `json:"user"`
+					Secret      string   `json:"secret"`
+					URI         string   `json:"uri"`
+					BackupCodes []string `json:"backupCodes"`
+				}{
+					Username:    user.Username,
+					Secret:      secret,
+					URI:         uri,
+					BackupCodes: backupCodes,
+				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusCreated, "User successfully enrolled! Please confirm the enrollment with an OTP!", responseData))
 			})
-		})
 
-		// Subrouter: '/api/v1/sessions'.
-		r.Route("/sessions", func(r chi.Router) {
-			sess := session.New(rdb, time.Minute*15)
+			// Enrollment confirmation route. The pending secret is activated only after the user proves a valid OTP,
+			// so users are not locked out by an authenticator app that is configured incorrectly.
+			r.Post("/enroll/confirm", func(w http.ResponseWriter, r *http.Request) {
+				confirmRequestBody := &EnrollConfirmRequestBody{}
+				failureResponse := decodeJSONBody(w, r, confirmRequestBody, options.maxBodyBytes, options.strictJSON)
+				if failureResponse != nil {
+					sendFailureResponse(w, r, failureResponse)
+					return
+				}
 
-			// Check authorization in Redis session.
-			r.Use(func(next http.Handler) http.Handler {
-				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					// Check session cookie.
-					sessionKey, err := r.Cookie("sess")
-					if err != nil {
-						sendFailureResponse(w, NewFailureResponse(http.StatusBadRequest, "No session found. Please log in again!"))
+				// Report every invalid field at once.
+				if fieldErrors := confirmRequestBody.Validate(); fieldErrors != nil {
+					res := NewFailureResponse(http.StatusBadRequest, "Request body contains invalid fields!")
+					sendFailureResponse(w, r, res.WithErrorCode(ErrorCodeValidationFailed).WithFieldErrors(fieldErrors))
+					return
+				}
+
+				// Find the user, and check the credentials in constant time, just like the login route.
+				user, found := options.users.Get(confirmRequestBody.Username)
+				if !found {
+					user = &User{}
+				}
+				usernameHash := sha256.Sum256([]byte(confirmRequestBody.Username))
+				passwordHash := sha256.Sum256([]byte(confirmRequestBody.Password))
+				expectedUsernameHash := sha256.Sum256([]byte(user.Username))
+				expectedPasswordHash := sha256.Sum256([]byte(user.Password))
+				usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+				passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
+				if !found || !usernameMatch || !passwordMatch {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Username or password do not match!").WithErrorCode(ErrorCodeInvalidCredentials))
+					return
+				}
+
+				if user.PendingSecret == "" {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusConflict, "There is no pending enrollment to confirm!").WithErrorCode(ErrorCodeAlreadyEnrolled))
+					return
+				}
+
+				// Guesses of the pending secret are limited, just like the verification route.
+				if !allowAttempt(w, r, sess, options, user.Username) {
+					return
+				}
+
+				// Verify the OTP with the pending secret. Nothing changes if it is invalid, so the user is able to retry.
+				validOTP, _, err := options.validateOTP(confirmRequestBody.Code, user.PendingSecret, time.Now(), options.otp.validateOptsFor(user))
+				if err != nil || !validOTP {
+					if options.lockout.MaxFailures > 0 {
+						if err := recordFailure(r.Context(), sess, options.lockout, user.Username); err != nil {
+							sendFailureResponse(w, r, serverFailure(r, err))
+							return
+						}
+					}
+
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "Invalid token, wrong TOTP code!").WithErrorCode(ErrorCodeInvalidOTP))
+					return
+				}
+
+				// Activate the secret. The user is copied, as it might be read by other requests at the same time.
+				activatedUser := *user
+				activatedUser.Secret = user.PendingSecret
+				activatedUser.PendingSecret = ""
+				if err := options.users.Save(&activatedUser); err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				// Forget the failures of the user, so only consecutive failures lock the account.
+				if options.lockout.MaxFailures > 0 {
+					if err := sess.ResetFailures(r.Context(), user.Username); err != nil {
+						sendFailureResponse(w, r, serverFailure(r, err))
 						return
 					}
+				}
 
-					// Check if session exists.
-					userID, err := sess.Get(sessionKey.Value)
-					if userID == "" {
-						sendFailureResponse(w, NewFailureResponse(http.StatusBadRequest, "User with your session ID is not found! Please log in again!"))
+				responseData := struct {
+					Username string `json:"user"`
+				}{
+					Username: activatedUser.Username,
+				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Enrollment successfully confirmed! You are now able to log in!", responseData))
+			})
+
+			// Audit route, so users are able to spot suspicious authentication attempts on their own account.
+			r.With(sessionMiddleware(sess, options.cookieName)).Get("/audit", func(w http.ResponseWriter, r *http.Request) {
+				userID := r.Context().Value(ContextKey{}).(string)
+
+				// Parse the requested number of events. Larger limits are clamped, as only so many events are kept.
+				limit := defaultAuditLimit
+				if limitQuery := r.URL.Query().Get("limit"); limitQuery != "" {
+					parsedLimit, err := strconv.Atoi(limitQuery)
+					if err != nil || parsedLimit < 1 {
+						sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, "Limit must be a positive number!"))
 						return
 					}
-					if err != nil {
-						sendFailureResponse(w, NewFailureResponse(http.StatusInternalServerError, err.Error()))
+					limit = parsedLimit
+				}
+				if limit > maxAuditLimit {
+					limit = maxAuditLimit
+				}
+
+				events, err := sess.GetAudit(r.Context(), userID, limit)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				responseData := struct {
+					User   string               `json:"user"`
+					Events []session.AuditEvent `json:"events"`
+				}{
+					User:   userID,
+					Events: events,
+				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Your most recent authentication events.", responseData))
+			})
+
+			// QR code route for enrollment, only for authenticated users.
+			r.With(sessionMiddleware(sess, options.cookieName)).Get("/qr", func(w http.ResponseWriter, r *http.Request) {
+				userID := r.Context().Value(ContextKey{}).(string)
+				user, found := options.users.Get(userID)
+				if !found {
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusNotFound, "User with your session ID is not found!").WithErrorCode(ErrorCodeUserNotFound))
+					return
+				}
+
+				// Parse the requested size of the QR code.
+				size := defaultQRCodeSize
+				if sizeQuery := r.URL.Query().Get("size"); sizeQuery != "" {
+					parsedSize, err := strconv.Atoi(sizeQuery)
+					if err != nil || parsedSize < minQRCodeSize {
+						errorMessage := fmt.Sprintf("Size of the QR code must be a number that is at least %d pixels!", minQRCodeSize)
+						sendFailureResponse(w, r, NewFailureResponse(http.StatusBadRequest, errorMessage))
 						return
 					}
+					size = parsedSize
+				}
+				if size > maxQRCodeSize {
+					size = maxQRCodeSize
+				}
 
-					// Allow next, pass user ID via context.
-					ctx := context.WithValue(r.Context(), ContextKey{}, userID)
-					next.ServeHTTP(w, r.Clone(ctx))
-				})
+				// Build the provisioning URI of the user.
+				uri, err := provisioningURI(options, user)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				// Render the URI as a PNG QR code.
+				qrCode, err := renderQRCode(uri, size)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				w.Header().Set("Content-Type", "image/png")
+				w.WriteHeader(http.StatusOK)
+				w.Write(qrCode)
 			})
+		})
+
+		// Subrouter: '/api/v1/sessions'.
+		r.Route("/sessions", func(r chi.Router) {
+			// Protect state-changing requests from CSRF.
+			r.Use(csrfMiddleware)
+
+			// Check authorization in Redis session.
+			r.Use(sessionMiddleware(sess, options.cookieName))
 
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 				// Get context and parse the value.
 				userID := r.Context().Value(ContextKey{}).(string)
 				if userID == "" {
-					sendFailureResponse(w, NewFailureResponse(http.StatusUnauthorized, "You are unauthorized to access this route!"))
+					sendFailureResponse(w, r, NewFailureResponse(http.StatusUnauthorized, "You are unauthorized to access this route!"))
 					return
 				}
 
-				// Get all sessions.
-				keys, err := sess.All()
+				// Admins get all sessions. Other users only get their own, so other users' sessions are not leaked.
+				var keys []session.SessionInfo
+				var err error
+				if isAdmin(options.users, userID) {
+					keys, err = sess.All(r.Context())
+				} else {
+					keys, err = sess.AllForUser(r.Context(), userID)
+				}
 				if err != nil {
-					sendFailureResponse(w, NewFailureResponse(http.StatusInternalServerError, err.Error()))
+					sendFailureResponse(w, r, serverFailure(r, err))
 					return
 				}
 
@@ -400,25 +1453,54 @@ func Configure(rdb *redis.Client) http.Handler {
 					KeyAndUsers: keys,
 					UserID:      userID,
 				}
-				sendSuccessResponse(w, NewSuccessResponse(http.StatusOK, "All of the sessions in the application.", resp))
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "All of the sessions you are allowed to see in the application.", resp))
+			})
+
+			// Get the details of the current session only, without fetching every other session.
+			r.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+				// The session cookie is guaranteed to exist by the middleware.
+				sessionKey, _ := r.Cookie(options.cookieName)
+				info, err := sess.Info(r.Context(), sessionKey.Value)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				ttl, err := sess.GetTTL(r.Context(), sessionKey.Value)
+				if err != nil {
+					sendFailureResponse(w, r, serverFailure(r, err))
+					return
+				}
+
+				resp := struct {
+					session.SessionInfo
+					TTL int64 `json:"ttl"`
+				}{
+					SessionInfo: info,
+					TTL:         int64(ttl.Seconds()),
+				}
+				sendSuccessResponse(w, r, NewSuccessResponse(http.StatusOK, "Details of your current session.", resp))
 			})
 		})
 
 		// Declare method not allowed as a fallback.
 		r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+			methods := allowedMethods(r)
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+
 			errorMessage := fmt.Sprintf("Method '%s' is not allowed in this route!", r.Method)
-			res := NewFailureResponse(http.StatusMethodNotAllowed, errorMessage)
-			sendFailureResponse(w, res)
+			res := NewFailureResponse(http.StatusMethodNotAllowed, errorMessage).WithAllowedMethods(methods)
+			sendFailureResponse(w, r, res)
 		})
 
 		// Declare 404 every time a request reaches here.
 		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 			errorMessage := fmt.Sprintf("Route '%s' with method '%s' does not exist in this server!", r.RequestURI, r.Method)
 			res := NewFailureResponse(http.StatusNotFound, errorMessage)
-			sendFailureResponse(w, res)
+			sendFailureResponse(w, r, res)
 		})
 	})
 
 	// Return our configured infrastructure.
-	return r
+	return &Application{handler: r, store: sess}
 }
@@ -2,17 +2,29 @@
 package application
 
 import (
+	"context"
 	"encoding/base32"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+	"github.com/lauslim12/fullstack-otp/internal/config"
+	rfcotp "github.com/lauslim12/fullstack-otp/internal/otp"
+	"github.com/lauslim12/fullstack-otp/internal/session"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
@@ -32,6 +44,45 @@ func initializeTestRedis() *redis.Client {
 	return client
 }
 
+// Generate a valid OTP for the default user.
+func generateTestOTP(otpOptions OTPOptions) string {
+	user := defaultUser(otpOptions)
+	code, err := totp.GenerateCodeCustom(user.Secret, time.Now(), otpOptions.validateOptsFor(user))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return code
+}
+
+// Verify the default user, and return the session cookie.
+func verifyTestUser(handler http.Handler) *http.Cookie {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+	w := httptest.NewRecorder()
+	r.SetBasicAuth("kaede", generateTestOTP(DefaultOTPOptions()))
+	handler.ServeHTTP(w, r)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "sess" {
+			return cookie
+		}
+	}
+
+	log.Fatal("Session cookie is not found!")
+	return nil
+}
+
+// Remove the request ID from a JSON response body, as it is different for every request.
+func withoutRequestID(body string) string {
+	response := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		log.Fatal(err.Error())
+	}
+	delete(response, "requestId")
+
+	return structToJSON(response)
+}
+
 func structToJSON(object interface{}) string {
 	out, err := json.Marshal(object)
 	if err != nil {
@@ -66,7 +117,7 @@ func TestGeneralHandlers(t *testing.T) {
 			method:         http.MethodDelete,
 			route:          "/api/v1",
 			expectedStatus: http.StatusMethodNotAllowed,
-			expectedBody:   NewFailureResponse(http.StatusMethodNotAllowed, "Method 'DELETE' is not allowed in this route!"),
+			expectedBody:   NewFailureResponse(http.StatusMethodNotAllowed, "Method 'DELETE' is not allowed in this route!").WithAllowedMethods([]string{http.MethodGet}),
 		},
 	}
 
@@ -94,7 +145,7 @@ func TestGeneralHandlers(t *testing.T) {
 
 			assert.NotNil(t, w.Body)
 			assert.Equal(t, tt.expectedStatus, w.Code)
-			assert.JSONEq(t, structToJSON(tt.expectedBody), w.Body.String())
+			assert.JSONEq(t, structToJSON(tt.expectedBody), withoutRequestID(w.Body.String()))
 		})
 	}
 
@@ -106,11 +157,90 @@ func TestGeneralHandlers(t *testing.T) {
 
 			assert.NotNil(t, w.Body)
 			assert.Equal(t, tt.expectedStatus, w.Code)
-			assert.JSONEq(t, structToJSON(tt.expectedBody), w.Body.String())
+			assert.JSONEq(t, structToJSON(tt.expectedBody), withoutRequestID(w.Body.String()))
 		})
 	}
 }
 
+func TestMethodNotAllowed(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+	tests := []struct {
+		name            string
+		method          string
+		route           string
+		expectedMethods []string
+	}{
+		{name: "test_delete_root", method: http.MethodDelete, route: "/api/v1", expectedMethods: []string{http.MethodGet}},
+		{name: "test_get_login", method: http.MethodGet, route: "/api/v1/auth/login", expectedMethods: []string{http.MethodPost}},
+		{name: "test_post_qr", method: http.MethodPost, route: "/api/v1/auth/qr", expectedMethods: []string{http.MethodGet}},
+		{name: "test_delete_enroll", method: http.MethodDelete, route: "/api/v1/auth/enroll", expectedMethods: []string{http.MethodPost}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.route, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			response := &FailureResponse{}
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), response))
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			assert.Equal(t, strings.Join(tt.expectedMethods, ", "), w.Header().Get("Allow"))
+			assert.Equal(t, tt.expectedMethods, response.AllowedMethods)
+		})
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	t.Run("test_health_redis_ok", func(t *testing.T) {
+		handler := Configure(initializeTestRedis())
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, structToJSON(NewSuccessResponse(http.StatusOK, "Application is healthy!", map[string]string{"redis": "ok"})), withoutRequestID(w.Body.String()))
+	})
+
+	t.Run("test_health_redis_down", func(t *testing.T) {
+		rdb := initializeTestRedis()
+		rdb.Close()
+
+		handler := Configure(rdb)
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusServiceUnavailable, "Application is unhealthy! Please try again later!")), withoutRequestID(w.Body.String()))
+		assert.NotContains(t, w.Body.String(), redis.ErrClosed.Error())
+	})
+}
+
+func TestTimeHandler(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/time", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	response := &struct {
+		Data struct {
+			Unix int64  `json:"unix"`
+			ISO  string `json:"iso"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	iso, err := time.Parse(time.RFC3339, response.Data.ISO)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.InDelta(t, time.Now().Unix(), response.Data.Unix, 5)
+	assert.Equal(t, response.Data.Unix, iso.Unix())
+}
+
 func TestDecodeJSONBody(t *testing.T) {
 	rdb := initializeTestRedis()
 	handler := Configure(rdb)
@@ -207,10 +337,144 @@ func TestDecodeJSONBody(t *testing.T) {
 				r.Header.Set("Content-Type", "application/json")
 			}
 
-			failureResponse := decodeJSONBody(w, r, &AuthRequestBody{})
+			failureResponse := decodeJSONBody(w, r, &AuthRequestBody{}, 512, true)
+			assert.JSONEq(t, structToJSON(tt.expectedBody), structToJSON(failureResponse))
+		})
+	}
+}
+
+func TestDecodeJSONBodyContentType(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		expectedBody *FailureResponse
+	}{
+		{
+			name:         "test_content_type_with_charset",
+			contentType:  "application/json; charset=utf-8",
+			expectedBody: nil,
+		},
+		{
+			name:         "test_content_type_uppercase",
+			contentType:  "APPLICATION/JSON; Charset=UTF-8",
+			expectedBody: nil,
+		},
+		{
+			name:         "test_content_type_wrong",
+			contentType:  "text/plain; charset=utf-8",
+			expectedBody: NewFailureResponse(http.StatusUnsupportedMediaType, "The 'Content-Type' header is not 'application/json'!"),
+		},
+		{
+			name:         "test_content_type_json_prefix",
+			contentType:  "application/jsonp",
+			expectedBody: NewFailureResponse(http.StatusUnsupportedMediaType, "The 'Content-Type' header is not 'application/json'!"),
+		},
+		{
+			name:         "test_content_type_malformed",
+			contentType:  "application/json; charset",
+			expectedBody: NewFailureResponse(http.StatusUnsupportedMediaType, "The 'Content-Type' header is not 'application/json'!"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kaede","password":"kaede"}`))
+			w := httptest.NewRecorder()
+			r.Header.Set("Content-Type", tt.contentType)
+
+			failureResponse := decodeJSONBody(w, r, &AuthRequestBody{}, 512, true)
+			assert.JSONEq(t, structToJSON(tt.expectedBody), structToJSON(failureResponse))
+		})
+	}
+}
+
+func TestDecodeJSONBodyUnknownFields(t *testing.T) {
+	input := `{"username":"kaede","password":"kaede","deviceName":"phone"}`
+
+	tests := []struct {
+		name         string
+		strict       bool
+		expectedBody *FailureResponse
+	}{
+		{
+			name:         "test_strict_unknown_field",
+			strict:       true,
+			expectedBody: NewFailureResponse(http.StatusBadRequest, "Request body contains unknown field '\"deviceName\"'!"),
+		},
+		{
+			name:         "test_lenient_unknown_field",
+			strict:       false,
+			expectedBody: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(input))
+			w := httptest.NewRecorder()
+			r.Header.Set("Content-Type", "application/json")
+
+			body := &AuthRequestBody{}
+			failureResponse := decodeJSONBody(w, r, body, 512, tt.strict)
+			assert.JSONEq(t, structToJSON(tt.expectedBody), structToJSON(failureResponse))
+			if !tt.strict {
+				assert.Equal(t, AuthRequestBody{Username: "kaede", Password: "kaede"}, *body)
+			}
+		})
+	}
+
+	t.Run("test_configured_lenient", func(t *testing.T) {
+		handler := Configure(initializeTestRedis(), WithStrictJSON(false))
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(input))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestDecodeJSONBodyMaxBytes(t *testing.T) {
+	input := `{"username":"kaede","password":"kaede"}`
+
+	tests := []struct {
+		name         string
+		maxBytes     int64
+		expectedBody *FailureResponse
+	}{
+		{
+			name:         "test_exact_limit",
+			maxBytes:     int64(len(input)),
+			expectedBody: nil,
+		},
+		{
+			name:         "test_below_limit",
+			maxBytes:     int64(len(input)) - 1,
+			expectedBody: NewFailureResponse(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must not be larger than %d bytes!", len(input)-1)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(input))
+			w := httptest.NewRecorder()
+			r.Header.Set("Content-Type", "application/json")
+
+			failureResponse := decodeJSONBody(w, r, &AuthRequestBody{}, tt.maxBytes, true)
 			assert.JSONEq(t, structToJSON(tt.expectedBody), structToJSON(failureResponse))
 		})
 	}
+
+	t.Run("test_configured_limit", func(t *testing.T) {
+		handler := Configure(initializeTestRedis(), WithMaxBodyBytes(16))
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(input))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusRequestEntityTooLarge, "Request body must not be larger than 16 bytes!")), withoutRequestID(w.Body.String()))
+	})
 }
 
 func TestAuthenticationHandler(t *testing.T) {
@@ -231,7 +495,17 @@ func TestAuthenticationHandler(t *testing.T) {
 			method:       http.MethodPost,
 			route:        "/api/v1/auth/login",
 			input:        `{"username":"kimura","password":"kaori"}`,
-			expectedBody: NewFailureResponse(http.StatusUnauthorized, "Username or password do not match!"),
+			expectedBody: NewFailureResponse(http.StatusUnauthorized, "Username or password do not match!").WithErrorCode(ErrorCodeInvalidCredentials),
+		},
+		{
+			name:   "test_empty_username_and_password",
+			method: http.MethodPost,
+			route:  "/api/v1/auth/login",
+			input:  `{"username":"","password":""}`,
+			expectedBody: NewFailureResponse(http.StatusBadRequest, "Request body contains invalid fields!").WithErrorCode(ErrorCodeValidationFailed).WithFieldErrors([]FieldError{
+				{Field: "username", Message: "Field 'username' must not be empty!"},
+				{Field: "password", Message: "Field 'password' must not be empty!"},
+			}),
 		},
 		{
 			name:         "test_bad_json",
@@ -265,7 +539,7 @@ func TestAuthenticationHandler(t *testing.T) {
 			r.Header.Set("Content-Type", "application/json")
 			handler.ServeHTTP(w, r)
 
-			assert.JSONEq(t, structToJSON(tt.expectedBody), w.Body.String())
+			assert.JSONEq(t, structToJSON(tt.expectedBody), withoutRequestID(w.Body.String()))
 		})
 	}
 
@@ -308,7 +582,7 @@ func TestVerifyHandler(t *testing.T) {
 		{
 			name:           "test_without_header",
 			input:          "{}",
-			expectedBody:   NewFailureResponse(http.StatusUnauthorized, "Please provide an 'Authorization' header!"),
+			expectedBody:   NewFailureResponse(http.StatusUnauthorized, "Please provide an 'Authorization' header!").WithErrorCode(ErrorCodeMissingAuthorization),
 			expectedStatus: http.StatusUnauthorized,
 			withHeader:     false,
 		},
@@ -338,7 +612,7 @@ func TestVerifyHandler(t *testing.T) {
 			handler.ServeHTTP(w, r)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
-			assert.JSONEq(t, structToJSON(tt.expectedBody), w.Body.String())
+			assert.JSONEq(t, structToJSON(tt.expectedBody), withoutRequestID(w.Body.String()))
 		})
 	}
 
@@ -353,3 +627,2136 @@ func TestVerifyHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyJSONBody(t *testing.T) {
+	rdb := initializeTestRedis()
+	handler := Configure(rdb)
+
+	tests := []struct {
+		name           string
+		input          string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "test_json_verify_success",
+			input:          structToJSON(VerificationRequestBody{Username: "kaede", OTP: generateTestOTP(DefaultOTPOptions())}),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_json_verify_wrong_code",
+			input:          structToJSON(VerificationRequestBody{Username: "kaede", OTP: "0000000000"}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   ErrorCodeInvalidOTP,
+		},
+		{
+			name:           "test_json_verify_unknown_user",
+			input:          structToJSON(VerificationRequestBody{Username: "unknown", OTP: "0000000000"}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   ErrorCodeInvalidCredentials,
+		},
+		{
+			name:           "test_json_verify_too_long",
+			input:          structToJSON(VerificationRequestBody{Username: "kaede", OTP: strings.Repeat("1", maxPasscodeLength+1)}),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   ErrorCodeInvalidOTPLength,
+		},
+		{
+			name:           "test_json_verify_unknown_field",
+			input:          `{"username":"kaede","password":"1234567890"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", strings.NewReader(tt.input))
+			w := httptest.NewRecorder()
+			r.Header.Set("Content-Type", "application/json")
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedCode != "" {
+				response := FailureResponse{}
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.expectedCode, response.ErrorCode)
+			}
+		})
+	}
+}
+
+func TestOTPOptions(t *testing.T) {
+	rdb := initializeTestRedis()
+	handler := Configure(rdb, WithOTPOptions(OTPOptions{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}))
+
+	testSharedSecret := base32.StdEncoding.EncodeToString([]byte("kaedeKIMURA"))
+	customOTP, err := totp.GenerateCodeCustom(testSharedSecret, time.Now(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	defaultOTP := generateTestOTP(DefaultOTPOptions())
+
+	t.Run("test_login_custom_options", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kaede","password":"kaede"}`))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+
+		response := &struct {
+			Data struct {
+				OTP string `json:"otp"`
+			} `json:"data"`
+		}{}
+		if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, customOTP, response.Data.OTP)
+	})
+
+	t.Run("test_verify_custom_options_success", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", customOTP)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("test_verify_custom_options_wrong_length", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", defaultOTP)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusBadRequest, "Your OTP does not conform to the length requirements of the validation server!").WithErrorCode(ErrorCodeInvalidOTPLength)), withoutRequestID(w.Body.String()))
+	})
+}
+
+func TestSessionCookie(t *testing.T) {
+	tests := []struct {
+		name           string
+		options        []Option
+		expectedSecure bool
+	}{
+		{
+			name:           "test_default_secure_cookie",
+			options:        nil,
+			expectedSecure: true,
+		},
+		{
+			name:           "test_insecure_cookie",
+			options:        []Option{WithSecureCookies(false)},
+			expectedSecure: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), tt.options...)
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("kaede", generateTestOTP(DefaultOTPOptions()))
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			// The session cookie has to be set, otherwise there would be no attributes to check.
+			sessionCookie := ""
+			for _, cookie := range w.Header().Values("Set-Cookie") {
+				if strings.HasPrefix(cookie, "sess=") {
+					sessionCookie = cookie
+				}
+			}
+			if sessionCookie == "" {
+				t.Fatal("Session cookie is not found!")
+			}
+
+			assert.Contains(t, sessionCookie, "HttpOnly")
+			assert.Contains(t, sessionCookie, "SameSite=Lax")
+			assert.Equal(t, tt.expectedSecure, strings.Contains(sessionCookie, "Secure"))
+		})
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []Option
+		expected time.Duration
+	}{
+		{
+			name:     "test_default_session_ttl",
+			options:  nil,
+			expected: 15 * time.Minute,
+		},
+		{
+			name:     "test_custom_session_ttl",
+			options:  []Option{WithSessionTTL(time.Hour)},
+			expected: time.Hour,
+		},
+		{
+			name:     "test_zero_session_ttl",
+			options:  []Option{WithSessionTTL(0)},
+			expected: 15 * time.Minute,
+		},
+		{
+			name:     "test_negative_session_ttl",
+			options:  []Option{WithSessionTTL(-time.Hour)},
+			expected: 15 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), tt.options...)
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("kaede", generateTestOTP(DefaultOTPOptions()))
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			cookies := w.Result().Cookies()
+			assert.Len(t, cookies, 2)
+			for _, cookie := range cookies {
+				assert.Equal(t, int(tt.expected.Seconds()), cookie.MaxAge)
+				assert.WithinDuration(t, time.Now().Add(tt.expected), cookie.Expires, 5*time.Second)
+			}
+		})
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		lockout  LockoutOptions
+		expected LockoutOptions
+	}{
+		{
+			name:     "test_positive_lockout_duration",
+			lockout:  LockoutOptions{MaxFailures: 3, Duration: time.Minute},
+			expected: LockoutOptions{MaxFailures: 3, Duration: time.Minute},
+		},
+		{
+			name:     "test_zero_lockout_duration",
+			lockout:  LockoutOptions{MaxFailures: 3, Duration: 0},
+			expected: LockoutOptions{},
+		},
+		{
+			name:     "test_negative_lockout_duration",
+			lockout:  LockoutOptions{MaxFailures: 3, Duration: -time.Minute},
+			expected: LockoutOptions{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, newOptions(WithLockout(tt.lockout)).lockout)
+		})
+	}
+}
+
+func TestSessionPrefix(t *testing.T) {
+	rdb := initializeTestRedis()
+	handler := Configure(rdb, WithSessionPrefix("custom:"))
+	cookie := verifyTestUser(handler)
+
+	t.Run("test_verification_uses_prefix", func(t *testing.T) {
+		// Other data of the user, such as the audit events, is under the prefix as well.
+		keys, err := rdb.Keys(context.Background(), "*").Result()
+		assert.Nil(t, err)
+		assert.Contains(t, keys, "custom:"+cookie.Value)
+		assert.Contains(t, keys, "custom:audit:kaede")
+		for _, key := range keys {
+			assert.True(t, strings.HasPrefix(key, "custom:"), key)
+		}
+	})
+
+	t.Run("test_sessions_uses_prefix", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+		w := httptest.NewRecorder()
+		r.AddCookie(cookie)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`"sessionId":%q`, cookie.Value))
+	})
+}
+
+func TestRecoveryHandler(t *testing.T) {
+	rdb := initializeTestRedis()
+	handler := Configure(rdb)
+	err := session.New(rdb, time.Minute).StoreBackupCodes(context.Background(), "kaede", []string{session.HashBackupCode("abcdefgh")})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		name           string
+		username       string
+		backupCode     string
+		expectedStatus int
+		expectedCookie bool
+	}{
+		{
+			name:           "test_valid_backup_code",
+			username:       "kaede",
+			backupCode:     "abcdefgh",
+			expectedStatus: http.StatusOK,
+			expectedCookie: true,
+		},
+		{
+			name:           "test_reused_backup_code",
+			username:       "kaede",
+			backupCode:     "abcdefgh",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "test_unknown_backup_code",
+			username:       "kaede",
+			backupCode:     "zzzzzzzz",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "test_unknown_username",
+			username:       "mai",
+			backupCode:     "abcdefgh",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/recovery", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth(tt.username, tt.backupCode)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedCookie, strings.Contains(strings.Join(w.Header().Values("Set-Cookie"), ";"), "sess="))
+		})
+	}
+}
+
+// Session store that counts the consumed backup codes of every user.
+type backupCodeCountingStore struct {
+	session.Store
+	consumed map[string]int
+}
+
+func (s *backupCodeCountingStore) ConsumeBackupCode(ctx context.Context, userID, code string) (bool, error) {
+	s.consumed[userID]++
+	return s.Store.ConsumeBackupCode(ctx, userID, code)
+}
+
+func TestRecoveryUnknownUsername(t *testing.T) {
+	store := &backupCodeCountingStore{Store: session.NewMemoryStore(time.Minute, 0), consumed: map[string]int{}}
+	handler := Configure(nil, WithSessionStore(store))
+	if err := store.StoreBackupCodes(context.Background(), "kaede", []string{session.HashBackupCode("abcdefgh")}); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/recovery", nil)
+	w := httptest.NewRecorder()
+	r.SetBasicAuth("mai", "abcdefgh")
+	handler.ServeHTTP(w, r)
+
+	// A backup code of the dummy user is consumed, so unknown users take as long as the known ones.
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), ErrorCodeInvalidCredentials)
+	assert.Equal(t, map[string]int{dummyUser(DefaultOTPOptions()).Username: 1}, store.consumed)
+
+	// The backup code of the known user is left untouched.
+	consumed, err := store.ConsumeBackupCode(context.Background(), "kaede", "abcdefgh")
+	assert.Nil(t, err)
+	assert.True(t, consumed)
+}
+
+func TestRecoveryLimits(t *testing.T) {
+	recoverWith := func(handler http.Handler, backupCode string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/recovery", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", backupCode)
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("test_recovery_rate_limited", func(t *testing.T) {
+		handler := Configure(initializeTestRedis(), WithRateLimit(RateLimitOptions{MaxAttempts: 2, Window: time.Minute}))
+
+		assert.Equal(t, http.StatusUnauthorized, recoverWith(handler, "zzzzzzzz").Code)
+		assert.Equal(t, http.StatusUnauthorized, recoverWith(handler, "zzzzzzzz").Code)
+
+		w := recoverWith(handler, "zzzzzzzz")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	})
+
+	t.Run("test_recovery_locked_out", func(t *testing.T) {
+		store := session.NewMemoryStore(15*time.Minute, 0)
+		handler := Configure(nil, WithSessionStore(store), WithLockout(LockoutOptions{MaxFailures: 2, Duration: 5 * time.Minute}))
+		if err := store.StoreBackupCodes(context.Background(), "kaede", []string{session.HashBackupCode("abcdefgh")}); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, recoverWith(handler, "zzzzzzzz").Code)
+		assert.Equal(t, http.StatusUnauthorized, recoverWith(handler, "zzzzzzzz").Code)
+
+		// Even a valid backup code is rejected, and it is not consumed.
+		w := recoverWith(handler, "abcdefgh")
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeAccountLocked)
+
+		consumed, err := store.ConsumeBackupCode(context.Background(), "kaede", "abcdefgh")
+		assert.Nil(t, err)
+		assert.True(t, consumed)
+	})
+
+	t.Run("test_recovery_audited", func(t *testing.T) {
+		store := session.NewMemoryStore(15*time.Minute, 0)
+		handler := Configure(nil, WithSessionStore(store))
+		if err := store.StoreBackupCodes(context.Background(), "kaede", []string{session.HashBackupCode("abcdefgh")}); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, recoverWith(handler, "zzzzzzzz").Code)
+		assert.Equal(t, http.StatusOK, recoverWith(handler, "abcdefgh").Code)
+
+		events, err := store.GetAudit(context.Background(), "kaede", session.MaxAuditEvents)
+		assert.Nil(t, err)
+
+		var outcomes []string
+		for _, event := range events {
+			outcomes = append(outcomes, event.Type+":"+event.Outcome)
+		}
+		assert.Equal(t, []string{"recovery:success", "recovery:failure"}, outcomes)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := Configure(initializeTestRedis(), WithRateLimit(RateLimitOptions{MaxAttempts: 2, Window: time.Minute}))
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", "0000000000")
+		handler.ServeHTTP(w, r)
+
+		if attempt <= 2 {
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Empty(t, w.Header().Get("Retry-After"))
+			continue
+		}
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		assert.Nil(t, err)
+		assert.True(t, retryAfter > 0 && retryAfter <= 60)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusTooManyRequests, "Too many verification attempts! Please try again later!").WithErrorCode(ErrorCodeRateLimited)), withoutRequestID(w.Body.String()))
+	}
+}
+
+func TestPerUserAlgorithm(t *testing.T) {
+	sha1User := &User{
+		Username: "kaede",
+		Password: "kaede",
+		Secret:   base32.StdEncoding.EncodeToString([]byte("kaedeKIMURA")),
+	}
+	sha512User := &User{
+		Username:  "kimura",
+		Password:  "kimura",
+		Secret:    base32.StdEncoding.EncodeToString([]byte("kimuraKAEDE")),
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA512,
+	}
+	handler := Configure(initializeTestRedis(), WithUserStore(NewMemoryUserStore(sha1User, sha512User)))
+
+	generateCode := func(user *User, algorithm otp.Algorithm) string {
+		code, err := totp.GenerateCodeCustom(user.Secret, time.Now(), totp.ValidateOpts{
+			Period:    30,
+			Skew:      1,
+			Digits:    otp.DigitsSix,
+			Algorithm: algorithm,
+		})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		return code
+	}
+
+	tests := []struct {
+		name           string
+		user           *User
+		code           string
+		expectedStatus int
+	}{
+		{
+			name:           "test_sha1_user_default_options",
+			user:           sha1User,
+			code:           generateCode(sha1User, otp.AlgorithmSHA1),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_sha512_user",
+			user:           sha512User,
+			code:           generateCode(sha512User, otp.AlgorithmSHA512),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_sha1_user_with_sha512_code",
+			user:           sha1User,
+			code:           generateCode(sha1User, otp.AlgorithmSHA512),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "test_sha512_user_with_sha1_code",
+			user:           sha512User,
+			code:           generateCode(sha512User, otp.AlgorithmSHA1),
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth(tt.user.Username, tt.code)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	t.Run("test_login_per_user_code", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kimura","password":"kimura"}`))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+
+		response := &struct {
+			Data struct {
+				OTP string `json:"otp"`
+			} `json:"data"`
+		}{}
+		if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, generateCode(sha512User, otp.AlgorithmSHA512), response.Data.OTP)
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+
+	tests := []struct {
+		name  string
+		route string
+	}{
+		{
+			name:  "test_success_request_id",
+			route: "/api/v1",
+		},
+		{
+			name:  "test_failure_request_id",
+			route: "/api/v1/404",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.route, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			response := &struct {
+				RequestID string `json:"requestId"`
+			}{}
+			if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+				log.Fatal(err.Error())
+			}
+
+			assert.NotEmpty(t, response.RequestID)
+			assert.Equal(t, w.Header().Get("X-Request-Id"), response.RequestID)
+		})
+	}
+}
+
+func TestVerifyUnknownUsername(t *testing.T) {
+	// Count the number of validations.
+	validations := 0
+	handler := Configure(initializeTestRedis(), withValidateOTP(func(passcode string, secret string, t time.Time, opts totp.ValidateOpts) (bool, int64, error) {
+		validations++
+		return validateWithOffset(passcode, secret, t, opts)
+	}))
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{
+			name:     "test_unknown_username",
+			username: "kimura",
+			password: generateTestOTP(DefaultOTPOptions()),
+		},
+		{
+			name:     "test_unknown_username_wrong_length",
+			username: "kimura",
+			password: "123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validations = 0
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth(tt.username, tt.password)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusUnauthorized, "Username does not match with the database!").WithErrorCode(ErrorCodeInvalidCredentials)), withoutRequestID(w.Body.String()))
+			assert.Equal(t, 1, validations)
+		})
+	}
+}
+
+func TestWithConfig(t *testing.T) {
+	cfg := config.Config{
+		SessionTTL:   time.Hour,
+		OTPPeriod:    30,
+		OTPDigits:    otp.DigitsSix,
+		OTPAlgorithm: otp.AlgorithmSHA1,
+		SharedSecret: "KAEDE",
+		Username:     "kimura",
+		Password:     "kaori",
+	}
+	handler := Configure(initializeTestRedis(), WithConfig(cfg))
+
+	code, err := totp.GenerateCodeCustom(base32.StdEncoding.EncodeToString([]byte("kimuraKAEDE")), time.Now(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	t.Run("test_configured_credentials", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kimura","password":"kaori"}`))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("test_configured_otp", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kimura", code)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestVerifyPasswordTooLong(t *testing.T) {
+	// Count the number of validations.
+	validations := 0
+	handler := Configure(initializeTestRedis(), withValidateOTP(func(passcode string, secret string, t time.Time, opts totp.ValidateOpts) (bool, int64, error) {
+		validations++
+		return validateWithOffset(passcode, secret, t, opts)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+	w := httptest.NewRecorder()
+	r.SetBasicAuth("kaede", strings.Repeat("1", 10*1024))
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusBadRequest, "Your OTP does not conform to the length requirements of the validation server!").WithErrorCode(ErrorCodeInvalidOTPLength)), withoutRequestID(w.Body.String()))
+	assert.Equal(t, 0, validations)
+}
+
+func TestRotateSecret(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+	cookie := verifyTestUser(handler)
+	oldOTP := generateTestOTP(DefaultOTPOptions())
+
+	t.Run("test_rotate_without_csrf_token", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/secret/rotate", nil)
+		w := httptest.NewRecorder()
+		r.AddCookie(cookie)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	var newSecret string
+	t.Run("test_rotate_secret", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/secret/rotate", nil)
+		w := httptest.NewRecorder()
+		r.AddCookie(cookie)
+		r.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+		r.Header.Set("X-CSRF-Token", "token")
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		response := struct {
+			Data struct {
+				URI string `json:"uri"`
+			} `json:"data"`
+		}{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+		uri, err := url.Parse(response.Data.URI)
+		assert.Nil(t, err)
+		newSecret = uri.Query().Get("secret")
+		assert.NotEmpty(t, newSecret)
+	})
+
+	t.Run("test_old_secret_fails", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", oldOTP)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("test_new_secret_succeeds", func(t *testing.T) {
+		newOTP, err := totp.GenerateCodeCustom(newSecret, time.Now(), DefaultOTPOptions().validateOptsFor(defaultUser(DefaultOTPOptions())))
+		assert.Nil(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", newOTP)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestVerifyOTPTooShort(t *testing.T) {
+	handler := Configure(initializeTestRedis(), WithOTPOptions(OTPOptions{Period: 30, Skew: 1, Digits: 10, Algorithm: otp.AlgorithmSHA512}))
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{name: "test_short_otp", password: "123456"},
+		{name: "test_short_otp_padded_with_whitespaces", password: "123456    "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("kaede", tt.password)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusBadRequest, "OTP too short, expected 10 digits!").WithErrorCode(ErrorCodeInvalidOTPLength)), withoutRequestID(w.Body.String()))
+		})
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+	cookie := verifyTestUser(handler)
+
+	tests := []struct {
+		name           string
+		sessionCookie  *http.Cookie
+		expectedStatus int
+	}{
+		{
+			name:           "test_refresh_valid_session",
+			sessionCookie:  cookie,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_refresh_absent_session",
+			sessionCookie:  &http.Cookie{Name: "sess", Value: "expired"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "test_refresh_without_session",
+			sessionCookie:  nil,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", nil)
+			w := httptest.NewRecorder()
+			if tt.sessionCookie != nil {
+				r.AddCookie(tt.sessionCookie)
+			}
+			r.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+			r.Header.Set("X-CSRF-Token", "token")
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				assert.Empty(t, w.Result().Cookies())
+				return
+			}
+
+			for _, refreshed := range w.Result().Cookies() {
+				assert.WithinDuration(t, time.Now().Add(15*time.Minute), refreshed.Expires, 5*time.Second)
+				if refreshed.Name == "sess" {
+					assert.Equal(t, cookie.Value, refreshed.Value)
+				}
+			}
+			assert.Contains(t, w.Body.String(), "expiresAt")
+		})
+	}
+}
+
+func TestCSRFRoutes(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+	w := httptest.NewRecorder()
+	r.SetBasicAuth("kaede", generateTestOTP(DefaultOTPOptions()))
+	handler.ServeHTTP(w, r)
+
+	var sessionCookie, csrfCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		switch cookie.Name {
+		case "sess":
+			sessionCookie = cookie
+		case "csrf":
+			csrfCookie = cookie
+		}
+	}
+	if sessionCookie == nil || csrfCookie == nil {
+		t.Fatal("Session and CSRF cookies are not found!")
+	}
+
+	tests := []struct {
+		name           string
+		withCSRFCookie bool
+		header         string
+		expectedStatus int
+	}{
+		{
+			name:           "test_csrf_matching_token",
+			withCSRFCookie: true,
+			header:         csrfCookie.Value,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_csrf_missing_header",
+			withCSRFCookie: true,
+			header:         "",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "test_csrf_mismatched_header",
+			withCSRFCookie: true,
+			header:         csrfCookie.Value + "x",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "test_csrf_missing_cookie",
+			withCSRFCookie: false,
+			header:         csrfCookie.Value,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", nil)
+			w := httptest.NewRecorder()
+			r.AddCookie(sessionCookie)
+			if tt.withCSRFCookie {
+				r.AddCookie(csrfCookie)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-CSRF-Token", tt.header)
+			}
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), ErrorCodeInvalidCSRFToken)
+			}
+		})
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+	validOTP := generateTestOTP(DefaultOTPOptions())
+	verifyTestUser(handler)
+
+	tests := []struct {
+		name              string
+		method            string
+		path              string
+		password          string
+		expectedErrorCode string
+	}{
+		{
+			name:              "test_otp_reused",
+			method:            http.MethodPost,
+			path:              "/api/v1/auth/verification",
+			password:          validOTP,
+			expectedErrorCode: ErrorCodeOTPReused,
+		},
+		{
+			name:              "test_wrong_otp",
+			method:            http.MethodPost,
+			path:              "/api/v1/auth/verification",
+			password:          "0000000000",
+			expectedErrorCode: ErrorCodeInvalidOTP,
+		},
+		{
+			name:              "test_no_session",
+			method:            http.MethodGet,
+			path:              "/api/v1/sessions",
+			expectedErrorCode: ErrorCodeSessionNotFound,
+		},
+		{
+			name:              "test_default_error_code",
+			method:            http.MethodGet,
+			path:              "/api/v1/404",
+			expectedErrorCode: "NOT_FOUND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			if tt.password != "" {
+				r.SetBasicAuth("kaede", tt.password)
+			}
+			handler.ServeHTTP(w, r)
+
+			response := FailureResponse{}
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedErrorCode, response.ErrorCode)
+		})
+	}
+}
+
+// Network error that always times out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "read tcp 127.0.0.1:6379: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestServerFailure(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{name: "test_unexpected_error", err: errors.New("dial tcp 10.0.0.1:6379: connection refused"), expectedStatus: http.StatusInternalServerError},
+		{name: "test_context_deadline", err: fmt.Errorf("redis: %w", context.DeadlineExceeded), expectedStatus: http.StatusServiceUnavailable},
+		{name: "test_socket_deadline", err: os.ErrDeadlineExceeded, expectedStatus: http.StatusServiceUnavailable},
+		{name: "test_network_timeout", err: timeoutError{}, expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serverFailure(httptest.NewRequest(http.MethodGet, "/", nil), tt.err)
+
+			assert.Equal(t, tt.expectedStatus, res.Code)
+			assert.NotContains(t, res.Message, tt.err.Error())
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// A Redis that accepts connections, but never replies to any of the commands.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+
+	// Connections are kept open until the end of the test, so the commands are never replied.
+	var mu sync.Mutex
+	var conns []net.Conn
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	rdb := redis.NewClient(&redis.Options{Addr: listener.Addr().String(), MaxRetries: -1})
+	handler := Configure(rdb, WithRequestTimeout(100*time.Millisecond))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", nil)
+	w := httptest.NewRecorder()
+	r.AddCookie(&http.Cookie{Name: "sess", Value: "slow"})
+	r.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+	r.Header.Set("X-CSRF-Token", "token")
+
+	start := time.Now()
+	handler.ServeHTTP(w, r)
+
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusServiceUnavailable, "Request timed out! Please try again later!").WithErrorCode(ErrorCodeTimeout)), withoutRequestID(w.Body.String()))
+}
+
+func TestVerifyDryRun(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+	code := generateTestOTP(DefaultOTPOptions())
+
+	tests := []struct {
+		name           string
+		path           string
+		padding        string
+		expectedStatus int
+		expectCookies  bool
+	}{
+		{
+			name:           "test_dry_run",
+			path:           "/api/v1/auth/verification?dryRun=true",
+			expectedStatus: http.StatusOK,
+			expectCookies:  false,
+		},
+		{
+			name:           "test_dry_run_again",
+			path:           "/api/v1/auth/verification?dryRun=true",
+			expectedStatus: http.StatusOK,
+			expectCookies:  false,
+		},
+		{
+			name:           "test_real_after_dry_run",
+			path:           "/api/v1/auth/verification",
+			expectedStatus: http.StatusOK,
+			expectCookies:  true,
+		},
+		{
+			name:           "test_dry_run_after_real",
+			path:           "/api/v1/auth/verification?dryRun=true",
+			expectedStatus: http.StatusBadRequest,
+			expectCookies:  false,
+		},
+		{
+			name:           "test_padded_dry_run_after_real",
+			path:           "/api/v1/auth/verification?dryRun=true",
+			padding:        " ",
+			expectedStatus: http.StatusBadRequest,
+			expectCookies:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("kaede", tt.padding+code+tt.padding)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectCookies, len(w.Result().Cookies()) > 0)
+		})
+	}
+}
+
+func TestVerifyPaddedReplay(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+	code := generateTestOTP(DefaultOTPOptions())
+
+	tests := []struct {
+		name           string
+		password       string
+		expectedStatus int
+	}{
+		{name: "test_first_use", password: code, expectedStatus: http.StatusOK},
+		{name: "test_leading_whitespace", password: " " + code, expectedStatus: http.StatusBadRequest},
+		{name: "test_trailing_whitespace", password: code + " ", expectedStatus: http.StatusBadRequest},
+		{name: "test_surrounding_tabs", password: "\t" + code + "\t", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("kaede", tt.password)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusBadRequest {
+				assert.Contains(t, w.Body.String(), ErrorCodeOTPReused)
+			}
+		})
+	}
+}
+
+func TestSessionRoles(t *testing.T) {
+	admin := &User{
+		Username: "kaede",
+		Password: "kaede",
+		Secret:   base32.StdEncoding.EncodeToString([]byte("kaedeKIMURA")),
+		Role:     RoleAdmin,
+	}
+	regular := &User{
+		Username: "kimura",
+		Password: "kimura",
+		Secret:   base32.StdEncoding.EncodeToString([]byte("kimuraKAEDE")),
+	}
+	users := NewMemoryUserStore(admin, regular)
+	handler := Configure(initializeTestRedis(), WithUserStore(users))
+
+	verify := func(user *User) *http.Cookie {
+		code, err := totp.GenerateCodeCustom(user.Secret, time.Now(), DefaultOTPOptions().validateOptsFor(user))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth(user.Username, code)
+		handler.ServeHTTP(w, r)
+
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "sess" {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+	adminCookie := verify(admin)
+	regularCookie := verify(regular)
+
+	tests := []struct {
+		name          string
+		cookie        *http.Cookie
+		expectedUsers []string
+	}{
+		{
+			name:          "test_admin_sees_all_sessions",
+			cookie:        adminCookie,
+			expectedUsers: []string{"kaede", "kimura"},
+		},
+		{
+			name:          "test_user_sees_own_sessions",
+			cookie:        regularCookie,
+			expectedUsers: []string{"kimura"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+			w := httptest.NewRecorder()
+			r.AddCookie(tt.cookie)
+			handler.ServeHTTP(w, r)
+
+			response := &struct {
+				Data struct {
+					Keys []session.SessionInfo `json:"keys"`
+				} `json:"data"`
+			}{}
+			if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+				log.Fatal(err.Error())
+			}
+
+			var users []string
+			for _, key := range response.Data.Keys {
+				users = append(users, key.UserID)
+			}
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.ElementsMatch(t, tt.expectedUsers, users)
+		})
+	}
+
+	t.Run("test_demoted_admin_sees_own_sessions", func(t *testing.T) {
+		demoted := *admin
+		demoted.Role = RoleUser
+		assert.Nil(t, users.Save(&demoted))
+
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+		w := httptest.NewRecorder()
+		r.AddCookie(adminCookie)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), `"userId":"kimura"`)
+	})
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := session.NewMemoryStore(15*time.Minute, time.Minute)
+	handler := Configure(nil, WithSessionStore(store))
+	cookie := verifyTestUser(handler)
+
+	t.Run("test_health_memory_store", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("test_sessions_memory_store", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+		w := httptest.NewRecorder()
+		r.AddCookie(cookie)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`"sessionId":%q`, cookie.Value))
+	})
+
+	t.Run("test_shutdown_memory_store", func(t *testing.T) {
+		assert.Nil(t, handler.Shutdown(context.Background()))
+	})
+}
+
+func TestVerifyClockSkew(t *testing.T) {
+	otpOptions := OTPOptions{Period: 30, Skew: 2, Digits: 10, Algorithm: otp.AlgorithmSHA512}
+	handler := Configure(initializeTestRedis(), WithOTPOptions(otpOptions))
+	user := defaultUser(otpOptions)
+
+	tests := []struct {
+		name             string
+		offset           time.Duration
+		expectedSkew     int64
+		expectedSkewJSON bool
+	}{
+		{
+			name:             "test_current_period",
+			offset:           0,
+			expectedSkew:     0,
+			expectedSkewJSON: false,
+		},
+		{
+			name:             "test_past_period",
+			offset:           -60 * time.Second,
+			expectedSkew:     -60,
+			expectedSkewJSON: true,
+		},
+		{
+			name:             "test_future_period",
+			offset:           30 * time.Second,
+			expectedSkew:     30,
+			expectedSkewJSON: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := totp.GenerateCodeCustom(user.Secret, time.Now().Add(tt.offset), otpOptions.validateOptsFor(user))
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("kaede", code)
+			handler.ServeHTTP(w, r)
+
+			body := w.Body.String()
+			response := &struct {
+				Data struct {
+					ClockSkewSeconds int64 `json:"clockSkewSeconds"`
+				} `json:"data"`
+			}{}
+			if err := json.Unmarshal([]byte(body), response); err != nil {
+				log.Fatal(err.Error())
+			}
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectedSkew, response.Data.ClockSkewSeconds)
+			assert.Equal(t, tt.expectedSkewJSON, strings.Contains(body, "clockSkewSeconds"))
+		})
+	}
+}
+
+func TestValidateWithOffset(t *testing.T) {
+	user := defaultUser(DefaultOTPOptions())
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		skew           uint
+		codeOffset     time.Duration
+		passcode       string
+		expectedValid  bool
+		expectedOffset int64
+		expectedErr    error
+	}{
+		{name: "test_past_period", skew: 1, codeOffset: -30 * time.Second, expectedValid: true, expectedOffset: -1},
+		{name: "test_outside_window", skew: 1, codeOffset: -90 * time.Second, expectedValid: false},
+		{name: "test_skew_larger_than_max_window", skew: rfcotp.MaxWindow + 1, codeOffset: -60 * time.Second, expectedValid: true},
+		{name: "test_non_digit_passcode", skew: 1, passcode: "123456789a", expectedValid: false},
+		{name: "test_wrong_length", skew: 1, passcode: "123", expectedErr: otp.ErrValidateInputInvalidLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOTPOptions().validateOptsFor(user)
+			opts.Skew = tt.skew
+			passcode := tt.passcode
+			if passcode == "" {
+				code, err := totp.GenerateCodeCustom(user.Secret, now.Add(tt.codeOffset), opts)
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+				passcode = code
+			}
+
+			valid, offset, err := validateWithOffset(passcode, user.Secret, now, opts)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedValid, valid)
+			assert.Equal(t, tt.expectedOffset, offset)
+		})
+	}
+}
+
+func TestRealm(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []Option
+		path     string
+		expected string
+	}{
+		{
+			name:     "test_default_realm",
+			options:  nil,
+			path:     "/api/v1/auth/verification",
+			expected: `Basic realm="restricted", charset="UTF-8"`,
+		},
+		{
+			name:     "test_custom_realm",
+			options:  []Option{WithRealm("Fullstack OTP")},
+			path:     "/api/v1/auth/verification",
+			expected: `Basic realm="Fullstack OTP", charset="UTF-8"`,
+		},
+		{
+			name:     "test_custom_realm_recovery",
+			options:  []Option{WithRealm("Fullstack OTP")},
+			path:     "/api/v1/auth/recovery",
+			expected: `Basic realm="Fullstack OTP", charset="UTF-8"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), tt.options...)
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, tt.expected, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestLockout(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer mr.Close()
+
+	lockout := LockoutOptions{MaxFailures: 2, Duration: 5 * time.Minute}
+	handler := Configure(redis.NewClient(&redis.Options{Addr: mr.Addr()}), WithLockout(lockout))
+
+	verify := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", code)
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("test_failures_lock_account", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, verify("0000000000").Code)
+		assert.Equal(t, http.StatusUnauthorized, verify("1111111111").Code)
+
+		w := verify(generateTestOTP(DefaultOTPOptions()))
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusLocked, "Your account is temporarily locked due to too many failed attempts!").WithErrorCode(ErrorCodeAccountLocked)), withoutRequestID(w.Body.String()))
+	})
+
+	t.Run("test_lock_expires", func(t *testing.T) {
+		mr.FastForward(lockout.Duration)
+
+		assert.Equal(t, http.StatusOK, verify(generateTestOTP(DefaultOTPOptions())).Code)
+	})
+
+	t.Run("test_success_resets_failures", func(t *testing.T) {
+		// OTPs of the neighbouring periods are still in the window, and have not been used yet.
+		otpAt := func(offset time.Duration) string {
+			user := defaultUser(DefaultOTPOptions())
+			code, err := totp.GenerateCodeCustom(user.Secret, time.Now().Add(offset), DefaultOTPOptions().validateOptsFor(user))
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+
+			return code
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, verify("2222222222").Code)
+		assert.Equal(t, http.StatusOK, verify(otpAt(-30*time.Second)).Code)
+
+		// Without the reset, this would be the second consecutive failure, and the account would be locked.
+		assert.Equal(t, http.StatusUnauthorized, verify("3333333333").Code)
+		assert.Equal(t, http.StatusOK, verify(otpAt(30*time.Second)).Code)
+	})
+}
+
+func TestEnroll(t *testing.T) {
+	admin := defaultUser(DefaultOTPOptions())
+	admin.Role = RoleAdmin
+	regular := &User{
+		Username: "kimura",
+		Password: "kimura",
+		Secret:   base32.StdEncoding.EncodeToString([]byte("kimuraKAEDE")),
+	}
+	users := NewMemoryUserStore(admin, regular)
+	handler := Configure(initializeTestRedis(), WithUserStore(users))
+	adminCookie := verifyTestUser(handler)
+
+	enroll := func(cookie *http.Cookie, username string) *httptest.ResponseRecorder {
+		body := structToJSON(AuthRequestBody{Username: username, Password: "password"})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/enroll", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		r.AddCookie(cookie)
+		r.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+		r.Header.Set("X-CSRF-Token", "token")
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("test_enroll_new_user", func(t *testing.T) {
+		w := enroll(adminCookie, "hayase")
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		response := struct {
+			Data struct {
+				Secret      string   `json:"secret"`
+				URI         string   `json:"uri"`
+				BackupCodes []string `json:"backupCodes"`
+			} `json:"data"`
+		}{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+		uri, err := url.Parse(response.Data.URI)
+		assert.Nil(t, err)
+		assert.Equal(t, "otpauth", uri.Scheme)
+		assert.Equal(t, "totp", uri.Host)
+		assert.Equal(t, response.Data.Secret, uri.Query().Get("secret"))
+		assert.NotEmpty(t, response.Data.BackupCodes)
+
+		// The secret is stored, but it is not active yet.
+		user, found := users.Get("hayase")
+		assert.True(t, found)
+		assert.Equal(t, response.Data.Secret, user.PendingSecret)
+		assert.Empty(t, user.Secret)
+
+		code, err := totp.GenerateCodeCustom(user.PendingSecret, time.Now(), DefaultOTPOptions().validateOptsFor(user))
+		assert.Nil(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w = httptest.NewRecorder()
+		r.SetBasicAuth("hayase", code)
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("test_enroll_existing_user", func(t *testing.T) {
+		w := enroll(adminCookie, "kimura")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeUserExists)
+	})
+
+	t.Run("test_enroll_not_admin", func(t *testing.T) {
+		code, err := totp.GenerateCodeCustom(regular.Secret, time.Now(), DefaultOTPOptions().validateOptsFor(regular))
+		assert.Nil(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kimura", code)
+		handler.ServeHTTP(w, r)
+
+		var regularCookie *http.Cookie
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "sess" {
+				regularCookie = cookie
+			}
+		}
+		assert.NotNil(t, regularCookie)
+
+		w = enroll(regularCookie, "misaki")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		_, found := users.Get("misaki")
+		assert.False(t, found)
+	})
+
+	t.Run("test_enroll_demoted_admin", func(t *testing.T) {
+		demoted := *admin
+		demoted.Role = RoleUser
+		assert.Nil(t, users.Save(&demoted))
+
+		w := enroll(adminCookie, "misaki")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestEnrollBackupCodes(t *testing.T) {
+	admin := defaultUser(DefaultOTPOptions())
+	admin.Role = RoleAdmin
+	handler := Configure(initializeTestRedis(), WithUserStore(NewMemoryUserStore(admin)), WithBackupCodes(3))
+	adminCookie := verifyTestUser(handler)
+
+	body := structToJSON(AuthRequestBody{Username: "hayase", Password: "password"})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/enroll", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.Header.Set("Content-Type", "application/json")
+	r.AddCookie(adminCookie)
+	r.AddCookie(&http.Cookie{Name: "csrf", Value: "token"})
+	r.Header.Set("X-CSRF-Token", "token")
+	handler.ServeHTTP(w, r)
+
+	response := struct {
+		Data struct {
+			BackupCodes []string `json:"backupCodes"`
+		} `json:"data"`
+	}{}
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Data.BackupCodes, 3)
+}
+
+func TestEnrollToken(t *testing.T) {
+	users := NewMemoryUserStore(defaultUser(DefaultOTPOptions()))
+	handler := Configure(initializeTestRedis(), WithUserStore(users), WithEnrollmentTokens(map[string]string{"invitation": "hayase", "another": "misaki"}))
+
+	enroll := func(token, username string) *httptest.ResponseRecorder {
+		body := structToJSON(AuthRequestBody{Username: username, Password: "password"})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/enroll", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	tests := []struct {
+		name         string
+		token        string
+		username     string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "test_enroll_without_token",
+			username:     "hayase",
+			expectedCode: http.StatusForbidden,
+			expectedBody: ErrorCodeInvalidCSRFToken,
+		},
+		{
+			name:         "test_enroll_invalid_token",
+			token:        "invalid",
+			username:     "hayase",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: ErrorCodeInvalidEnrollToken,
+		},
+		{
+			name:         "test_enroll_token_of_another_username",
+			token:        "invitation",
+			username:     "misaki",
+			expectedCode: http.StatusForbidden,
+			expectedBody: ErrorCodeInvalidEnrollToken,
+		},
+		{
+			name:         "test_enroll_with_token",
+			token:        "invitation",
+			username:     "hayase",
+			expectedCode: http.StatusCreated,
+			expectedBody: "otpauth://totp/",
+		},
+		{
+			name:         "test_enroll_token_used_again",
+			token:        "invitation",
+			username:     "hayase",
+			expectedCode: http.StatusConflict,
+			expectedBody: ErrorCodeUserExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := enroll(tt.token, tt.username)
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedBody)
+		})
+	}
+
+	t.Run("test_concurrent_enrollments", func(t *testing.T) {
+		var created int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if enroll("another", "misaki").Code == http.StatusCreated {
+					atomic.AddInt32(&created, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), created)
+	})
+}
+
+func TestEnrollConfirm(t *testing.T) {
+	pending := &User{
+		Username:      "hayase",
+		Password:      "hayase",
+		PendingSecret: base32.StdEncoding.EncodeToString([]byte("hayaseYUUKA")),
+	}
+	users := NewMemoryUserStore(pending)
+	handler := Configure(initializeTestRedis(), WithUserStore(users))
+
+	request := func(target string, body interface{}) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(structToJSON(body)))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+	credentials := AuthRequestBody{Username: "hayase", Password: "hayase"}
+
+	t.Run("test_login_before_confirm", func(t *testing.T) {
+		w := request("/api/v1/auth/login", credentials)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeNotEnrolled)
+	})
+
+	t.Run("test_confirm_wrong_code", func(t *testing.T) {
+		w := request("/api/v1/auth/enroll/confirm", EnrollConfirmRequestBody{AuthRequestBody: credentials, Code: "000000"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		user, _ := users.Get("hayase")
+		assert.Empty(t, user.Secret)
+		assert.Equal(t, pending.PendingSecret, user.PendingSecret)
+	})
+
+	t.Run("test_confirm_wrong_password", func(t *testing.T) {
+		code, err := totp.GenerateCodeCustom(pending.PendingSecret, time.Now(), DefaultOTPOptions().validateOptsFor(pending))
+		assert.Nil(t, err)
+		w := request("/api/v1/auth/enroll/confirm", EnrollConfirmRequestBody{AuthRequestBody: AuthRequestBody{Username: "hayase", Password: "wrong"}, Code: code})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		user, _ := users.Get("hayase")
+		assert.Empty(t, user.Secret)
+	})
+
+	t.Run("test_confirm_valid_code", func(t *testing.T) {
+		code, err := totp.GenerateCodeCustom(pending.PendingSecret, time.Now(), DefaultOTPOptions().validateOptsFor(pending))
+		assert.Nil(t, err)
+		w := request("/api/v1/auth/enroll/confirm", EnrollConfirmRequestBody{AuthRequestBody: credentials, Code: code})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		user, _ := users.Get("hayase")
+		assert.Equal(t, pending.PendingSecret, user.Secret)
+		assert.Empty(t, user.PendingSecret)
+	})
+
+	t.Run("test_login_after_confirm", func(t *testing.T) {
+		w := request("/api/v1/auth/login", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("test_confirm_twice", func(t *testing.T) {
+		w := request("/api/v1/auth/enroll/confirm", EnrollConfirmRequestBody{AuthRequestBody: credentials, Code: "000000"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeAlreadyEnrolled)
+	})
+}
+
+func TestEnrollConfirmLimits(t *testing.T) {
+	pending := &User{
+		Username:      "hayase",
+		Password:      "hayase",
+		PendingSecret: base32.StdEncoding.EncodeToString([]byte("hayaseYUUKA")),
+	}
+	credentials := AuthRequestBody{Username: "hayase", Password: "hayase"}
+
+	confirm := func(handler http.Handler, code string) *httptest.ResponseRecorder {
+		body := structToJSON(EnrollConfirmRequestBody{AuthRequestBody: credentials, Code: code})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/enroll/confirm", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+	validCode := func() string {
+		code, err := totp.GenerateCodeCustom(pending.PendingSecret, time.Now(), DefaultOTPOptions().validateOptsFor(pending))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		return code
+	}
+
+	t.Run("test_confirm_rate_limited", func(t *testing.T) {
+		handler := Configure(initializeTestRedis(), WithUserStore(NewMemoryUserStore(pending)), WithRateLimit(RateLimitOptions{MaxAttempts: 2, Window: time.Minute}))
+
+		assert.Equal(t, http.StatusUnauthorized, confirm(handler, "0000000000").Code)
+		assert.Equal(t, http.StatusUnauthorized, confirm(handler, "1111111111").Code)
+
+		w := confirm(handler, validCode())
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeRateLimited)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	})
+
+	t.Run("test_confirm_locks_account", func(t *testing.T) {
+		users := NewMemoryUserStore(pending)
+		handler := Configure(initializeTestRedis(), WithUserStore(users), WithLockout(LockoutOptions{MaxFailures: 2, Duration: 5 * time.Minute}))
+
+		assert.Equal(t, http.StatusUnauthorized, confirm(handler, "0000000000").Code)
+		assert.Equal(t, http.StatusUnauthorized, confirm(handler, "1111111111").Code)
+
+		w := confirm(handler, validCode())
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeAccountLocked)
+
+		user, _ := users.Get("hayase")
+		assert.Empty(t, user.Secret)
+	})
+}
+
+func TestAuditEvents(t *testing.T) {
+	store := session.NewMemoryStore(15*time.Minute, 0)
+	handler := Configure(nil, WithSessionStore(store))
+
+	login := func(password string) {
+		body := structToJSON(AuthRequestBody{Username: "kaede", Password: password})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+	}
+	verify := func(code string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+		w := httptest.NewRecorder()
+		r.SetBasicAuth("kaede", code)
+		handler.ServeHTTP(w, r)
+	}
+
+	login("wrong")
+	login("kaede")
+	verify("0000000000")
+	verify(generateTestOTP(DefaultOTPOptions()))
+
+	// Unknown users are audited as the empty user, so they do not get their own audit log.
+	body := structToJSON(AuthRequestBody{Username: "unknown", Password: "unknown"})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	events, err := store.GetAudit(context.Background(), "kaede", session.MaxAuditEvents)
+	assert.Nil(t, err)
+
+	var outcomes []string
+	for _, event := range events {
+		outcomes = append(outcomes, event.Type+":"+event.Outcome)
+	}
+	assert.Equal(t, []string{"verification:success", "verification:failure", "login:success", "login:failure"}, outcomes)
+
+	unknownEvents, err := store.GetAudit(context.Background(), "unknown", session.MaxAuditEvents)
+	assert.Nil(t, err)
+	assert.Empty(t, unknownEvents)
+
+	emptyUserEvents, err := store.GetAudit(context.Background(), "", session.MaxAuditEvents)
+	assert.Nil(t, err)
+	assert.Len(t, emptyUserEvents, 1)
+	assert.Equal(t, session.AuditOutcomeFailure, emptyUserEvents[0].Outcome)
+}
+
+// Session store that is unable to record any audit event.
+type failingAuditStore struct {
+	session.Store
+}
+
+func (s failingAuditStore) AppendAudit(ctx context.Context, userID string, event session.AuditEvent) error {
+	return errors.New("audit log is unavailable")
+}
+
+func TestAuditFailure(t *testing.T) {
+	handler := Configure(nil, WithSessionStore(failingAuditStore{Store: session.NewMemoryStore(15*time.Minute, 0)}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+	w := httptest.NewRecorder()
+	r.SetBasicAuth("kaede", generateTestOTP(DefaultOTPOptions()))
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Result().Cookies())
+}
+
+func TestAuditHandler(t *testing.T) {
+	regular := &User{
+		Username: "kimura",
+		Password: "kimura",
+		Secret:   base32.StdEncoding.EncodeToString([]byte("kimuraKAEDE")),
+	}
+	store := session.NewMemoryStore(15*time.Minute, 0)
+	handler := Configure(nil, WithSessionStore(store), WithUserStore(NewMemoryUserStore(defaultUser(DefaultOTPOptions()), regular)))
+
+	// Events of another user must never be returned.
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, store.AppendAudit(context.Background(), "kimura", session.AuditEvent{Type: session.AuditTypeLogin, IP: "10.0.0.1", Outcome: session.AuditOutcomeFailure}))
+	}
+	for i := 0; i < maxAuditLimit+5; i++ {
+		assert.Nil(t, store.AppendAudit(context.Background(), "kaede", session.AuditEvent{Type: session.AuditTypeLogin, Outcome: session.AuditOutcomeFailure}))
+	}
+	cookie := verifyTestUser(handler)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedEvents int
+	}{
+		{name: "test_default_limit", query: "", expectedStatus: http.StatusOK, expectedEvents: defaultAuditLimit},
+		{name: "test_custom_limit", query: "?limit=3", expectedStatus: http.StatusOK, expectedEvents: 3},
+		{name: "test_clamped_limit", query: "?limit=100000", expectedStatus: http.StatusOK, expectedEvents: maxAuditLimit},
+		{name: "test_invalid_limit", query: "?limit=abc", expectedStatus: http.StatusBadRequest},
+		{name: "test_zero_limit", query: "?limit=0", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/auth/audit"+tt.query, nil)
+			w := httptest.NewRecorder()
+			r.AddCookie(cookie)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			response := struct {
+				Data struct {
+					User   string               `json:"user"`
+					Events []session.AuditEvent `json:"events"`
+				} `json:"data"`
+			}{}
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, "kaede", response.Data.User)
+			assert.Len(t, response.Data.Events, tt.expectedEvents)
+			for _, event := range response.Data.Events {
+				assert.NotEqual(t, "10.0.0.1", event.IP)
+			}
+
+			// The most recent event is the verification that created the session.
+			assert.Equal(t, session.AuditTypeVerification, response.Data.Events[0].Type)
+			assert.Equal(t, session.AuditOutcomeSuccess, response.Data.Events[0].Outcome)
+		})
+	}
+
+	t.Run("test_audit_without_session", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/auth/audit", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeSessionNotFound)
+	})
+}
+
+func TestCurrentSession(t *testing.T) {
+	rdb := initializeTestRedis()
+	handler := Configure(rdb)
+	cookie := verifyTestUser(handler)
+
+	t.Run("test_current_session", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions/me", nil)
+		w := httptest.NewRecorder()
+		r.AddCookie(cookie)
+		handler.ServeHTTP(w, r)
+
+		response := struct {
+			Data struct {
+				SessionID string `json:"sessionId"`
+				UserID    string `json:"userId"`
+				Role      string `json:"role"`
+				TTL       int64  `json:"ttl"`
+			} `json:"data"`
+		}{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, cookie.Value, response.Data.SessionID)
+		assert.Equal(t, "kaede", response.Data.UserID)
+		assert.Equal(t, RoleUser, response.Data.Role)
+		assert.Greater(t, response.Data.TTL, int64(0))
+	})
+
+	t.Run("test_current_session_without_cookie", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions/me", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestValidateSession(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer mr.Close()
+	handler := Configure(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	cookie := verifyTestUser(handler)
+
+	tests := []struct {
+		name           string
+		cookie         *http.Cookie
+		expectedStatus int
+		expectedUser   string
+	}{
+		{
+			name:           "test_validate_valid_cookie",
+			cookie:         cookie,
+			expectedStatus: http.StatusOK,
+			expectedUser:   "kaede",
+		},
+		{
+			name:           "test_validate_invalid_cookie",
+			cookie:         &http.Cookie{Name: "sess", Value: "invalid"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "test_validate_without_cookie",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr.SetTTL("sess:"+cookie.Value, time.Minute)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/auth/validate", nil)
+			w := httptest.NewRecorder()
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			handler.ServeHTTP(w, r)
+
+			response := struct {
+				Data struct {
+					User string `json:"user"`
+				} `json:"data"`
+			}{}
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedUser, response.Data.User)
+			assert.Empty(t, w.Result().Cookies())
+
+			// The session is not refreshed.
+			assert.Equal(t, time.Minute, mr.TTL("sess:"+cookie.Value))
+		})
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer mr.Close()
+	handler := Configure(redis.NewClient(&redis.Options{Addr: mr.Addr()}), WithMaxSessions(2))
+
+	// Sessions that were created earlier, the oldest one first.
+	mr.HSet("sess:oldest", "userId", "kaede", "createdAt", "1000", "role", RoleUser)
+	mr.HSet("sess:older", "userId", "kaede", "createdAt", "2000", "role", RoleUser)
+	mr.HSet("sess:another", "userId", "kimura", "createdAt", "500", "role", RoleUser)
+	mr.ZAdd("sess:user_sessions:kaede", 1000, "oldest")
+	mr.ZAdd("sess:user_sessions:kaede", 2000, "older")
+	mr.ZAdd("sess:user_sessions:kimura", 500, "another")
+
+	cookie := verifyTestUser(handler)
+
+	assert.False(t, mr.Exists("sess:oldest"))
+	assert.True(t, mr.Exists("sess:older"))
+	assert.True(t, mr.Exists("sess:"+cookie.Value))
+	assert.True(t, mr.Exists("sess:another"))
+}
+
+func TestCookieName(t *testing.T) {
+	rdb := initializeTestRedis()
+	handler := Configure(rdb, WithCookieName("otp_sess"))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verification", nil)
+	w := httptest.NewRecorder()
+	r.SetBasicAuth("kaede", generateTestOTP(DefaultOTPOptions()))
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var sessionCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		assert.NotEqual(t, "sess", cookie.Name)
+		if cookie.Name == "otp_sess" {
+			sessionCookie = cookie
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatal("Session cookie with the custom name is not found!")
+	}
+
+	tests := []struct {
+		name           string
+		cookieName     string
+		expectedStatus int
+	}{
+		{
+			name:           "test_custom_cookie_name",
+			cookieName:     "otp_sess",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "test_default_cookie_name",
+			cookieName:     "sess",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+			w := httptest.NewRecorder()
+			r.AddCookie(&http.Cookie{Name: tt.cookieName, Value: sessionCookie.Value})
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestInvalidCookieName(t *testing.T) {
+	for _, name := range []string{"", "otp sess", "otp;sess", "séss"} {
+		t.Run(fmt.Sprintf("test_invalid_cookie_name_%q", name), func(t *testing.T) {
+			handler := Configure(initializeTestRedis(), WithCookieName(name))
+
+			// The default name is kept, so the session cookie is still set.
+			cookie := verifyTestUser(handler)
+			assert.Equal(t, "sess", cookie.Name)
+		})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	rdb := initializeTestRedis()
+	handler := Configure(rdb)
+
+	// OTPs are the same in a period, so a different OTP is stored beforehand to tell the keys apart.
+	if err := rdb.Set(context.Background(), "sess:idem:kaede:key-a", "1111111111", time.Minute).Err(); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	login := func(idempotencyKey string) (int, string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kaede","password":"kaede"}`))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", idempotencyKey)
+		handler.ServeHTTP(w, r)
+
+		response := &struct {
+			Data struct {
+				OTP string `json:"otp"`
+			} `json:"data"`
+		}{}
+		if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		return w.Code, response.Data.OTP
+	}
+
+	t.Run("test_same_idempotency_key", func(t *testing.T) {
+		firstStatus, firstOTP := login("key-a")
+		secondStatus, secondOTP := login("key-a")
+
+		assert.Equal(t, http.StatusOK, firstStatus)
+		assert.Equal(t, http.StatusOK, secondStatus)
+		assert.Equal(t, "1111111111", firstOTP)
+		assert.Equal(t, firstOTP, secondOTP)
+	})
+
+	t.Run("test_different_idempotency_keys", func(t *testing.T) {
+		_, firstOTP := login("key-a")
+		status, secondOTP := login("key-b")
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.NotEqual(t, firstOTP, secondOTP)
+		assert.Equal(t, int64(1), rdb.Exists(context.Background(), "sess:idem:kaede:key-b").Val())
+	})
+
+	t.Run("test_otp_remembered_until_end_of_period", func(t *testing.T) {
+		login("key-c")
+		remaining := time.Duration(rfcotp.SecondsRemaining(time.Now().Unix(), int64(DefaultOTPOptions().Period))) * time.Second
+
+		ttl := rdb.TTL(context.Background(), "sess:idem:kaede:key-c").Val()
+		assert.Greater(t, ttl, time.Duration(0))
+		assert.LessOrEqual(t, ttl, remaining)
+	})
+
+	t.Run("test_idempotency_key_too_long", func(t *testing.T) {
+		status, _ := login(strings.Repeat("a", maxIdempotencyKeyLength+1))
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
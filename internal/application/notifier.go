@@ -0,0 +1,34 @@
+package application
+
+import "context"
+
+// Notifier is used to deliver OTPs to users out-of-band, such as via SMS or email.
+type Notifier interface {
+	Send(ctx context.Context, userID, otp string) error
+}
+
+// DevNotifier is a no-op notifier for development, which is used by default.
+// As OTPs are not delivered anywhere, the login route returns the OTP in its response instead.
+type DevNotifier struct{}
+
+// Send does nothing, as the OTP is returned in the login response.
+func (DevNotifier) Send(ctx context.Context, userID, otp string) error {
+	return nil
+}
+
+// Development reports that the OTPs are not delivered anywhere, so they have to be returned in the login response.
+func (DevNotifier) Development() bool {
+	return true
+}
+
+// developmentNotifier is implemented by notifiers that do not deliver the OTPs, such as 'DevNotifier' and wrappers of it.
+type developmentNotifier interface {
+	Development() bool
+}
+
+// Utility function to check if OTPs are not delivered out-of-band, and thus have to be returned to the client.
+// Notifiers are recognized by their 'Development' method instead of their type, so pointers work as well.
+func isDevNotifier(notifier Notifier) bool {
+	dev, ok := notifier.(developmentNotifier)
+	return ok && dev.Development()
+}
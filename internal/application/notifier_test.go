@@ -0,0 +1,113 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Mock notifier, which records every OTP it is asked to send.
+type mockNotifier struct {
+	userIDs []string
+	otps    []string
+	err     error
+}
+
+func (m *mockNotifier) Send(ctx context.Context, userID, otp string) error {
+	m.userIDs = append(m.userIDs, userID)
+	m.otps = append(m.otps, otp)
+	return m.err
+}
+
+func TestNotifier(t *testing.T) {
+	login := func(handler http.Handler) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kaede","password":"kaede"}`))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("test_dev_notifier_returns_otp", func(t *testing.T) {
+		w := login(Configure(initializeTestRedis()))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"otp"`)
+	})
+
+	t.Run("test_dev_notifier_pointer_returns_otp", func(t *testing.T) {
+		w := login(Configure(initializeTestRedis(), WithNotifier(&DevNotifier{})))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"otp"`)
+	})
+
+	t.Run("test_nil_notifier_is_ignored", func(t *testing.T) {
+		w := login(Configure(initializeTestRedis(), WithNotifier(nil)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"otp"`)
+	})
+
+	t.Run("test_notifier_sends_otp", func(t *testing.T) {
+		notifier := &mockNotifier{}
+		w := login(Configure(initializeTestRedis(), WithNotifier(notifier)))
+
+		response := struct {
+			Data map[string]interface{} `json:"data"`
+		}{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"kaede"}, notifier.userIDs)
+		assert.Equal(t, []string{generateTestOTP(DefaultOTPOptions())}, notifier.otps)
+		assert.NotContains(t, response.Data, "otp")
+		assert.NotContains(t, response.Data, "basicAuth")
+		assert.NotContains(t, response.Data, "decodedBasic")
+		assert.NotContains(t, response.Data, "sharedSecret")
+		assert.NotContains(t, w.Body.String(), notifier.otps[0])
+	})
+
+	t.Run("test_notifier_fails", func(t *testing.T) {
+		notifier := &mockNotifier{err: errors.New("SMS gateway is down!")}
+		w := login(Configure(initializeTestRedis(), WithNotifier(notifier)))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusInternalServerError, "Internal server error! Please try again later!")), withoutRequestID(w.Body.String()))
+	})
+}
+
+func TestNotifierIdempotencyKey(t *testing.T) {
+	login := func(handler http.Handler) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"username":"kaede","password":"kaede"}`))
+		w := httptest.NewRecorder()
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "key")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("test_replayed_otp_is_not_sent_again", func(t *testing.T) {
+		notifier := &mockNotifier{}
+		handler := Configure(initializeTestRedis(), WithNotifier(notifier))
+
+		assert.Equal(t, http.StatusOK, login(handler).Code)
+		assert.Equal(t, http.StatusOK, login(handler).Code)
+		assert.Len(t, notifier.otps, 1)
+	})
+
+	t.Run("test_failed_otp_is_sent_on_retry", func(t *testing.T) {
+		notifier := &mockNotifier{err: errors.New("SMS gateway is down!")}
+		handler := Configure(initializeTestRedis(), WithNotifier(notifier))
+
+		assert.Equal(t, http.StatusInternalServerError, login(handler).Code)
+		notifier.err = nil
+		assert.Equal(t, http.StatusOK, login(handler).Code)
+		assert.Len(t, notifier.otps, 2)
+	})
+}
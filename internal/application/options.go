@@ -0,0 +1,384 @@
+package application
+
+import (
+	"encoding/base32"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lauslim12/fullstack-otp/internal/config"
+	rfcotp "github.com/lauslim12/fullstack-otp/internal/otp"
+	"github.com/lauslim12/fullstack-otp/internal/session"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OTPOptions is used to configure how OTPs are generated and validated in the handlers.
+type OTPOptions struct {
+	Period    uint          // Period of the token in seconds.
+	Skew      uint          // How many periods before and after the current one should be tolerated.
+	Digits    otp.Digits    // Digits requested for the OTP.
+	Algorithm otp.Algorithm // Hash algorithm for the OTP.
+}
+
+// DefaultOTPOptions returns the default OTP options of the application.
+func DefaultOTPOptions() OTPOptions {
+	return OTPOptions{
+		Period:    30,
+		Skew:      1,
+		Digits:    10,
+		Algorithm: otp.AlgorithmSHA512,
+	}
+}
+
+// Utility function to create the validation options for a user, as every user may have their own digits and algorithm.
+func (o OTPOptions) validateOptsFor(user *User) totp.ValidateOpts {
+	digits := user.Digits
+	if digits == 0 {
+		digits = otp.DigitsSix
+	}
+
+	return totp.ValidateOpts{
+		Period:    o.Period,
+		Skew:      o.Skew,
+		Digits:    digits,
+		Algorithm: user.Algorithm,
+	}
+}
+
+// RateLimitOptions is used to limit the verification attempts of a user in a fixed window.
+type RateLimitOptions struct {
+	MaxAttempts int64         // Maximum number of verification attempts in a window. Zero disables rate limiting.
+	Window      time.Duration // Duration of the window, starting from the first attempt.
+}
+
+// LockoutOptions is used to lock the account of a user temporarily after too many failed verifications.
+type LockoutOptions struct {
+	MaxFailures int64         // Failed verifications before the account is locked. Zero disables the lockout.
+	Duration    time.Duration // How long the account is locked, and how long failures are counted for.
+}
+
+// Option is used to customize the application when calling 'Configure'.
+type Option func(*options)
+
+// options represents all of the customizable parts of the application.
+type options struct {
+	otp            OTPOptions
+	maxBodyBytes   int64
+	cors           CORSOptions
+	secureCookies  bool
+	users          UserStore
+	logger         *slog.Logger
+	metrics        *metrics
+	sessionTTL     time.Duration
+	sessionPrefix  string
+	strictAccept   bool
+	compress       bool
+	rateLimit      RateLimitOptions
+	notifier       Notifier
+	requestTimeout time.Duration
+	sessionStore   session.Store
+	realm          string
+	lockout        LockoutOptions
+	requireHTTPS   bool
+	trustedProxies []*net.IPNet
+	maxSessions    int
+	cookieName     string
+	strictJSON     bool
+	backupCodes    int
+	enrollTokens   map[string]string
+	validateOTP    func(passcode string, secret string, t time.Time, opts totp.ValidateOpts) (bool, int64, error)
+}
+
+// Utility function to create the default options, and then apply all of the passed options on top of it.
+func newOptions(opts ...Option) *options {
+	o := &options{
+		otp:            DefaultOTPOptions(),
+		maxBodyBytes:   512,
+		cors:           DefaultCORSOptions(),
+		secureCookies:  true,
+		sessionTTL:     15 * time.Minute,
+		sessionPrefix:  session.DefaultPrefix,
+		compress:       true,
+		notifier:       DevNotifier{},
+		requestTimeout: 10 * time.Second,
+		realm:          "restricted",
+		cookieName:     "sess",
+		strictJSON:     true,
+		backupCodes:    rfcotp.BackupCodesCount,
+		validateOTP:    validateWithOffset,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// The default user follows the configured OTP options.
+	if o.users == nil {
+		o.users = NewMemoryUserStore(defaultUser(o.otp))
+	}
+
+	return o
+}
+
+// WithOTPOptions is used to set the OTP options used by both the login and the verification handlers.
+// Digits and algorithm are only used for the default user, as users carry their own.
+func WithOTPOptions(otpOptions OTPOptions) Option {
+	return func(o *options) {
+		o.otp = otpOptions
+	}
+}
+
+// WithMaxBodyBytes is used to set the maximum size of a JSON request body in bytes.
+func WithMaxBodyBytes(maxBytes int64) Option {
+	return func(o *options) {
+		o.maxBodyBytes = maxBytes
+	}
+}
+
+// WithCORS is used to allow cross-origin requests to access the API.
+func WithCORS(corsOptions CORSOptions) Option {
+	return func(o *options) {
+		o.cors = corsOptions
+	}
+}
+
+// WithSecureCookies is used to toggle the 'Secure' attribute of the cookies. Disable it to test over plain HTTP.
+func WithSecureCookies(secure bool) Option {
+	return func(o *options) {
+		o.secureCookies = secure
+	}
+}
+
+// WithUserStore is used to set the store of the users that are able to log in.
+func WithUserStore(users UserStore) Option {
+	return func(o *options) {
+		o.users = users
+	}
+}
+
+// WithSessionTTL is used to set how long a session lasts, both in the Redis and in the cookies.
+// Non-positive durations would expire the sessions right away, so they are ignored, and the default is kept.
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		if ttl > 0 {
+			o.sessionTTL = ttl
+		}
+	}
+}
+
+// WithSessionStore is used to store the sessions somewhere other than the Redis, such as 'session.MemoryStore'.
+// The store keeps the expiration and the prefix it was created with, as 'WithSessionTTL' and 'WithSessionPrefix' only
+// apply to the default Redis store. Create the store with the same TTL as 'WithSessionTTL', so the sessions do not
+// expire before or after their cookies. The store is closed when the application is shut down.
+func WithSessionStore(store session.Store) Option {
+	return func(o *options) {
+		o.sessionStore = store
+	}
+}
+
+// WithMaxSessions is used to limit the concurrent sessions of a user. Once the limit is reached,
+// the oldest sessions are evicted to make room for the new one. Zero disables the limit, which is the default.
+func WithMaxSessions(maxSessions int) Option {
+	return func(o *options) {
+		o.maxSessions = maxSessions
+	}
+}
+
+// WithEnrollmentTokens is used to allow self-service enrollment with pre-enrollment tokens, which map each token to the
+// only username it is able to enroll. Tokens are sent as 'Authorization: Bearer <token>'. Without any tokens, only
+// admins are able to enroll users, which is the default. A token cannot be used again once its user exists.
+func WithEnrollmentTokens(tokens map[string]string) Option {
+	return func(o *options) {
+		o.enrollTokens = make(map[string]string, len(tokens))
+		for token, username := range tokens {
+			if token != "" && username != "" {
+				o.enrollTokens[token] = username
+			}
+		}
+	}
+}
+
+// WithBackupCodes is used to set the number of backup codes created when a user is enrolled. Defaults to 10.
+// Zero disables the backup codes, and negative numbers are ignored.
+func WithBackupCodes(count int) Option {
+	return func(o *options) {
+		if count >= 0 {
+			o.backupCodes = count
+		}
+	}
+}
+
+// WithCookieName is used to set the name of the session cookie, so it does not collide with the cookies of other
+// services on the same domain. Defaults to 'sess'. Empty or invalid names would be dropped silently when the cookie is
+// set, so they are ignored, and the default is kept.
+func WithCookieName(name string) Option {
+	return func(o *options) {
+		if isValidCookieName(name) {
+			o.cookieName = name
+		}
+	}
+}
+
+// Utility function to check if a cookie name is a token, as described in RFC 6265.
+func isValidCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WithSessionPrefix is used to set the prefix of the session keys in the Redis. The other keys of the users, such as
+// the attempts, the backup codes, and the audit events, are stored under the prefix as well. On a Redis Cluster, use a
+// hash tag such as '{sess}:', as the session limit of 'WithMaxSessions' uses several keys in a single script.
+func WithSessionPrefix(prefix string) Option {
+	return func(o *options) {
+		o.sessionPrefix = prefix
+	}
+}
+
+// WithLogger is used to log requests as structured fields. If not set, requests are logged as plain text.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMetrics is used to register the authentication metrics to the given registry, and expose them at '/metrics'.
+// Metrics are disabled by default, so multiple applications will not collide in a global registry. A nil registry is ignored.
+func WithMetrics(registry *prometheus.Registry) Option {
+	return func(o *options) {
+		if registry != nil {
+			o.metrics = newMetrics(registry)
+		}
+	}
+}
+
+// WithStrictAccept is used to reject API requests with an 'Accept' header that does not allow JSON with 406.
+// Disabled by default, so existing clients that send arbitrary 'Accept' headers will not break.
+func WithStrictAccept(strict bool) Option {
+	return func(o *options) {
+		o.strictAccept = strict
+	}
+}
+
+// WithStrictJSON is used to toggle the rejection of unknown fields in JSON request bodies with 400.
+// Enabled by default. Disable it, so clients that send newer fields are able to talk to an older server.
+func WithStrictJSON(strict bool) Option {
+	return func(o *options) {
+		o.strictJSON = strict
+	}
+}
+
+// Utility option to swap the validation function of the OTP, so tests are able to observe validations.
+func withValidateOTP(validate func(passcode string, secret string, t time.Time, opts totp.ValidateOpts) (bool, int64, error)) Option {
+	return func(o *options) {
+		o.validateOTP = validate
+	}
+}
+
+// WithCompression is used to toggle the gzip compression of JSON responses, for clients that support it.
+func WithCompression(compress bool) Option {
+	return func(o *options) {
+		o.compress = compress
+	}
+}
+
+// WithRateLimit is used to limit the verification attempts of a user. Disabled by default.
+func WithRateLimit(rateLimit RateLimitOptions) Option {
+	return func(o *options) {
+		o.rateLimit = rateLimit
+	}
+}
+
+// WithLockout is used to lock the account of a user after too many failed verifications, even for valid OTPs.
+// Disabled by default. A lockout with a non-positive duration would never expire, so it is ignored.
+func WithLockout(lockout LockoutOptions) Option {
+	return func(o *options) {
+		if lockout.Duration > 0 {
+			o.lockout = lockout
+		}
+	}
+}
+
+// WithNotifier is used to deliver the OTPs of the login route out-of-band. Unless it is a 'DevNotifier',
+// the OTP and the shared secret are omitted from the login response. A nil notifier is ignored.
+func WithNotifier(notifier Notifier) Option {
+	return func(o *options) {
+		if notifier != nil {
+			o.notifier = notifier
+		}
+	}
+}
+
+// WithRequestTimeout is used to set how long a request may take before its operations are cancelled.
+// Timed out requests are responded with 503. Set to zero to disable the timeout.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithRequireHTTPS is used to refuse plaintext HTTP requests to the authentication routes, as they set the cookies.
+// Disabled by default, so the application is able to be tested locally. Use 'WithTrustedProxies' behind a TLS-terminating proxy.
+func WithRequireHTTPS(require bool) Option {
+	return func(o *options) {
+		o.requireHTTPS = require
+	}
+}
+
+// WithTrustedProxies is used to set the proxies, as CIDRs or single IPs, that are trusted to set 'X-Forwarded-Proto'
+// when HTTPS is required. Without any, which is the default, only direct TLS connections are allowed. Invalid entries
+// are ignored.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(o *options) {
+		for _, proxy := range proxies {
+			if !strings.Contains(proxy, "/") {
+				if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+					proxy += "/32"
+				} else if ip != nil {
+					proxy += "/128"
+				}
+			}
+
+			if _, network, err := net.ParseCIDR(proxy); err == nil {
+				o.trustedProxies = append(o.trustedProxies, network)
+			}
+		}
+	}
+}
+
+// WithRealm is used to set the realm of the 'WWW-Authenticate' header, sent when the basic authentication is missing.
+func WithRealm(realm string) Option {
+	return func(o *options) {
+		o.realm = realm
+	}
+}
+
+// WithConfig is used to apply the configurations loaded from the environment variables.
+// The expected user is created with the configured credentials and OTP options.
+func WithConfig(cfg config.Config) Option {
+	return func(o *options) {
+		o.sessionTTL = cfg.SessionTTL
+		o.otp.Period = cfg.OTPPeriod
+		o.otp.Digits = cfg.OTPDigits
+		o.otp.Algorithm = cfg.OTPAlgorithm
+		o.users = NewMemoryUserStore(&User{
+			Username:  cfg.Username,
+			Password:  cfg.Password,
+			Secret:    base32.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%s", cfg.Username, cfg.SharedSecret))),
+			Digits:    cfg.OTPDigits,
+			Algorithm: cfg.OTPAlgorithm,
+		})
+	}
+}
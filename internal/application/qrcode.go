@@ -0,0 +1,36 @@
+package application
+
+import (
+	"bytes"
+	"image/png"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+)
+
+// Default, minimum, and maximum sizes of a QR code in pixels.
+const (
+	defaultQRCodeSize = 256
+	minQRCodeSize     = 64
+	maxQRCodeSize     = 1024
+)
+
+// Utility function to render a string as a square PNG QR code with the given size in pixels.
+func renderQRCode(content string, size int) ([]byte, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err = barcode.Scale(code, size, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
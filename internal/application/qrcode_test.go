@@ -0,0 +1,72 @@
+package application
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQRCodeHandler(t *testing.T) {
+	handler := Configure(initializeTestRedis())
+	sessionCookie := verifyTestUser(handler)
+
+	successTests := []struct {
+		name         string
+		route        string
+		expectedSize int
+	}{
+		{
+			name:         "test_default_size",
+			route:        "/api/v1/auth/qr",
+			expectedSize: defaultQRCodeSize,
+		},
+		{
+			name:         "test_requested_size",
+			route:        "/api/v1/auth/qr?size=300",
+			expectedSize: 300,
+		},
+		{
+			name:         "test_capped_size",
+			route:        "/api/v1/auth/qr?size=5000",
+			expectedSize: maxQRCodeSize,
+		},
+	}
+
+	for _, tt := range successTests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.route, nil)
+			w := httptest.NewRecorder()
+			r.AddCookie(sessionCookie)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+			image, err := png.Decode(w.Body)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedSize, image.Bounds().Dx())
+			assert.Equal(t, tt.expectedSize, image.Bounds().Dy())
+		})
+	}
+
+	t.Run("test_invalid_size", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/auth/qr?size=abc", nil)
+		w := httptest.NewRecorder()
+		r.AddCookie(sessionCookie)
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, structToJSON(NewFailureResponse(http.StatusBadRequest, "Size of the QR code must be a number that is at least 64 pixels!")), withoutRequestID(w.Body.String()))
+	})
+
+	t.Run("test_without_session", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/auth/qr", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,52 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisOptions is used to configure the connection pool of the Redis client.
+type RedisOptions struct {
+	Address      string        // Address of the Redis, as 'host:port'.
+	Password     string        // Password of the Redis. Empty if there is no password.
+	DB           int           // Database of the Redis to be selected.
+	PoolSize     int           // Maximum number of connections in the pool.
+	DialTimeout  time.Duration // How long establishing a new connection may take.
+	ReadTimeout  time.Duration // How long reading a reply may take.
+	WriteTimeout time.Duration // How long writing a command may take.
+}
+
+// DefaultRedisOptions returns the default Redis options, connecting to a local Redis.
+func DefaultRedisOptions() RedisOptions {
+	return RedisOptions{
+		Address:      "localhost:6379",
+		PoolSize:     10,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+	}
+}
+
+// NewRedis creates a new Redis client, and pings the Redis to make sure it is reachable before serving any requests.
+// The client is closed if the Redis cannot be reached.
+func NewRedis(opts RedisOptions) (*redis.Client, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         opts.Address,
+		Password:     opts.Password,
+		DB:           opts.DB,
+		PoolSize:     opts.PoolSize,
+		DialTimeout:  opts.DialTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+	})
+
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("redis at '%s' is unreachable: %w", opts.Address, err)
+	}
+
+	return rdb, nil
+}
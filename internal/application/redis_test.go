@@ -0,0 +1,39 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRedis(t *testing.T) {
+	t.Run("test_new_redis_reachable", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		assert.Nil(t, err)
+		defer mr.Close()
+		opts := DefaultRedisOptions()
+		opts.Address = mr.Addr()
+		opts.PoolSize = 3
+
+		rdb, err := NewRedis(opts)
+		assert.Nil(t, err)
+		defer rdb.Close()
+
+		assert.Equal(t, 3, rdb.Options().PoolSize)
+		assert.Nil(t, rdb.Ping(context.Background()).Err())
+	})
+
+	t.Run("test_new_redis_unreachable", func(t *testing.T) {
+		opts := DefaultRedisOptions()
+		opts.Address = "127.0.0.1:0"
+		opts.DialTimeout = 100 * time.Millisecond
+
+		rdb, err := NewRedis(opts)
+		assert.NotNil(t, err)
+		assert.Nil(t, rdb)
+		assert.Contains(t, err.Error(), "127.0.0.1:0")
+	})
+}
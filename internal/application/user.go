@@ -0,0 +1,123 @@
+package application
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pquerna/otp"
+)
+
+// Roles of the users. Admins are able to see the sessions of every user.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// User represents a user that is able to log in to the application.
+// Zero values of 'Digits' and 'Algorithm' default to 6 digits and SHA1, just like common authenticator apps.
+type User struct {
+	Username      string        // Username of the user.
+	Password      string        // Password of the user.
+	Secret        string        // OTP shared secret (base32 encoded). Empty until the enrollment is confirmed.
+	PendingSecret string        // OTP shared secret (base32 encoded) of an enrollment that is not confirmed yet.
+	Digits        otp.Digits    // Digits of the OTP of the user.
+	Algorithm     otp.Algorithm // Hash algorithm of the OTP of the user.
+	Role          string        // Role of the user. Defaults to 'RoleUser' if empty.
+}
+
+// Utility function to get the role of the user, defaulting to a regular user.
+func (u *User) role() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+
+	return u.Role
+}
+
+// Utility function to check if the user has an activated secret, as users without one must not be able to log in.
+func (u *User) enrolled() bool {
+	return u.Secret != ""
+}
+
+// ErrUserExists is returned by 'Create' if a user with the same username already exists.
+var ErrUserExists = errors.New("user already exists")
+
+// UserStore is used to look up and update the users of the application.
+// 'Create' must check and store the user atomically, so concurrent enrollments of a username cannot both succeed.
+type UserStore interface {
+	Get(username string) (*User, bool)
+	Create(user *User) error
+	Save(user *User) error
+}
+
+// MemoryUserStore is an in-memory implementation of 'UserStore'.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// NewMemoryUserStore creates a new in-memory user store with the given users.
+func NewMemoryUserStore(users ...*User) *MemoryUserStore {
+	store := &MemoryUserStore{users: make(map[string]*User)}
+	for _, user := range users {
+		store.users[user.Username] = user
+	}
+
+	return store
+}
+
+// Get is used to find a user by their username.
+func (s *MemoryUserStore) Get(username string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[username]
+	return user, ok
+}
+
+// Create is used to store a new user, only if there is no user with the same username yet.
+// Returns 'ErrUserExists' otherwise.
+func (s *MemoryUserStore) Create(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.Username]; ok {
+		return ErrUserExists
+	}
+
+	s.users[user.Username] = user
+	return nil
+}
+
+// Save is used to create or replace a user, identified by their username.
+func (s *MemoryUserStore) Save(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.Username] = user
+	return nil
+}
+
+// Utility function to create the default user of the application.
+// The secret is the username concatenated with 'KIMURA' for now.
+func defaultUser(otpOptions OTPOptions) *User {
+	return &User{
+		Username:  "kaede",
+		Password:  "kaede",
+		Secret:    base32.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%s", "kaede", "KIMURA"))),
+		Digits:    otpOptions.Digits,
+		Algorithm: otpOptions.Algorithm,
+	}
+}
+
+// Utility function to create a dummy user, used to validate OTPs of unknown users to equalize timing.
+// The dummy user has no username, so it will never match.
+func dummyUser(otpOptions OTPOptions) *User {
+	return &User{
+		Secret:    base32.StdEncoding.EncodeToString([]byte("DUMMYSECRET")),
+		Digits:    otpOptions.Digits,
+		Algorithm: otpOptions.Algorithm,
+	}
+}
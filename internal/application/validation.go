@@ -0,0 +1,46 @@
+package application
+
+import "fmt"
+
+// Length bounds of the fields of an authentication request body.
+const (
+	maxUsernameLength = 64
+	maxPasswordLength = 128
+)
+
+// FieldError represents a single invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate checks every field of the authentication request body, and returns all of the violations at once,
+// so clients are able to show every error without resubmitting. Returns nil if the body is valid.
+func (b *AuthRequestBody) Validate() []FieldError {
+	var fieldErrors []FieldError
+	fieldErrors = appendLengthError(fieldErrors, "username", b.Username, maxUsernameLength)
+	fieldErrors = appendLengthError(fieldErrors, "password", b.Password, maxPasswordLength)
+
+	return fieldErrors
+}
+
+// Validate checks the credentials and the OTP of the enrollment confirmation request body at once.
+// Returns nil if the body is valid.
+func (b *EnrollConfirmRequestBody) Validate() []FieldError {
+	fieldErrors := b.AuthRequestBody.Validate()
+	fieldErrors = appendLengthError(fieldErrors, "code", b.Code, maxPasscodeLength)
+
+	return fieldErrors
+}
+
+// Utility function to append a field error if a field is empty or longer than its maximum length.
+func appendLengthError(fieldErrors []FieldError, field, value string, maxLength int) []FieldError {
+	switch {
+	case value == "":
+		return append(fieldErrors, FieldError{Field: field, Message: fmt.Sprintf("Field '%s' must not be empty!", field)})
+	case len(value) > maxLength:
+		return append(fieldErrors, FieldError{Field: field, Message: fmt.Sprintf("Field '%s' must not be longer than %d characters!", field, maxLength)})
+	default:
+		return fieldErrors
+	}
+}
@@ -0,0 +1,85 @@
+package application
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthRequestBodyValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *AuthRequestBody
+		expected []FieldError
+	}{
+		{
+			name:     "test_valid_body",
+			input:    &AuthRequestBody{Username: "kaede", Password: "kaede"},
+			expected: nil,
+		},
+		{
+			name:  "test_empty_username_and_password",
+			input: &AuthRequestBody{},
+			expected: []FieldError{
+				{Field: "username", Message: "Field 'username' must not be empty!"},
+				{Field: "password", Message: "Field 'password' must not be empty!"},
+			},
+		},
+		{
+			name:  "test_too_long_username",
+			input: &AuthRequestBody{Username: strings.Repeat("a", 65), Password: "kaede"},
+			expected: []FieldError{
+				{Field: "username", Message: "Field 'username' must not be longer than 64 characters!"},
+			},
+		},
+		{
+			name:  "test_too_long_password",
+			input: &AuthRequestBody{Username: "kaede", Password: strings.Repeat("a", 129)},
+			expected: []FieldError{
+				{Field: "password", Message: "Field 'password' must not be longer than 128 characters!"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.input.Validate())
+		})
+	}
+}
+
+func TestEnrollConfirmRequestBodyValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *EnrollConfirmRequestBody
+		expected []FieldError
+	}{
+		{
+			name:     "test_valid_body",
+			input:    &EnrollConfirmRequestBody{AuthRequestBody: AuthRequestBody{Username: "kaede", Password: "kaede"}, Code: "123456"},
+			expected: nil,
+		},
+		{
+			name:  "test_missing_code",
+			input: &EnrollConfirmRequestBody{AuthRequestBody: AuthRequestBody{Username: "kaede", Password: "kaede"}},
+			expected: []FieldError{
+				{Field: "code", Message: "Field 'code' must not be empty!"},
+			},
+		},
+		{
+			name:  "test_too_long_code",
+			input: &EnrollConfirmRequestBody{AuthRequestBody: AuthRequestBody{Password: "kaede"}, Code: strings.Repeat("1", 65)},
+			expected: []FieldError{
+				{Field: "username", Message: "Field 'username' must not be empty!"},
+				{Field: "code", Message: "Field 'code' must not be longer than 64 characters!"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.input.Validate())
+		})
+	}
+}
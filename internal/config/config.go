@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+)
+
+// Config represents all of the configurations of the application, read from the environment variables.
+type Config struct {
+	RedisAddress  string        // Address of the Redis server.
+	RedisPassword string        // Password of the Redis server.
+	SessionTTL    time.Duration // How long a session lives.
+	OTPPeriod     uint          // Period of the OTP in seconds.
+	OTPDigits     otp.Digits    // Digits of the OTP.
+	OTPAlgorithm  otp.Algorithm // Hash algorithm of the OTP.
+	SharedSecret  string        // Shared secret, concatenated with the username to derive the OTP secret.
+	Username      string        // Username of the expected user.
+	Password      string        // Password of the expected user.
+}
+
+// Utility function to get an environment variable, or the fallback value if it does not exist.
+func getEnv(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
+
+// Utility function to parse the name of a hash algorithm.
+func parseAlgorithm(name string) (otp.Algorithm, error) {
+	switch strings.ToUpper(name) {
+	case "SHA1":
+		return otp.AlgorithmSHA1, nil
+	case "SHA256":
+		return otp.AlgorithmSHA256, nil
+	case "SHA512":
+		return otp.AlgorithmSHA512, nil
+	default:
+		return 0, fmt.Errorf("config: unknown OTP_ALGORITHM %q, must be SHA1, SHA256, or SHA512", name)
+	}
+}
+
+// Load is used to read and validate the configurations from the environment variables.
+// Every configuration has a default value, which matches the development environment.
+func Load() (Config, error) {
+	sessionTTL, err := time.ParseDuration(getEnv("SESSION_TTL", "15m"))
+	if err != nil || sessionTTL <= 0 {
+		return Config{}, fmt.Errorf("config: SESSION_TTL must be a positive duration, such as '15m'")
+	}
+
+	period, err := strconv.ParseUint(getEnv("OTP_PERIOD", "30"), 10, 32)
+	if err != nil || period == 0 {
+		return Config{}, fmt.Errorf("config: OTP_PERIOD must be a positive number of seconds")
+	}
+
+	digits, err := strconv.Atoi(getEnv("OTP_DIGITS", "10"))
+	if err != nil || digits < 6 || digits > 10 {
+		return Config{}, fmt.Errorf("config: OTP_DIGITS must be a number between 6 and 10")
+	}
+
+	algorithm, err := parseAlgorithm(getEnv("OTP_ALGORITHM", "SHA512"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		RedisAddress:  getEnv("REDIS_ADDRESS", "localhost:6379"),
+		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+		SessionTTL:    sessionTTL,
+		OTPPeriod:     uint(period),
+		OTPDigits:     otp.Digits(digits),
+		OTPAlgorithm:  algorithm,
+		SharedSecret:  getEnv("OTP_SHARED_SECRET", "KIMURA"),
+		Username:      getEnv("OTP_EXPECTED_USERNAME", "kaede"),
+		Password:      getEnv("OTP_EXPECTED_PASSWORD", "kaede"),
+	}, nil
+}
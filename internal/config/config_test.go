@@ -0,0 +1,113 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/stretchr/testify/assert"
+)
+
+// All of the environment variables read by the configuration.
+var keys = []string{
+	"REDIS_ADDRESS",
+	"REDIS_PASSWORD",
+	"SESSION_TTL",
+	"OTP_PERIOD",
+	"OTP_DIGITS",
+	"OTP_ALGORITHM",
+	"OTP_SHARED_SECRET",
+	"OTP_EXPECTED_USERNAME",
+	"OTP_EXPECTED_PASSWORD",
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("test_load_defaults", func(t *testing.T) {
+		for _, key := range keys {
+			t.Setenv(key, "")
+		}
+
+		cfg, err := Load()
+
+		assert.Nil(t, err)
+		assert.Equal(t, Config{
+			RedisAddress:  "localhost:6379",
+			RedisPassword: "",
+			SessionTTL:    15 * time.Minute,
+			OTPPeriod:     30,
+			OTPDigits:     10,
+			OTPAlgorithm:  otp.AlgorithmSHA512,
+			SharedSecret:  "KIMURA",
+			Username:      "kaede",
+			Password:      "kaede",
+		}, cfg)
+	})
+
+	t.Run("test_load_from_env", func(t *testing.T) {
+		t.Setenv("REDIS_ADDRESS", "redis:6380")
+		t.Setenv("REDIS_PASSWORD", "secret")
+		t.Setenv("SESSION_TTL", "1h")
+		t.Setenv("OTP_PERIOD", "60")
+		t.Setenv("OTP_DIGITS", "6")
+		t.Setenv("OTP_ALGORITHM", "sha1")
+		t.Setenv("OTP_SHARED_SECRET", "KAEDE")
+		t.Setenv("OTP_EXPECTED_USERNAME", "kimura")
+		t.Setenv("OTP_EXPECTED_PASSWORD", "kaori")
+
+		cfg, err := Load()
+
+		assert.Nil(t, err)
+		assert.Equal(t, Config{
+			RedisAddress:  "redis:6380",
+			RedisPassword: "secret",
+			SessionTTL:    time.Hour,
+			OTPPeriod:     60,
+			OTPDigits:     otp.DigitsSix,
+			OTPAlgorithm:  otp.AlgorithmSHA1,
+			SharedSecret:  "KAEDE",
+			Username:      "kimura",
+			Password:      "kaori",
+		}, cfg)
+	})
+
+	failureTests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{
+			name:  "test_invalid_session_ttl",
+			key:   "SESSION_TTL",
+			value: "fifteen",
+		},
+		{
+			name:  "test_negative_session_ttl",
+			key:   "SESSION_TTL",
+			value: "-15m",
+		},
+		{
+			name:  "test_invalid_period",
+			key:   "OTP_PERIOD",
+			value: "0",
+		},
+		{
+			name:  "test_invalid_digits",
+			key:   "OTP_DIGITS",
+			value: "12",
+		},
+		{
+			name:  "test_invalid_algorithm",
+			key:   "OTP_ALGORITHM",
+			value: "MD5",
+		},
+	}
+
+	for _, tt := range failureTests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+
+			_, err := Load()
+			assert.NotNil(t, err)
+		})
+	}
+}
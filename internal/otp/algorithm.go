@@ -0,0 +1,247 @@
+package otp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Algorithm represents a hash algorithm for the OTP. Unlike a hasher function, it is comparable and serializable.
+// The zero value is SHA1, which is the default algorithm of the RFC.
+type Algorithm int
+
+// All of the supported algorithms.
+const (
+	SHA1 Algorithm = iota
+	SHA256
+	SHA512
+)
+
+// UnknownAlgorithm is reported for hashers that are none of the supported algorithms, such as a custom 'Hasher'.
+// It is never valid to generate or to serialize an OTP with.
+const UnknownAlgorithm Algorithm = -1
+
+// Hasher returns the hasher of the algorithm, or nil if the algorithm is unknown.
+func (a Algorithm) Hasher() func() hash.Hash {
+	switch a {
+	case SHA1:
+		return sha1.New
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+// String returns the name of the algorithm, such as 'SHA1'.
+func (a Algorithm) String() string {
+	switch a {
+	case SHA1:
+		return "SHA1"
+	case SHA256:
+		return "SHA256"
+	case SHA512:
+		return "SHA512"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
+}
+
+// ParseAlgorithm is used to parse the name of an algorithm ('sha1', 'sha256', or 'sha512'), case-insensitive.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "sha1":
+		return SHA1, nil
+	case "sha256":
+		return SHA256, nil
+	case "sha512":
+		return SHA512, nil
+	default:
+		return 0, fmt.Errorf("unknown algorithm %q", name)
+	}
+}
+
+// MarshalText is used to serialize the algorithm as its name, so it is readable in JSON.
+func (a Algorithm) MarshalText() ([]byte, error) {
+	if a.Hasher() == nil {
+		return nil, fmt.Errorf("unknown algorithm %d", int(a))
+	}
+
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText is used to deserialize the algorithm from its name.
+func (a *Algorithm) UnmarshalText(text []byte) error {
+	algorithm, err := ParseAlgorithm(string(text))
+	if err != nil {
+		return err
+	}
+
+	*a = algorithm
+	return nil
+}
+
+// This function is an utility function to resolve the hasher to be used. An explicit hasher takes precedence over the algorithm.
+func resolveHasher(hasher func() hash.Hash, algorithm Algorithm) (func() hash.Hash, error) {
+	if hasher != nil {
+		return hasher, nil
+	}
+
+	if resolved := algorithm.Hasher(); resolved != nil {
+		return resolved, nil
+	}
+
+	return nil, fmt.Errorf("unknown algorithm %d", int(algorithm))
+}
+
+// This function is an utility function to find the algorithm to be serialized, as hashers cannot be serialized.
+func serializableAlgorithm(hasher func() hash.Hash, algorithm Algorithm) (Algorithm, error) {
+	if hasher == nil {
+		return algorithm, nil
+	}
+
+	name, err := AlgorithmName(hasher)
+	if err != nil {
+		return 0, err
+	}
+
+	return ParseAlgorithm(name)
+}
+
+// Serializable form of the OTP configurations. Timestamp is excluded, as it changes on every call, and so is the
+// replay guard, as it is state instead of configuration.
+type totpConfigJSON struct {
+	Secret         string        `json:"secret"`
+	Period         int64         `json:"period"`
+	T0             int64         `json:"t0,omitempty"`
+	Digits         int           `json:"digits"`
+	Algorithm      Algorithm     `json:"algorithm"`
+	TimestampUnit  TimestampUnit `json:"timestampUnit,omitempty"`
+	MinSecretBytes int           `json:"minSecretBytes,omitempty"`
+	FixedOffset    *int          `json:"fixedOffset,omitempty"`
+	Window         int64         `json:"window,omitempty"`
+	WindowBefore   int64         `json:"windowBefore,omitempty"`
+	WindowAfter    int64         `json:"windowAfter,omitempty"`
+	PreviousSecret string        `json:"previousSecret,omitempty"`
+	Algorithms     []Algorithm   `json:"algorithms,omitempty"`
+	Periods        []int64       `json:"periods,omitempty"`
+	MaxSkewSeconds int64         `json:"maxSkewSeconds,omitempty"`
+}
+
+// MarshalJSON is used to serialize the configuration, with the hasher stored as the name of its algorithm.
+func (c TOTPConfig) MarshalJSON() ([]byte, error) {
+	algorithm, err := serializableAlgorithm(c.Hasher, c.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(totpConfigJSON{
+		Secret:         c.Secret,
+		Period:         c.Period,
+		T0:             c.T0,
+		Digits:         c.Digits,
+		Algorithm:      algorithm,
+		TimestampUnit:  c.TimestampUnit,
+		MinSecretBytes: c.MinSecretBytes,
+		FixedOffset:    c.FixedOffset,
+	})
+}
+
+// UnmarshalJSON is used to deserialize the configuration. The hasher is reconstructed through 'Algorithm'.
+func (c *TOTPConfig) UnmarshalJSON(data []byte) error {
+	var raw totpConfigJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*c = TOTPConfig{
+		Secret:         raw.Secret,
+		Period:         raw.Period,
+		T0:             raw.T0,
+		Digits:         raw.Digits,
+		Algorithm:      raw.Algorithm,
+		TimestampUnit:  raw.TimestampUnit,
+		MinSecretBytes: raw.MinSecretBytes,
+		FixedOffset:    raw.FixedOffset,
+	}
+	return nil
+}
+
+// MarshalJSON is used to serialize the configuration, with the hashers stored as the names of their algorithms.
+func (c TOTPValidateConfig) MarshalJSON() ([]byte, error) {
+	algorithm, err := serializableAlgorithm(c.Hasher, c.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var algorithms []Algorithm
+	for _, hasher := range c.Hashers {
+		hasherAlgorithm, err := serializableAlgorithm(hasher, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		algorithms = append(algorithms, hasherAlgorithm)
+	}
+
+	return json.Marshal(totpConfigJSON{
+		Secret:         c.Secret,
+		Period:         c.Period,
+		T0:             c.T0,
+		Digits:         c.Digits,
+		Algorithm:      algorithm,
+		TimestampUnit:  c.TimestampUnit,
+		MinSecretBytes: c.MinSecretBytes,
+		FixedOffset:    c.FixedOffset,
+		Window:         c.Window,
+		WindowBefore:   c.WindowBefore,
+		WindowAfter:    c.WindowAfter,
+		PreviousSecret: c.PreviousSecret,
+		Algorithms:     algorithms,
+		Periods:        c.Periods,
+		MaxSkewSeconds: c.MaxSkewSeconds,
+	})
+}
+
+// UnmarshalJSON is used to deserialize the configuration. The hashers are reconstructed through their algorithms.
+func (c *TOTPValidateConfig) UnmarshalJSON(data []byte) error {
+	var raw totpConfigJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var hashers []func() hash.Hash
+	for _, algorithm := range raw.Algorithms {
+		hasher, err := resolveHasher(nil, algorithm)
+		if err != nil {
+			return err
+		}
+
+		hashers = append(hashers, hasher)
+	}
+
+	*c = TOTPValidateConfig{
+		Secret:         raw.Secret,
+		Period:         raw.Period,
+		T0:             raw.T0,
+		Digits:         raw.Digits,
+		Algorithm:      raw.Algorithm,
+		TimestampUnit:  raw.TimestampUnit,
+		MinSecretBytes: raw.MinSecretBytes,
+		FixedOffset:    raw.FixedOffset,
+		Window:         raw.Window,
+		WindowBefore:   raw.WindowBefore,
+		WindowAfter:    raw.WindowAfter,
+		PreviousSecret: raw.PreviousSecret,
+		Hashers:        hashers,
+		Periods:        raw.Periods,
+		MaxSkewSeconds: raw.MaxSkewSeconds,
+	}
+	return nil
+}
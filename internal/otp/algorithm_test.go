@@ -0,0 +1,294 @@
+package otp
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/json"
+	"hash"
+	"reflect"
+	"testing"
+)
+
+func TestAlgorithm(t *testing.T) {
+	// Test vectors are taken from the RFC 6238, Appendix B.
+	tests := []struct {
+		name           string
+		algorithm      Algorithm
+		secret         string
+		expectedName   string
+		expectedOutput string
+	}{
+		{
+			name:           "test_sha1",
+			algorithm:      SHA1,
+			secret:         toBase32("12345678901234567890"),
+			expectedName:   "SHA1",
+			expectedOutput: "94287082",
+		},
+		{
+			name:           "test_sha256",
+			algorithm:      SHA256,
+			secret:         toBase32("12345678901234567890123456789012"),
+			expectedName:   "SHA256",
+			expectedOutput: "46119246",
+		},
+		{
+			name:           "test_sha512",
+			algorithm:      SHA512,
+			secret:         toBase32("1234567890123456789012345678901234567890123456789012345678901234"),
+			expectedName:   "SHA512",
+			expectedOutput: "90693936",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otp, err := Generate(TOTPConfig{
+				Secret:    tt.secret,
+				Period:    30,
+				Timestamp: 59,
+				Digits:    8,
+				Algorithm: tt.algorithm,
+			})
+			if err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			if otp != tt.expectedOutput {
+				t.Errorf("OTP and the expected output are not the same! Got: %v, expected: %v!", otp, tt.expectedOutput)
+			}
+
+			if tt.algorithm.String() != tt.expectedName {
+				t.Errorf("Name of the algorithm is not the same! Got: %v, expected: %v!", tt.algorithm.String(), tt.expectedName)
+			}
+		})
+
+		t.Run(tt.name+"_json_round_trip", func(t *testing.T) {
+			input := struct {
+				Algorithm Algorithm `json:"algorithm"`
+			}{Algorithm: tt.algorithm}
+			output := input
+			output.Algorithm = UnknownAlgorithm
+
+			out, err := json.Marshal(input)
+			if err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			if string(out) != `{"algorithm":"`+tt.expectedName+`"}` {
+				t.Errorf("JSON of the algorithm is not the same! Got: %v!", string(out))
+			}
+
+			if err := json.Unmarshal(out, &output); err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			if output.Algorithm != tt.algorithm {
+				t.Errorf("Algorithm is not the same after round-tripping! Got: %v, expected: %v!", output.Algorithm, tt.algorithm)
+			}
+		})
+	}
+
+	t.Run("test_hasher_takes_precedence", func(t *testing.T) {
+		config := TOTPConfig{
+			Secret:    toBase32("12345678901234567890"),
+			Period:    30,
+			Timestamp: 59,
+			Digits:    8,
+			Hasher:    sha1.New,
+			Algorithm: SHA512,
+		}
+
+		otp, err := Generate(config)
+		if err != nil || otp != "94287082" {
+			t.Errorf("Hasher should take precedence over the algorithm! Got: %v, %v!", otp, err)
+		}
+	})
+
+	t.Run("test_unknown_algorithm", func(t *testing.T) {
+		otp, err := Generate(TOTPConfig{Secret: toBase32("12345678901234567890"), Period: 30, Timestamp: 59, Digits: 8, Algorithm: Algorithm(10)})
+		if err == nil {
+			t.Errorf("Test-cases should return error(s)! Got: %v!", otp)
+		}
+
+		if err := json.Unmarshal([]byte(`"MD5"`), new(Algorithm)); err == nil {
+			t.Error("Unmarshaling an unknown algorithm should return error(s)!")
+		}
+	})
+
+	t.Run("test_unknown_algorithm_constant", func(t *testing.T) {
+		if UnknownAlgorithm.Hasher() != nil {
+			t.Error("Unknown algorithm should not have a hasher!")
+		}
+
+		if _, err := json.Marshal(UnknownAlgorithm); err == nil {
+			t.Error("Marshaling the unknown algorithm should return error(s)!")
+		}
+	})
+}
+
+func TestConfigJSON(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+
+	t.Run("test_totp_config_round_trip", func(t *testing.T) {
+		config := TOTPConfig{
+			Secret:    sharedSecret,
+			Period:    30,
+			Timestamp: 1629794237,
+			Digits:    10,
+			Hasher:    sha512.New,
+		}
+
+		out, err := json.Marshal(config)
+		if err != nil {
+			t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+		}
+
+		expectedJSON := `{"secret":"` + sharedSecret + `","period":30,"digits":10,"algorithm":"SHA512"}`
+		if string(out) != expectedJSON {
+			t.Errorf("JSON of the configuration is not the same! Got: %v, expected: %v!", string(out), expectedJSON)
+		}
+
+		var reloaded TOTPConfig
+		if err := json.Unmarshal(out, &reloaded); err != nil {
+			t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+		}
+		reloaded.Timestamp = config.Timestamp
+
+		original, _ := Generate(config)
+		res, err := Generate(reloaded)
+		if err != nil || res != original || res != "2091961511" {
+			t.Errorf("Reloaded configuration should generate the identical token! Got: %v, expected: %v!", res, original)
+		}
+	})
+
+	t.Run("test_totp_validate_config_round_trip", func(t *testing.T) {
+		config := TOTPValidateConfig{
+			Secret:    sharedSecret,
+			Period:    30,
+			Digits:    10,
+			Algorithm: SHA512,
+			Window:    1,
+		}
+
+		out, err := json.Marshal(config)
+		if err != nil {
+			t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+		}
+
+		var reloaded TOTPValidateConfig
+		if err := json.Unmarshal(out, &reloaded); err != nil {
+			t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+		}
+		reloaded.Timestamp = 1629795965
+
+		valid, err := Verify("2053730166", reloaded)
+		if err != nil || !valid || reloaded.Window != 1 {
+			t.Errorf("Reloaded configuration should verify the token! Got: %v, %v!", valid, err)
+		}
+	})
+
+	t.Run("test_every_field_round_trip", func(t *testing.T) {
+		// Fields which are not serialized, or are compared by their algorithms instead.
+		excluded := map[string]bool{"Timestamp": true, "Hasher": true, "Hashers": true, "ReplayGuard": true}
+		fixedOffset := 2
+
+		configs := []interface{}{
+			&TOTPConfig{
+				Secret:         sharedSecret,
+				Period:         60,
+				Timestamp:      1629794237000,
+				T0:             30,
+				Digits:         8,
+				Algorithm:      SHA256,
+				TimestampUnit:  Milliseconds,
+				MinSecretBytes: 16,
+				FixedOffset:    &fixedOffset,
+			},
+			&TOTPValidateConfig{
+				Secret:         sharedSecret,
+				Period:         60,
+				Timestamp:      1629794237000,
+				T0:             30,
+				Digits:         8,
+				Algorithm:      SHA256,
+				Window:         2,
+				WindowBefore:   1,
+				WindowAfter:    3,
+				TimestampUnit:  Milliseconds,
+				PreviousSecret: toBase32("The lazy dog jumps over the quick brown fox."),
+				Hashers:        []func() hash.Hash{sha1.New, sha512.New},
+				Periods:        []int64{30, 60},
+				MinSecretBytes: 16,
+				FixedOffset:    &fixedOffset,
+				MaxSkewSeconds: 45,
+				ReplayGuard:    NewMemoryReplayGuard(),
+			},
+		}
+
+		for _, config := range configs {
+			original := reflect.ValueOf(config).Elem()
+			for i := 0; i < original.NumField(); i++ {
+				name := original.Type().Field(i).Name
+				if !excluded[name] && original.Field(i).IsZero() {
+					t.Errorf("Field %v of %v should be populated, so that it is checked by this test!", name, original.Type())
+				}
+			}
+
+			out, err := json.Marshal(config)
+			if err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			reloaded := reflect.New(original.Type())
+			if err := json.Unmarshal(out, reloaded.Interface()); err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			for i := 0; i < original.NumField(); i++ {
+				name := original.Type().Field(i).Name
+				if excluded[name] {
+					continue
+				}
+
+				if got, expected := reloaded.Elem().Field(i).Interface(), original.Field(i).Interface(); !reflect.DeepEqual(got, expected) {
+					t.Errorf("Field %v of %v is not the same! Got: %v, expected: %v!", name, original.Type(), got, expected)
+				}
+			}
+		}
+
+		validateConfig := configs[1].(*TOTPValidateConfig)
+		out, _ := json.Marshal(validateConfig)
+
+		var reloaded TOTPValidateConfig
+		if err := json.Unmarshal(out, &reloaded); err != nil {
+			t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+		}
+
+		if len(reloaded.Hashers) != len(validateConfig.Hashers) {
+			t.Fatalf("Hashers are not the same! Got: %v, expected: %v!", len(reloaded.Hashers), len(validateConfig.Hashers))
+		}
+
+		for i, hasher := range reloaded.Hashers {
+			got, _ := AlgorithmName(hasher)
+			expected, _ := AlgorithmName(validateConfig.Hashers[i])
+			if got != expected {
+				t.Errorf("Hasher is not the same! Got: %v, expected: %v!", got, expected)
+			}
+		}
+	})
+
+	t.Run("test_unknown_algorithms", func(t *testing.T) {
+		var reloaded TOTPValidateConfig
+		if err := json.Unmarshal([]byte(`{"secret":"`+sharedSecret+`","period":30,"digits":10,"algorithm":"SHA1","algorithms":["SHA1","MD5"]}`), &reloaded); err == nil {
+			t.Error("Unmarshaling an unknown algorithm should return error(s)!")
+		}
+	})
+
+	t.Run("test_unknown_hasher", func(t *testing.T) {
+		_, err := json.Marshal(TOTPConfig{Secret: sharedSecret, Hasher: sha512.New384})
+		if err == nil {
+			t.Error("Marshaling an unknown hasher should return error(s)!")
+		}
+	})
+}
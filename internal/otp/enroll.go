@@ -0,0 +1,95 @@
+package otp
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"io"
+)
+
+// Number of bytes of a newly created secret.
+const secretBytes = RecommendedSecretBytes
+
+// Default number of backup codes created during enrollment.
+const BackupCodesCount = 10
+
+// Used to generate the secrets and the backup codes, overridable in tests.
+var randReader io.Reader = rand.Reader
+
+// Encoding of the backup codes. Lowercase without padding, so they are easy to type.
+var backupCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// This function will create a new random secret (base32 encoded), generated with a secure random number generator.
+func NewSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := io.ReadFull(randReader, b); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// This function will create distinct, random, single-use backup codes, to be used when the authenticator is lost.
+// Every code consists of 8 characters (40 bits).
+func GenerateBackupCodes(count int) ([]string, error) {
+	if count < 0 {
+		return nil, errors.New("count of the backup codes must not be negative")
+	}
+
+	codes := make([]string, 0, count)
+	seen := make(map[string]bool, count)
+	for len(codes) < count {
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(randReader, b); err != nil {
+			return nil, err
+		}
+
+		code := backupCodeEncoding.EncodeToString(b)
+		if seen[code] {
+			continue
+		}
+
+		seen[code] = true
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// This function will enroll a new account by creating a secret, its provisioning URI, and a set of backup codes.
+// Only the period, the digits, and the algorithm of the options are used. The backup codes should be stored by the caller.
+func Enroll(issuer, account string, opts TOTPConfig) (secret, uri string, backupCodes []string, err error) {
+	return EnrollWithBackupCodes(issuer, account, opts, BackupCodesCount)
+}
+
+// This function will enroll a new account like 'Enroll', but with the given number of backup codes instead of the default.
+func EnrollWithBackupCodes(issuer, account string, opts TOTPConfig, backupCodesCount int) (secret, uri string, backupCodes []string, err error) {
+	algorithm, err := serializableAlgorithm(opts.Hasher, opts.Algorithm)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	secret, err = NewSecret()
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	uri, err = GenerateProvisioningURI(ProvisioningConfig{
+		Issuer:      issuer,
+		AccountName: account,
+		Secret:      secret,
+		Period:      opts.Period,
+		Digits:      opts.Digits,
+		Algorithm:   algorithm,
+	})
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	backupCodes, err = GenerateBackupCodes(backupCodesCount)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return secret, uri, backupCodes, nil
+}
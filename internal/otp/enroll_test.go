@@ -0,0 +1,158 @@
+package otp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"io"
+	"net/url"
+	"testing"
+)
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Errorf("Creating a secret should not return error(s)! Got: %v!", err)
+	}
+
+	decoded, err := base32.StdEncoding.DecodeString(secret)
+	if err != nil {
+		t.Errorf("Secret should be a valid base32 string! Got: %v!", err)
+	}
+
+	if len(decoded) != 20 {
+		t.Errorf("Secret should be 20 bytes long! Got: %v!", len(decoded))
+	}
+
+	another, _ := NewSecret()
+	if secret == another {
+		t.Errorf("Secrets should be random! Got: %v twice!", secret)
+	}
+}
+
+// A reader that always fails, to simulate a broken random number generator.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("random number generator is broken")
+}
+
+// Mocks the random source of the secrets and the backup codes.
+func mockRandReader(t *testing.T, reader io.Reader) {
+	randReader = reader
+	t.Cleanup(func() { randReader = rand.Reader })
+}
+
+func TestNewSecretRandReader(t *testing.T) {
+	t.Run("test_new_secret_fixed", func(t *testing.T) {
+		mockRandReader(t, bytes.NewReader([]byte("12345678901234567890")))
+
+		secret, err := NewSecret()
+		if err != nil || secret != toBase32("12345678901234567890") {
+			t.Errorf("Secret should be created from the random source! Got: %v, %v!", secret, err)
+		}
+	})
+
+	t.Run("test_new_secret_fail", func(t *testing.T) {
+		mockRandReader(t, failingReader{})
+
+		if _, err := NewSecret(); err == nil || err.Error() != "random number generator is broken" {
+			t.Errorf("Error of the random source should be returned! Got: %v!", err)
+		}
+	})
+
+	t.Run("test_backup_codes_fail", func(t *testing.T) {
+		mockRandReader(t, failingReader{})
+
+		if _, err := GenerateBackupCodes(1); err == nil {
+			t.Error("Error of the random source should be returned!")
+		}
+	})
+}
+
+func TestGenerateBackupCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+	}{
+		{name: "test_no_backup_codes", count: 0},
+		{name: "test_single_backup_code", count: 1},
+		{name: "test_many_backup_codes", count: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes, err := GenerateBackupCodes(tt.count)
+			if err != nil {
+				t.Errorf("Generating backup codes should not return error(s)! Got: %v!", err)
+			}
+
+			if len(codes) != tt.count {
+				t.Errorf("Number of the backup codes is incorrect. Got: %v, expected: %v!", len(codes), tt.count)
+			}
+
+			seen := map[string]bool{}
+			for _, code := range codes {
+				if len(code) != 8 || seen[code] {
+					t.Errorf("Backup codes should be distinct and 8 characters long! Got: %v!", code)
+				}
+				seen[code] = true
+			}
+		})
+	}
+
+	t.Run("test_negative_backup_codes", func(t *testing.T) {
+		if _, err := GenerateBackupCodes(-1); err == nil {
+			t.Errorf("Generating a negative number of backup codes should return an error!")
+		}
+	})
+}
+
+func TestEnroll(t *testing.T) {
+	secret, uri, backupCodes, err := Enroll("Fullstack OTP", "kaede", TOTPConfig{Period: 30, Digits: 6, Hasher: sha256.New})
+	if err != nil {
+		t.Errorf("Enrollment should not return error(s)! Got: %v!", err)
+	}
+
+	if _, err := base32.StdEncoding.DecodeString(secret); err != nil {
+		t.Errorf("Secret should be a valid base32 string! Got: %v!", err)
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Errorf("Provisioning URI should be parseable! Got: %v!", err)
+	}
+
+	query := parsed.Query()
+	if parsed.Scheme != "otpauth" || query.Get("secret") != secret || query.Get("algorithm") != "SHA256" || query.Get("digits") != "6" {
+		t.Errorf("Provisioning URI is incorrect. Got: %v!", uri)
+	}
+
+	if len(backupCodes) != BackupCodesCount {
+		t.Errorf("Number of the backup codes is incorrect. Got: %v, expected: %v!", len(backupCodes), BackupCodesCount)
+	}
+
+	t.Run("test_enroll_with_backup_codes", func(t *testing.T) {
+		for _, count := range []int{0, 3, 20} {
+			_, _, backupCodes, err := EnrollWithBackupCodes("Fullstack OTP", "kaede", TOTPConfig{Period: 30, Digits: 6}, count)
+			if err != nil {
+				t.Errorf("Enrollment should not return error(s)! Got: %v!", err)
+			}
+			if len(backupCodes) != count {
+				t.Errorf("Number of the backup codes is incorrect. Got: %v, expected: %v!", len(backupCodes), count)
+			}
+		}
+
+		if _, _, _, err := EnrollWithBackupCodes("Fullstack OTP", "kaede", TOTPConfig{Period: 30, Digits: 6}, -1); err == nil {
+			t.Errorf("Enrollment with a negative number of backup codes should return an error!")
+		}
+	})
+
+	t.Run("test_enroll_unknown_algorithm", func(t *testing.T) {
+		if _, _, _, err := Enroll("Fullstack OTP", "kaede", TOTPConfig{Period: 30, Digits: 6, Algorithm: Algorithm(42)}); err == nil {
+			t.Errorf("Enrollment with an unknown algorithm should return an error!")
+		}
+	})
+}
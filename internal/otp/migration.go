@@ -0,0 +1,283 @@
+package otp
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Account represents a TOTP account, as stored in an authenticator app.
+// Google Authenticator only supports a period of 30 seconds, so the period is not a part of the account.
+type Account struct {
+	Secret    string    // OTP shared secret (base32 encoded).
+	Name      string    // Name of the account, usually the username or the email.
+	Issuer    string    // Name of the provider or the service.
+	Algorithm Algorithm // Hash algorithm for the OTP.
+	Digits    int       // Digits of the OTP, either 6 or 8.
+}
+
+// Scheme and host of the migration URIs of Google Authenticator.
+const (
+	migrationScheme = "otpauth-migration"
+	migrationHost   = "offline"
+)
+
+// Field numbers of the 'MigrationPayload' and the 'OtpParameters' protobuf messages of Google Authenticator.
+const (
+	payloadFieldOTPParameters = 1
+	payloadFieldVersion       = 2
+	payloadFieldBatchSize     = 3
+	payloadFieldBatchIndex    = 4
+
+	parametersFieldSecret    = 1
+	parametersFieldName      = 2
+	parametersFieldIssuer    = 3
+	parametersFieldAlgorithm = 4
+	parametersFieldDigits    = 5
+	parametersFieldType      = 6
+)
+
+// Values of the enums of the 'OtpParameters' protobuf message. Unspecified values are zero.
+const (
+	migrationAlgorithmSHA1   = 1
+	migrationAlgorithmSHA256 = 2
+	migrationAlgorithmSHA512 = 3
+	migrationDigitsSix       = 1
+	migrationDigitsEight     = 2
+	migrationTypeTOTP        = 2
+)
+
+// Wire types of the protobuf encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// ExportGoogleAuth is used to export TOTP accounts into a migration URI of Google Authenticator, which is
+// 'otpauth-migration://offline?data=' followed by a base64 encoded protobuf payload. Render it as a QR code to import
+// the accounts into the app.
+func ExportGoogleAuth(accounts []Account) (string, error) {
+	var payload []byte
+	for _, account := range accounts {
+		parameters, err := encodeAccount(account)
+		if err != nil {
+			return "", err
+		}
+
+		payload = appendBytesField(payload, payloadFieldOTPParameters, parameters)
+	}
+	payload = appendVarintField(payload, payloadFieldVersion, 1)
+	payload = appendVarintField(payload, payloadFieldBatchSize, 1)
+	payload = appendVarintField(payload, payloadFieldBatchIndex, 0)
+
+	query := url.Values{}
+	query.Set("data", base64.StdEncoding.EncodeToString(payload))
+	uri := url.URL{Scheme: migrationScheme, Host: migrationHost, RawQuery: query.Encode()}
+
+	return uri.String(), nil
+}
+
+// ImportGoogleAuth is used to import the TOTP accounts from a migration URI of Google Authenticator.
+// HOTP accounts are not supported, and are rejected with an error.
+func ImportGoogleAuth(uri string) ([]Account, error) {
+	parsedURI, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if parsedURI.Scheme != migrationScheme || parsedURI.Host != migrationHost {
+		return nil, fmt.Errorf("uri must start with '%s://%s'", migrationScheme, migrationHost)
+	}
+
+	// Unescaped '+' characters of the base64 data are turned into spaces when the query is parsed.
+	data := strings.ReplaceAll(parsedURI.Query().Get("data"), " ", "+")
+	if data == "" {
+		return nil, errors.New("uri does not contain any data")
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	err = parseFields(payload, func(field int, wireType int, varint uint64, bytes []byte) error {
+		if field != payloadFieldOTPParameters || wireType != wireBytes {
+			return nil
+		}
+
+		account, err := decodeAccount(bytes)
+		if err != nil {
+			return err
+		}
+
+		accounts = append(accounts, account)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// This function will encode an account into the 'OtpParameters' protobuf message.
+func encodeAccount(account Account) ([]byte, error) {
+	secret, err := transformSecret(strings.ToUpper(strings.TrimSpace(account.Secret)))
+	if err != nil {
+		return nil, err
+	}
+
+	var algorithm uint64
+	switch account.Algorithm {
+	case SHA1:
+		algorithm = migrationAlgorithmSHA1
+	case SHA256:
+		algorithm = migrationAlgorithmSHA256
+	case SHA512:
+		algorithm = migrationAlgorithmSHA512
+	default:
+		return nil, fmt.Errorf("unknown algorithm %d", int(account.Algorithm))
+	}
+
+	var digits uint64
+	switch account.Digits {
+	case 6:
+		digits = migrationDigitsSix
+	case 8:
+		digits = migrationDigitsEight
+	default:
+		return nil, fmt.Errorf("google authenticator only supports 6 or 8 digits, got %d", account.Digits)
+	}
+
+	var parameters []byte
+	parameters = appendBytesField(parameters, parametersFieldSecret, secret)
+	parameters = appendBytesField(parameters, parametersFieldName, []byte(account.Name))
+	parameters = appendBytesField(parameters, parametersFieldIssuer, []byte(account.Issuer))
+	parameters = appendVarintField(parameters, parametersFieldAlgorithm, algorithm)
+	parameters = appendVarintField(parameters, parametersFieldDigits, digits)
+	parameters = appendVarintField(parameters, parametersFieldType, migrationTypeTOTP)
+
+	return parameters, nil
+}
+
+// This function will decode an account from the 'OtpParameters' protobuf message.
+// Unspecified algorithms and digits default to SHA1 and 6 digits, just like the app does.
+func decodeAccount(parameters []byte) (Account, error) {
+	account := Account{Algorithm: SHA1, Digits: 6}
+	otpType := uint64(0)
+
+	err := parseFields(parameters, func(field int, wireType int, varint uint64, bytes []byte) error {
+		switch {
+		case field == parametersFieldSecret && wireType == wireBytes:
+			account.Secret = base32.StdEncoding.EncodeToString(bytes)
+		case field == parametersFieldName && wireType == wireBytes:
+			account.Name = string(bytes)
+		case field == parametersFieldIssuer && wireType == wireBytes:
+			account.Issuer = string(bytes)
+		case field == parametersFieldAlgorithm && wireType == wireVarint:
+			switch varint {
+			case 0, migrationAlgorithmSHA1:
+				account.Algorithm = SHA1
+			case migrationAlgorithmSHA256:
+				account.Algorithm = SHA256
+			case migrationAlgorithmSHA512:
+				account.Algorithm = SHA512
+			default:
+				return fmt.Errorf("unsupported algorithm %d", varint)
+			}
+		case field == parametersFieldDigits && wireType == wireVarint:
+			switch varint {
+			case 0, migrationDigitsSix:
+				account.Digits = 6
+			case migrationDigitsEight:
+				account.Digits = 8
+			default:
+				return fmt.Errorf("unsupported digits %d", varint)
+			}
+		case field == parametersFieldType && wireType == wireVarint:
+			otpType = varint
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Account{}, err
+	}
+
+	if otpType != migrationTypeTOTP {
+		return Account{}, fmt.Errorf("account %q is not a TOTP account", account.Name)
+	}
+
+	return account, nil
+}
+
+// This function will append a varint field to a protobuf message.
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(b, value)
+}
+
+// This function will append a length-delimited field to a protobuf message.
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// This function will call 'fn' with every field of a protobuf message, in order. Varint fields are passed as 'varint',
+// and length-delimited fields as 'bytes'. Fixed-size fields are skipped, as the migration payload does not use them.
+func parseFields(message []byte, fn func(field int, wireType int, varint uint64, bytes []byte) error) error {
+	errMalformed := errors.New("malformed protobuf message")
+
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return errMalformed
+		}
+		message = message[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+
+		switch wireType {
+		case wireVarint:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return errMalformed
+			}
+			message = message[n:]
+
+			if err := fn(field, wireType, value, nil); err != nil {
+				return err
+			}
+		case wireBytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || length > uint64(len(message)-n) {
+				return errMalformed
+			}
+			value := message[n : n+int(length)]
+			message = message[n+int(length):]
+
+			if err := fn(field, wireType, 0, value); err != nil {
+				return err
+			}
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(message) < size {
+				return errMalformed
+			}
+			message = message[size:]
+		default:
+			return errMalformed
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package otp
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestGoogleAuthRoundTrip(t *testing.T) {
+	accounts := []Account{
+		{Secret: toBase32("12345678901234567890"), Name: "kaede@example.com", Issuer: "Fullstack OTP", Algorithm: SHA1, Digits: 6},
+	}
+
+	uri, err := ExportGoogleAuth(accounts)
+	if err != nil {
+		t.Fatalf("Export should not return error(s)! Got: %v!", err)
+	}
+
+	parsedURI, err := url.Parse(uri)
+	if err != nil || parsedURI.Scheme != "otpauth-migration" || parsedURI.Host != "offline" || parsedURI.Query().Get("data") == "" {
+		t.Errorf("Export should return a migration URI! Got: %v!", uri)
+	}
+
+	imported, err := ImportGoogleAuth(uri)
+	if err != nil {
+		t.Fatalf("Import should not return error(s)! Got: %v!", err)
+	}
+
+	if !reflect.DeepEqual(accounts, imported) {
+		t.Errorf("Expected and actual accounts are not the same! Expected: %v, got: %v!", accounts, imported)
+	}
+}
+
+func TestImportGoogleAuth(t *testing.T) {
+	// Example payload with a single TOTP account, with the algorithm and the digits left unspecified.
+	imported, err := ImportGoogleAuth("otpauth-migration://offline?data=CjEKCkhlbGxvId6tvu8SGEV4YW1wbGU6YWxpY2VAZ29vZ2xlLmNvbRoHRXhhbXBsZTAC")
+	if err != nil {
+		t.Fatalf("Import should not return error(s)! Got: %v!", err)
+	}
+
+	expected := []Account{{Secret: "JBSWY3DPEHPK3PXP", Name: "Example:alice@google.com", Issuer: "Example", Algorithm: SHA1, Digits: 6}}
+	if !reflect.DeepEqual(expected, imported) {
+		t.Errorf("Expected and actual accounts are not the same! Expected: %v, got: %v!", expected, imported)
+	}
+}
+
+func TestImportGoogleAuthFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+	}{
+		{name: "test_wrong_scheme", uri: "otpauth://totp/Example?secret=JBSWY3DPEHPK3PXP"},
+		{name: "test_missing_data", uri: "otpauth-migration://offline"},
+		{name: "test_invalid_base64", uri: "otpauth-migration://offline?data=not-base64!"},
+		{name: "test_truncated_payload", uri: "otpauth-migration://offline?data=CjEKCkhlbGxv"},
+		// Same account as the example payload, but as an HOTP account.
+		{name: "test_hotp_account", uri: "otpauth-migration://offline?data=CjEKCkhlbGxvId6tvu8SGEV4YW1wbGU6YWxpY2VAZ29vZ2xlLmNvbRoHRXhhbXBsZTAB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ImportGoogleAuth(tt.uri); err == nil {
+				t.Errorf("Import should return an error! Got: %v!", err)
+			}
+		})
+	}
+}
+
+func TestExportGoogleAuthFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		account Account
+	}{
+		{name: "test_invalid_secret", account: Account{Secret: "not base32!", Digits: 6}},
+		{name: "test_unsupported_digits", account: Account{Secret: "JBSWY3DPEHPK3PXP", Digits: 10}},
+		{name: "test_unknown_algorithm", account: Account{Secret: "JBSWY3DPEHPK3PXP", Algorithm: Algorithm(42), Digits: 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, err := ExportGoogleAuth([]Account{tt.account})
+			if err == nil || uri != "" {
+				t.Errorf("Export should return an error! Got: %v!", err)
+			}
+		})
+	}
+}
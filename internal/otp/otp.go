@@ -2,14 +2,22 @@ package otp
 
 import (
 	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/base32"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
 	"math"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // TOTPConfig in order to act as a baseline of TOTP configurations.
@@ -17,8 +25,40 @@ type TOTPConfig struct {
 	Secret    string           // OTP shared secret.
 	Period    int64            // Period of the token will be validated.
 	Timestamp int64            // Timestamp or current time in UNIX time.
+	T0        int64            // Time to start counting the periods from in UNIX time. Defaults to the Unix epoch.
 	Digits    int              // Digits requested for the OTP.
-	Hasher    func() hash.Hash // Hash algorithm for the OTP.
+	Hasher    func() hash.Hash // Hash algorithm for the OTP. Takes precedence over 'Algorithm' if set.
+	Algorithm Algorithm        // Hash algorithm for the OTP, used if 'Hasher' is not set.
+
+	// Unit of 'Timestamp', either seconds or milliseconds. Defaults to seconds. 'T0' and 'Period' are always in seconds.
+	TimestampUnit TimestampUnit
+
+	// Minimum length of the decoded secret in bytes. Shorter secrets are rejected with 'ErrSecretTooShort'.
+	// Zero disables the check. RFC 4226 requires at least 16 bytes, and recommends 'RecommendedSecretBytes'.
+	MinSecretBytes int
+
+	// Truncation offset used instead of the dynamic offset of RFC 4226, which is the last 4 bits of the digest.
+	// Only set it to interoperate with legacy servers that truncate at a fixed offset. The offset and the three bytes
+	// after it have to be in the digest, so it must be between zero and the digest size minus four.
+	FixedOffset *int
+}
+
+// WithWindow is used to create the validation parameters of these configurations, so every shared field does not
+// have to be copied by hand. Only the window is added, the other validation parameters are left empty.
+func (c TOTPConfig) WithWindow(window int64) TOTPValidateConfig {
+	return TOTPValidateConfig{
+		Secret:         c.Secret,
+		Period:         c.Period,
+		Timestamp:      c.Timestamp,
+		T0:             c.T0,
+		Digits:         c.Digits,
+		Hasher:         c.Hasher,
+		Algorithm:      c.Algorithm,
+		Window:         window,
+		TimestampUnit:  c.TimestampUnit,
+		MinSecretBytes: c.MinSecretBytes,
+		FixedOffset:    c.FixedOffset,
+	}
 }
 
 // TOTPValidateConfig to configure validation parameters.
@@ -26,9 +66,193 @@ type TOTPValidateConfig struct {
 	Secret    string           // OTP shared secret.
 	Period    int64            // Period of the token will be validated.
 	Timestamp int64            // Timestamp or current time in UNIX time.
+	T0        int64            // Time to start counting the periods from in UNIX time. Defaults to the Unix epoch.
 	Digits    int              // Digits requested for the OTP.
-	Hasher    func() hash.Hash // Hash algorithm for the OTP.
-	Window    int64            // How long in a timeframe should an OTP be tolerated.
+	Hasher    func() hash.Hash // Hash algorithm for the OTP. Takes precedence over 'Algorithm' if set.
+	Algorithm Algorithm        // Hash algorithm for the OTP, used if 'Hasher' is not set.
+	Window    int64            // How long in a timeframe should an OTP be tolerated, both before and after.
+
+	// Asymmetric tolerance, in periods. If either is set, both are used instead of 'Window'.
+	WindowBefore int64 // How many periods in the past should an OTP be tolerated.
+	WindowAfter  int64 // How many periods in the future should an OTP be tolerated.
+
+	// Unit of 'Timestamp', just like 'TOTPConfig'. Defaults to seconds.
+	TimestampUnit TimestampUnit
+
+	// Secret before rotation, tried after 'Secret' if set, so in-flight OTPs still validate during a grace period.
+	// Both secrets are decoded before any token is generated, so an invalid previous secret is always an error,
+	// even if the OTP matches the current secret.
+	PreviousSecret string
+
+	// Hash algorithms that are accepted, tried in order. If empty, 'Hasher' or 'Algorithm' is used instead. Nil hashers
+	// are rejected with an error.
+	// Useful to accept OTPs of both the old and the new algorithm while users are being migrated.
+	Hashers []func() hash.Hash
+
+	// Periods that are accepted, in seconds, tried in order. If empty, 'Period' is used instead.
+	// Useful to accept OTPs from authenticator apps with different periods while users are being migrated.
+	Periods []int64
+
+	// Minimum length of the decoded secrets in bytes, just like 'TOTPConfig'. Zero disables the check.
+	MinSecretBytes int
+
+	// Fixed truncation offset, just like 'TOTPConfig'. Checked against the digest size of every hasher.
+	FixedOffset *int
+
+	// Maximum clock skew in seconds, which is the offset of the matching period multiplied by the period. OTPs that
+	// match in the window, but further than this, are rejected. Zero disables the check. Useful to keep the window
+	// for robustness, while accepting less than whole periods.
+	MaxSkewSeconds int64
+
+	// Replay protection. If set, a valid OTP is marked as used, and is rejected afterwards until the window has passed.
+	ReplayGuard ReplayGuard
+}
+
+// TimestampUnit represents the unit of the timestamps of the configurations.
+type TimestampUnit int
+
+const (
+	Seconds      TimestampUnit = iota // Timestamps are in UNIX seconds.
+	Milliseconds                      // Timestamps are in UNIX milliseconds.
+)
+
+// This function will calculate the counter of a timestamp in this unit, with 'T0' and 'period' in seconds.
+// Milliseconds are not divided into seconds first, so the counter is exact at the period boundaries.
+func (u TimestampUnit) counter(timestamp, t0, period int64) int64 {
+	if u == Milliseconds {
+		return (timestamp - t0*1000) / (period * 1000)
+	}
+
+	return (timestamp - t0) / period
+}
+
+// SecretMatch reports which secret of the validation parameters an OTP was generated with.
+type SecretMatch int
+
+const (
+	NoMatch             SecretMatch = iota // OTP does not match any secret.
+	CurrentSecretMatch                     // OTP matches 'Secret'.
+	PreviousSecretMatch                    // OTP matches 'PreviousSecret'.
+)
+
+// RecommendedSecretBytes is the length of a shared secret recommended by RFC 4226, which is 160 bits.
+const RecommendedSecretBytes = 20
+
+// ErrSecretTooShort is returned if a decoded secret is shorter than the configured 'MinSecretBytes'.
+var ErrSecretTooShort = errors.New("secret is shorter than the minimum length")
+
+// This function will reject secrets that are shorter than the minimum length in bytes. A minimum of zero allows everything.
+func checkSecretLength(secret []byte, minSecretBytes int) error {
+	if len(secret) < minSecretBytes {
+		return fmt.Errorf("%w: got %d bytes, expected at least %d bytes", ErrSecretTooShort, len(secret), minSecretBytes)
+	}
+
+	return nil
+}
+
+// This function will check that a period is able to be used to calculate the counter, as it is a divisor.
+func checkPeriod(period int64) error {
+	if period <= 0 {
+		return errors.New("period must be a positive number of seconds")
+	}
+
+	return nil
+}
+
+// This function will check that a fixed truncation offset, if set, leaves four bytes of the digest of the hasher.
+func checkFixedOffset(fixedOffset *int, hasher func() hash.Hash) error {
+	if fixedOffset == nil {
+		return nil
+	}
+
+	if maxOffset := hasher().Size() - 4; *fixedOffset < 0 || *fixedOffset > maxOffset {
+		return fmt.Errorf("fixed offset must be between 0 and %d, got %d", maxOffset, *fixedOffset)
+	}
+
+	return nil
+}
+
+// ErrNonDigitPasscode is returned if a passcode contains anything other than ASCII digits after it is normalized,
+// so malformed passcodes are rejected before any token is generated.
+var ErrNonDigitPasscode = errors.New("passcode must only contain digits")
+
+// ErrNilLookup is returned by 'VerifyFor' if there is no lookup to resolve the validation parameters with.
+var ErrNilLookup = errors.New("lookup must not be nil")
+
+// Maximum window, in periods, in either direction. Every period in the window generates a token for every secret and
+// hasher, so huge windows from untrusted configurations would otherwise make verification hang.
+const MaxWindow = 10
+
+// This function will return the range of the window, as the number of periods before and after the counter.
+func (c TOTPValidateConfig) windowRange() (before, after int64) {
+	if c.WindowBefore != 0 || c.WindowAfter != 0 {
+		return c.WindowBefore, c.WindowAfter
+	}
+
+	return c.Window, c.Window
+}
+
+// This function will return the accepted periods, defaulting to 'Period' if 'Periods' is empty.
+func (c TOTPValidateConfig) periods() []int64 {
+	if len(c.Periods) == 0 {
+		return []int64{c.Period}
+	}
+
+	return c.Periods
+}
+
+// This function will return how long an OTP has to be remembered, as it cannot be valid anymore after the whole window.
+// The longest period is used, as it makes the window the longest.
+func (c TOTPValidateConfig) replayTTL() time.Duration {
+	longest := int64(0)
+	for _, period := range c.periods() {
+		if period > longest {
+			longest = period
+		}
+	}
+
+	before, after := c.windowRange()
+	return time.Duration(longest*(before+after+1)) * time.Second
+}
+
+// ProvisioningConfig to configure the parameters of a provisioning URI.
+type ProvisioningConfig struct {
+	Issuer      string    // Name of the provider or the service.
+	AccountName string    // Name of the account, usually the username or the email.
+	Secret      string    // OTP shared secret (base32 encoded).
+	Period      int64     // Period of the token.
+	Digits      int       // Digits requested for the OTP.
+	Algorithm   Algorithm // Hash algorithm for the OTP.
+}
+
+// This function will map the name of an algorithm ('sha1', 'sha256', or 'sha512') into its hasher.
+func HasherFromName(name string) (func() hash.Hash, error) {
+	algorithm, err := ParseAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return algorithm.Hasher(), nil
+}
+
+// This function will report the name of the algorithm of a hasher ('SHA1', 'SHA256', or 'SHA512').
+// As functions cannot be compared in Go, the hasher is instantiated and identified by its digest and block sizes.
+func AlgorithmName(hasher func() hash.Hash) (string, error) {
+	if hasher == nil {
+		return "", errors.New("hasher must not be nil")
+	}
+
+	h := hasher()
+	switch {
+	case h.Size() == sha1.Size && h.BlockSize() == sha1.BlockSize:
+		return "SHA1", nil
+	case h.Size() == sha256.Size && h.BlockSize() == sha256.BlockSize:
+		return "SHA256", nil
+	case h.Size() == sha512.Size && h.BlockSize() == sha512.BlockSize:
+		return "SHA512", nil
+	default:
+		return "", errors.New("unknown hasher, only SHA1, SHA256, and SHA512 are supported")
+	}
 }
 
 // This function is an utility function to convert a secret (base32 encoded) into byte form.
@@ -44,9 +268,10 @@ func transformSecret(sharedSecret string) ([]byte, error) {
 }
 
 // This function is an utility function to convert an integer into byte form.
+// The bytes have enough spare capacity for the largest digest, so the digest is able to reuse the same buffer.
 func transformCounter(counter int64) []byte {
 	// Transform into bytes.
-	counterInBytes := make([]byte, 8)
+	counterInBytes := make([]byte, 8, 8+sha512.Size)
 	binary.BigEndian.PutUint64(counterInBytes, uint64(counter))
 
 	// Returns our transformed counter.
@@ -54,81 +279,497 @@ func transformCounter(counter int64) []byte {
 }
 
 // This function will pad an OTP with leading zeroes shall the digits are not enough.
+// The string is built by hand instead of with a format string, as it is on the hot path of every verification.
 func pad(otp, digits int) string {
-	return fmt.Sprintf(fmt.Sprintf("%%0%dd", digits), otp)
+	var buffer [20]byte
+	number := strconv.AppendInt(buffer[:0], int64(otp), 10)
+
+	var padded strings.Builder
+	padded.Grow(max(digits, len(number)))
+	for i := len(number); i < digits; i++ {
+		padded.WriteByte('0')
+	}
+	padded.Write(number)
+
+	return padded.String()
+}
+
+// This function will remove every whitespace and hyphen from an OTP, as users often type OTPs in groups of digits.
+// For example, '205 373 0166' and '205-373-0166' are both normalized into '2053730166'. Invisible formatting runes,
+// such as zero-width spaces that come along with copy-pasted OTPs, are removed as well. Unicode whitespaces,
+// such as non-breaking spaces, are already covered by 'unicode.IsSpace'.
+func normalizePasscode(otp string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+
+		return r
+	}, otp)
+}
+
+// Result of a verification, as the secret and the hasher that generated the TOTP.
+type verification struct {
+	secret SecretMatch // Secret that matched, or 'NoMatch'.
+	hasher int         // Position of the hasher that matched in 'Hashers', or -1 if there is no match.
+	offset int64       // Periods between the matching counter and the current counter.
+	period int64       // Period of the matching counter in seconds.
+}
+
+// Result of a verification that does not match.
+var noVerification = verification{secret: NoMatch, hasher: -1}
+
+// This function will group an OTP into chunks of 'groupSize' characters joined by 'sep', so it is easier to read.
+// The last group is shorter if the OTP is not evenly divisible. For example, '2053730166' with groups of 4 and '-'
+// is formatted into '2053-7301-66'. The OTP is returned as it is if 'groupSize' is not positive.
+func FormatForDisplay(token string, groupSize int, sep string) string {
+	runes := []rune(token)
+	if groupSize <= 0 || len(runes) <= groupSize {
+		return token
+	}
+
+	groups := make([]string, 0, (len(runes)+groupSize-1)/groupSize)
+	for start := 0; start < len(runes); start += groupSize {
+		end := start + groupSize
+		if end > len(runes) {
+			end = len(runes)
+		}
+
+		groups = append(groups, string(runes[start:end]))
+	}
+
+	return strings.Join(groups, sep)
 }
 
 // This function will validate a TOTP using constant time compare.
-// Window is used as the interval - the window of counter values to test.
+// Window is used as the interval - the window of counter values to test. Windows larger than 'MaxWindow' are rejected.
 func Verify(otp string, options TOTPValidateConfig) (bool, error) {
-	// Remove whitespaces from the passed OTP and calculate counter.
-	passcode := strings.TrimSpace(otp)
-	counter := options.Timestamp / options.Period
+	result, err := verify(otp, options, false)
+	return result.secret != NoMatch, err
+}
+
+// This function will validate a TOTP of a user like 'Verify', with the validation parameters resolved by 'lookup'.
+// Useful for servers that verify OTPs of many users, as the parameters are only fetched when they are needed.
+// Errors of the lookup, such as an unknown user, are returned as they are. A nil lookup returns 'ErrNilLookup'.
+func VerifyFor(userID, otp string, lookup func(userID string) (TOTPValidateConfig, error)) (bool, error) {
+	if lookup == nil {
+		return false, ErrNilLookup
+	}
+
+	options, err := lookup(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return Verify(otp, options)
+}
+
+// This function will validate a TOTP like 'Verify', and report which secret the TOTP matched.
+// Useful to find out whether users are still using their secret from before the rotation.
+func VerifyMatch(otp string, options TOTPValidateConfig) (SecretMatch, error) {
+	result, err := verify(otp, options, false)
+	return result.secret, err
+}
+
+// This function will validate a TOTP like 'Verify', and report the position of the hasher in 'Hashers' that matched.
+// Useful to find out which users have not migrated to a new algorithm yet. Returns -1 if the TOTP is not valid,
+// and 0 for a valid TOTP if 'Hashers' is empty.
+func VerifyHasher(otp string, options TOTPValidateConfig) (int, error) {
+	result, err := verify(otp, options, false)
+	return result.hasher, err
+}
+
+// This function will validate a TOTP like 'Verify', and report the offset of the matching period from the current one.
+// A positive offset means the TOTP is from the future, so the clock of the client is most likely ahead.
+func VerifyWithOffset(otp string, options TOTPValidateConfig) (bool, int64, error) {
+	result, err := verify(otp, options, false)
+	return result.secret != NoMatch, result.offset, err
+}
+
+// VerifyResult represents the details of a verification, so new details do not need new verification functions.
+type VerifyResult struct {
+	Valid     bool      // Whether the TOTP matches any secret, hasher, and period in the window.
+	Offset    int64     // Periods between the matching counter and the current counter.
+	Algorithm Algorithm // Algorithm of the matching hasher, or 'UnknownAlgorithm' if it is not SHA1, SHA256, nor SHA512.
+	Period    int64     // Period of the matching counter in seconds.
+}
+
+// This function will validate a TOTP like 'Verify', and report the details of the match at once.
+// Every field other than 'Valid' is zero if the TOTP is not valid.
+func VerifyDetailed(otp string, options TOTPValidateConfig) (VerifyResult, error) {
+	result, err := verify(otp, options, false)
+	if err != nil || result.secret == NoMatch {
+		return VerifyResult{}, err
+	}
+
+	hasher := options.Hasher
+	if len(options.Hashers) > 0 {
+		hasher = options.Hashers[result.hasher]
+	}
+
+	algorithm, err := serializableAlgorithm(hasher, options.Algorithm)
+	if err != nil {
+		algorithm = UnknownAlgorithm
+	}
+
+	return VerifyResult{Valid: true, Offset: result.offset, Algorithm: algorithm, Period: result.period}, nil
+}
+
+// This function will validate a TOTP like 'Verify', but always iterates through the entire window before returning.
+// It is slightly slower, as every token in the window is generated, but the time it takes to respond does not
+// leak the position of the matching token in the window.
+func VerifyConstantTimeAll(otp string, options TOTPValidateConfig) (bool, error) {
+	result, err := verify(otp, options, true)
+	return result.secret != NoMatch, err
+}
+
+// This function will validate a TOTP with an already decoded secret, skipping the base32 decoding of 'Verify'.
+// Both 'Secret' and 'PreviousSecret' of the validation parameters are ignored.
+func VerifyBytes(otp string, secret []byte, options TOTPValidateConfig) (bool, error) {
+	result, err := verifySecrets(otp, [][]byte{secret}, options, false)
+	return result.secret != NoMatch, err
+}
+
+// This function will decode the current and the previous secret, and validate a TOTP against them.
+// Secrets are decoded up front, so the result does not depend on whether the verification is exhaustive.
+func verify(otp string, options TOTPValidateConfig, exhaustive bool) (verification, error) {
+	// The current secret is always tried first. Position of a secret is its 'SecretMatch', offset by one.
+	secrets := []string{options.Secret}
+	if options.PreviousSecret != "" {
+		secrets = append(secrets, options.PreviousSecret)
+	}
+
+	// Decode the secrets once, as they are the same for every counter in the window.
+	secretsInBytes := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		secretInBytes, err := transformSecret(strings.ToUpper(strings.TrimSpace(secret)))
+		if err != nil {
+			return noVerification, err
+		}
+
+		secretsInBytes = append(secretsInBytes, secretInBytes)
+	}
+
+	return verifySecrets(otp, secretsInBytes, options, exhaustive)
+}
+
+// This function will validate a TOTP against every counter in the window, for every decoded secret and hasher in order.
+// A matching TOTP is then checked against the replay guard, if set.
+func verifySecrets(otp string, secrets [][]byte, options TOTPValidateConfig, exhaustive bool) (verification, error) {
+	// Remove whitespaces and hyphens from the passed OTP.
+	passcode := normalizePasscode(otp)
 
 	// Check if the length of the OTP is not equal to specified digits.
 	if len(passcode) != options.Digits {
-		return false, errors.New("passcode is not equal to the specified digits in length")
-	}
-
-	// We will try to safely compare two strings at a single moment.
-	// Also try to generate tokens in allowed windows. If one match, then that token is valid.
-	for i := counter - options.Window; i <= counter+options.Window; i++ {
-		generatedToken, err := Generate(TOTPConfig{
-			Secret:    options.Secret,
-			Period:    options.Period,
-			Timestamp: options.Timestamp,
-			Digits:    options.Digits,
-			Hasher:    options.Hasher,
-		})
+		return noVerification, errors.New("passcode is not equal to the specified digits in length")
+	}
+
+	// Reject passcodes that could never match, as every token is numeric.
+	for i := 0; i < len(passcode); i++ {
+		if passcode[i] < '0' || passcode[i] > '9' {
+			return noVerification, ErrNonDigitPasscode
+		}
+	}
+
+	// Reject weak secrets, if enabled.
+	for _, secret := range secrets {
+		if err := checkSecretLength(secret, options.MinSecretBytes); err != nil {
+			return noVerification, err
+		}
+	}
+
+	// Reject windows that are too large, instead of generating an unbounded amount of tokens.
+	if before, after := options.windowRange(); before > MaxWindow || after > MaxWindow {
+		return noVerification, fmt.Errorf("window must not be larger than %d periods", MaxWindow)
+	}
+
+	// Reject periods that would make the counter impossible to calculate.
+	for _, period := range options.periods() {
+		if err := checkPeriod(period); err != nil {
+			return noVerification, err
+		}
+	}
+
+	// Resolve the hashers once, as they are the same for every counter in the window.
+	hashers := options.Hashers
+	if len(hashers) == 0 {
+		hasher, err := resolveHasher(options.Hasher, options.Algorithm)
 		if err != nil {
-			return false, err
+			return noVerification, err
 		}
 
-		if subtle.ConstantTimeCompare([]byte(passcode), []byte(generatedToken)) == 1 {
-			return true, nil
+		hashers = []func() hash.Hash{hasher}
+	}
+
+	// Reject nil hashers, which would panic once a token is generated, and fixed offsets outside of the digest of any hasher.
+	for i, hasher := range hashers {
+		if hasher == nil {
+			return noVerification, fmt.Errorf("hasher at position %d must not be nil", i)
 		}
+		if err := checkFixedOffset(options.FixedOffset, hasher); err != nil {
+			return noVerification, err
+		}
+	}
+
+	result := matchSecrets(passcode, secrets, hashers, options, exhaustive)
+	if result.secret == NoMatch {
+		return result, nil
+	}
+
+	// Reject the OTP if the clock skew is too large, even if it is in the window.
+	if skew := result.offset * result.period; options.MaxSkewSeconds > 0 && (skew > options.MaxSkewSeconds || -skew > options.MaxSkewSeconds) {
+		return noVerification, nil
+	}
+
+	if options.ReplayGuard == nil {
+		return result, nil
 	}
 
-	return false, nil
+	// Reject the OTP if it has been used before with the same current secret, even if it matched the previous one.
+	seen, err := options.ReplayGuard.Seen(replayScope(secrets[0]), passcode, options.replayTTL())
+	if err != nil {
+		return noVerification, err
+	}
+	if seen {
+		return noVerification, nil
+	}
+
+	return result, nil
+}
+
+// This function will get the replay scope of a secret. The secret is hashed, so it is never kept by the guard.
+func replayScope(secret []byte) string {
+	digest := sha256.Sum256(secret)
+	return hex.EncodeToString(digest[:])
+}
+
+// This function will find the secret, the hasher, and the period that generate the passcode in the window.
+// If 'exhaustive' is true, the loop does not stop at the first match, and matches are accumulated with bitwise OR instead.
+func matchSecrets(passcode string, secrets [][]byte, hashers []func() hash.Hash, options TOTPValidateConfig, exhaustive bool) verification {
+	before, after := options.windowRange()
+
+	result := noVerification
+	for secretIndex, secretInBytes := range secrets {
+		for hasherIndex, hasher := range hashers {
+			// The token of a counter does not depend on the period, so the windows of multiple periods often overlap.
+			// Remember the tokens of this secret and hasher, so each counter is only hashed once.
+			tokens := map[int64]string{}
+
+			for _, period := range options.periods() {
+				// We will try to safely compare two strings at a single moment.
+				// Also try to generate tokens in allowed windows. If one match, then that token is valid.
+				// The offset of the first match is selected in constant time as well.
+				counter := options.TimestampUnit.counter(options.Timestamp, options.T0, period)
+				match, offset := 0, 0
+				for i := counter - before; i <= counter+after; i++ {
+					generatedToken, ok := tokens[i]
+					if !ok {
+						generatedToken = compute(secretInBytes, i, options.Digits, hasher, options.FixedOffset)
+						tokens[i] = generatedToken
+					}
+					tokenMatch := subtle.ConstantTimeCompare([]byte(passcode), []byte(generatedToken))
+					offset = subtle.ConstantTimeSelect(tokenMatch&^match, int(i-counter), offset)
+					match |= tokenMatch
+					if match == 1 && !exhaustive {
+						return verification{secret: SecretMatch(secretIndex + 1), hasher: hasherIndex, offset: int64(offset), period: period}
+					}
+				}
+
+				if match == 1 && result.secret == NoMatch {
+					result = verification{secret: SecretMatch(secretIndex + 1), hasher: hasherIndex, offset: int64(offset), period: period}
+				}
+			}
+		}
+	}
+
+	return result
 }
 
 // This function will generate a new OTP. In this case, it's TOTP.
 // Reference: https://datatracker.ietf.org/doc/html/rfc6238.
 func Generate(options TOTPConfig) (string, error) {
+	if err := checkPeriod(options.Period); err != nil {
+		return "", err
+	}
+
 	// Calculate counters.
-	counter := options.Timestamp / options.Period
+	counter := options.TimestampUnit.counter(options.Timestamp, options.T0, options.Period)
 
 	// Removes whitespaces for some secrets.
 	// Transform to uppercase to conform to the RFC.
 	secretTrimmed := strings.TrimSpace(options.Secret)
 	secretTrimmed = strings.ToUpper(secretTrimmed)
 
-	// Transform 'counter' into a byte array.
-	counterInBytes := transformCounter(counter)
-
 	// Transform 'secret' into a byte array.
 	secretInBytes, err := transformSecret(secretTrimmed)
 	if err != nil {
 		return "", err
 	}
+	if err := checkSecretLength(secretInBytes, options.MinSecretBytes); err != nil {
+		return "", err
+	}
+
+	// Resolve the hasher from either the hasher or the algorithm.
+	hasher, err := resolveHasher(options.Hasher, options.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	if err := checkFixedOffset(options.FixedOffset, hasher); err != nil {
+		return "", err
+	}
 
-	// Create a new OTP token based on the inputs.
-	hmac := hmac.New(options.Hasher, secretInBytes)
+	// Return the newly created OTP.
+	return compute(secretInBytes, counter, options.Digits, hasher, options.FixedOffset), nil
+}
+
+// This function will generate a new OTP with an already decoded secret, skipping the base32 decoding of 'Generate'.
+// The 'Secret' of the configurations is ignored.
+func GenerateBytes(secret []byte, options TOTPConfig) (string, error) {
+	if err := checkPeriod(options.Period); err != nil {
+		return "", err
+	}
+	if err := checkSecretLength(secret, options.MinSecretBytes); err != nil {
+		return "", err
+	}
+
+	// Resolve the hasher from either the hasher or the algorithm.
+	hasher, err := resolveHasher(options.Hasher, options.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	if err := checkFixedOffset(options.FixedOffset, hasher); err != nil {
+		return "", err
+	}
+
+	counter := options.TimestampUnit.counter(options.Timestamp, options.T0, options.Period)
+	return compute(secret, counter, options.Digits, hasher, options.FixedOffset), nil
+}
+
+// This function will generate the OTP of the current time, for the common case of an algorithm and a period.
+// Periods are truncated into whole seconds, and have to be at least a second.
+func GenerateCode(secret string, algorithm Algorithm, digits int, period time.Duration) (string, error) {
+	if period < time.Second {
+		return "", errors.New("period must be at least a second")
+	}
+
+	return Generate(TOTPConfig{
+		Secret:    secret,
+		Period:    int64(period / time.Second),
+		Timestamp: time.Now().Unix(),
+		Digits:    digits,
+		Algorithm: algorithm,
+	})
+}
+
+// Digest is used to compute the raw HMAC digest of a counter, before it is truncated into an OTP.
+// Useful for custom truncations, or to compare against reference vectors that publish the intermediate digests.
+// A nil hasher defaults to SHA1, as described in RFC 4226.
+func Digest(secret string, counter int64, hasher func() hash.Hash) ([]byte, error) {
+	secretInBytes, err := transformSecret(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return nil, err
+	}
+
+	hasher, err = resolveHasher(hasher, SHA1)
+	if err != nil {
+		return nil, err
+	}
+
+	return digest(secretInBytes, counter, hasher), nil
+}
+
+// This function will compute the HMAC digest of a counter with an already decoded secret.
+func digest(secretInBytes []byte, counter int64, hasher func() hash.Hash) []byte {
+	// Transform 'counter' into a byte array.
+	counterInBytes := transformCounter(counter)
+
+	// Hash the counter with the secret as the key. The digest overwrites the counter, as it is not needed anymore.
+	hmac := hmac.New(hasher, secretInBytes)
 	hmac.Write(counterInBytes)
-	digest := hmac.Sum(nil)
+	return hmac.Sum(counterInBytes[:0])
+}
+
+// This function will compute the OTP of a counter with an already decoded secret, as described in RFC 4226.
+// A fixed offset, if set, is used instead of the dynamic offset, and has to be checked with 'checkFixedOffset' first.
+func compute(secretInBytes []byte, counter int64, digits int, hasher func() hash.Hash, fixedOffset *int) string {
+	// Create a new OTP token based on the inputs.
+	digest := digest(secretInBytes, counter, hasher)
 
 	// After getting the digest, we get the properties of the OTP.
 	// Everything has to be casted to integer to round them.
 	offset := int(digest[len(digest)-1] & 15)
+	if fixedOffset != nil {
+		offset = *fixedOffset
+	}
 	otp := ((int(digest[offset] & 127)) << 24) |
 		((int(digest[offset+1] & 255)) << 16) |
 		((int(digest[offset+2] & 255)) << 8) |
 		(int(digest[offset+3] & 255))
-	otp = otp % int(math.Pow10(options.Digits))
+	otp = otp % int(math.Pow10(digits))
 
 	// Pad the OTP with leading zeroes.
-	token := pad(otp, options.Digits)
+	return pad(otp, digits)
+}
 
-	// Return the newly created OTP.
-	return token, nil
+// This function will generate a new OTP like 'Generate', and also return the time it stops being valid.
+// The OTP is valid until the end of the current period, without taking any window into account.
+func GenerateAt(options TOTPConfig) (token string, validUntil time.Time, err error) {
+	token, err = Generate(options)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	counter := options.TimestampUnit.counter(options.Timestamp, options.T0, options.Period)
+	validUntil = time.Unix(options.T0+(counter+1)*options.Period, 0)
+
+	return token, validUntil, nil
+}
+
+// This function will return the seconds remaining before the OTP of the current period expires.
+// A timestamp exactly on a period boundary starts a new period, so the whole period remains.
+// Timestamps before the Unix epoch are counted the same way, and a non-positive period has no seconds remaining.
+func SecondsRemaining(timestamp, period int64) int64 {
+	if period <= 0 {
+		return 0
+	}
+
+	return period - ((timestamp%period)+period)%period
+}
+
+// This function will generate a provisioning URI to be used by authenticator apps, usually rendered as a QR code.
+// Reference: https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func GenerateProvisioningURI(options ProvisioningConfig) (string, error) {
+	// Issuer and account name are required to build the label.
+	if options.Issuer == "" || options.AccountName == "" {
+		return "", errors.New("issuer and account name must not be empty")
+	}
+
+	// Algorithm has to be supported by authenticator apps.
+	if options.Algorithm.Hasher() == nil {
+		return "", fmt.Errorf("unknown algorithm %d", int(options.Algorithm))
+	}
+
+	// Secret has to be a valid base32 string.
+	if _, err := transformSecret(strings.ToUpper(strings.TrimSpace(options.Secret))); err != nil {
+		return "", err
+	}
+
+	// Build the query parameters. Padding is removed as authenticator apps do not expect it.
+	query := url.Values{}
+	query.Set("secret", strings.TrimRight(strings.ToUpper(strings.TrimSpace(options.Secret)), "="))
+	query.Set("issuer", options.Issuer)
+	query.Set("algorithm", options.Algorithm.String())
+	query.Set("digits", strconv.Itoa(options.Digits))
+	query.Set("period", strconv.FormatInt(options.Period, 10))
+
+	// Build the URI.
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + options.Issuer + ":" + options.AccountName,
+		RawQuery: query.Encode(),
+	}
+
+	// Return the newly created URI.
+	return uri.String(), nil
 }
@@ -1,16 +1,115 @@
 package otp
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func toBase32(str string) string {
 	return base32.StdEncoding.EncodeToString([]byte(str))
 }
 
+func TestHasherFromName(t *testing.T) {
+	successTests := []struct {
+		name         string
+		input        string
+		expectedSize int
+	}{
+		{
+			name:         "test_sha1",
+			input:        "sha1",
+			expectedSize: sha1.Size,
+		},
+		{
+			name:         "test_sha256",
+			input:        "sha256",
+			expectedSize: sha256.Size,
+		},
+		{
+			name:         "test_sha512",
+			input:        "SHA512",
+			expectedSize: sha512.Size,
+		},
+	}
+
+	for _, tt := range successTests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, err := HasherFromName(tt.input)
+			if err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			if hasher().Size() != tt.expectedSize {
+				t.Errorf("Size of the hasher is not the same! Got: %v, expected: %v!", hasher().Size(), tt.expectedSize)
+			}
+		})
+	}
+
+	t.Run("test_unknown_algorithm", func(t *testing.T) {
+		hasher, err := HasherFromName("md5")
+		if err == nil || hasher != nil {
+			t.Error("Test-cases should return error(s) for an unknown algorithm!")
+		}
+	})
+}
+
+func TestAlgorithmName(t *testing.T) {
+	for _, name := range []string{"SHA1", "SHA256", "SHA512"} {
+		t.Run("test_round_trip_"+name, func(t *testing.T) {
+			hasher, err := HasherFromName(name)
+			if err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			res, err := AlgorithmName(hasher)
+			if err != nil {
+				t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+			}
+
+			if res != name {
+				t.Errorf("Name of the algorithm is not the same! Got: %v, expected: %v!", res, name)
+			}
+		})
+	}
+
+	failureTests := []struct {
+		name   string
+		hasher func() hash.Hash
+	}{
+		{
+			name:   "test_nil_hasher",
+			hasher: nil,
+		},
+		{
+			name:   "test_unknown_hasher_sha512_256",
+			hasher: sha512.New512_256,
+		},
+		{
+			name:   "test_unknown_hasher_md5",
+			hasher: md5.New,
+		},
+	}
+
+	for _, tt := range failureTests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := AlgorithmName(tt.hasher)
+			if err == nil {
+				t.Errorf("Test-cases should return error(s)! Got: %v!", res)
+			}
+		})
+	}
+}
+
 func TestTransformSecret(t *testing.T) {
 	t.Run("test_transform_not_base32", func(t *testing.T) {
 		input := "not_base32"
@@ -52,6 +151,22 @@ func TestPad(t *testing.T) {
 		}
 	})
 
+	t.Run("test_padding_zero", func(t *testing.T) {
+		if res := pad(0, 10); res != "0000000000" {
+			t.Errorf("The end result of the padding should be '0000000000'! Got: %v!", res)
+		}
+	})
+
+	t.Run("test_padding_matches_format", func(t *testing.T) {
+		for _, otp := range []int{0, 7, 1234, 123456, 2053730166} {
+			for _, digits := range []int{6, 8, 10} {
+				if res, expected := pad(otp, digits), fmt.Sprintf("%0*d", digits, otp); res != expected {
+					t.Errorf("The end result of the padding should be '%s'! Got: %v!", expected, res)
+				}
+			}
+		}
+	})
+
 	t.Run("test_enough_padding", func(t *testing.T) {
 		inputOTP, inputDigits := 123456, 6
 
@@ -62,6 +177,57 @@ func TestPad(t *testing.T) {
 	})
 }
 
+func TestDigest(t *testing.T) {
+	// Intermediate HMAC-SHA1 values from RFC 4226, Appendix D.
+	sharedSecret := toBase32("12345678901234567890")
+
+	t.Run("test_digest_reference_vectors", func(t *testing.T) {
+		expected := []string{
+			"cc93cf18508d94934c64b65d8ba7667fb7cde4b0",
+			"75a48a19d4cbe100644e8ac1397eea747a2d33ab",
+			"0bacb7fa082fef30782211938bc1c5e70416ff44",
+		}
+
+		for counter, want := range expected {
+			res, err := Digest(sharedSecret, int64(counter), sha1.New)
+			if err != nil {
+				t.Errorf("Error should be null! Got: %v!", err)
+			}
+			if got := fmt.Sprintf("%x", res); got != want {
+				t.Errorf("Expected digest %s for counter %d! Got: %s!", want, counter, got)
+			}
+		}
+	})
+
+	t.Run("test_digest_length", func(t *testing.T) {
+		for _, hasher := range []func() hash.Hash{sha1.New, sha256.New, sha512.New} {
+			res, err := Digest(sharedSecret, 1, hasher)
+			if err != nil {
+				t.Errorf("Error should be null! Got: %v!", err)
+			}
+			if len(res) != hasher().Size() {
+				t.Errorf("Expected a digest of %d bytes! Got: %d!", hasher().Size(), len(res))
+			}
+		}
+	})
+
+	t.Run("test_digest_default_hasher", func(t *testing.T) {
+		res, err := Digest(sharedSecret, 0, nil)
+		if err != nil {
+			t.Errorf("Error should be null! Got: %v!", err)
+		}
+		if len(res) != sha1.Size {
+			t.Errorf("Expected a SHA1 digest! Got %d bytes!", len(res))
+		}
+	})
+
+	t.Run("test_digest_invalid_secret", func(t *testing.T) {
+		if _, err := Digest("!!!", 0, sha1.New); err == nil {
+			t.Error("Error should not be null!")
+		}
+	})
+}
+
 func TestGenerate(t *testing.T) {
 	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
 	period := 30
@@ -156,6 +322,280 @@ func TestGenerate(t *testing.T) {
 	}
 }
 
+func TestGenerateCode(t *testing.T) {
+	secret := toBase32("The quick brown fox jumps over the lazy dog.")
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		hasher    func() hash.Hash
+		digits    int
+		period    time.Duration
+	}{
+		{name: "test_sha1", algorithm: SHA1, hasher: sha1.New, digits: 6, period: 30 * time.Second},
+		{name: "test_sha256", algorithm: SHA256, hasher: sha256.New, digits: 8, period: 60 * time.Second},
+		{name: "test_sha512", algorithm: SHA512, hasher: sha512.New, digits: 10, period: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			period := int64(tt.period / time.Second)
+			before := time.Now().Unix()
+			token, err := GenerateCode(secret, tt.algorithm, tt.digits, tt.period)
+			if err != nil {
+				t.Fatalf("Generation should not return error(s)! Got: %v!", err)
+			}
+			if time.Now().Unix()/period != before/period {
+				t.Skip("Period has changed during the generation.")
+			}
+
+			expected, err := Generate(TOTPConfig{Secret: secret, Period: period, Timestamp: before, Digits: tt.digits, Hasher: tt.hasher})
+			if err != nil {
+				t.Fatalf("Generation should not return error(s)! Got: %v!", err)
+			}
+
+			if token != expected {
+				t.Errorf("Expected and actual tokens are not the same! Expected: %s, got: %s!", expected, token)
+			}
+		})
+	}
+
+	t.Run("test_unknown_algorithm", func(t *testing.T) {
+		if _, err := GenerateCode(secret, Algorithm(99), 6, 30*time.Second); err == nil {
+			t.Errorf("Generation with an unknown algorithm should return an error!")
+		}
+	})
+
+	t.Run("test_period_too_short", func(t *testing.T) {
+		if _, err := GenerateCode(secret, SHA1, 6, time.Millisecond); err == nil {
+			t.Errorf("Generation with a period shorter than a second should return an error!")
+		}
+	})
+}
+
+func TestGenerateAt(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+
+	tests := []struct {
+		name               string
+		timestamp          int64
+		expectedValidUntil int64
+	}{
+		{name: "test_start_of_period", timestamp: 1629795960, expectedValidUntil: 1629795990},
+		{name: "test_middle_of_period", timestamp: 1629795975, expectedValidUntil: 1629795990},
+		{name: "test_end_of_period", timestamp: 1629795989, expectedValidUntil: 1629795990},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := TOTPConfig{
+				Secret:    sharedSecret,
+				Period:    30,
+				Timestamp: tt.timestamp,
+				Digits:    10,
+				Hasher:    sha512.New,
+			}
+
+			token, validUntil, err := GenerateAt(options)
+			if err != nil {
+				t.Errorf("Generation should not return error(s)! Got: %v!", err)
+			}
+
+			if token != "2053730166" {
+				t.Errorf("Generated token is incorrect. Got: %v, expected: %v!", token, "2053730166")
+			}
+
+			if validUntil.Unix() != tt.expectedValidUntil {
+				t.Errorf("Expiry of the token is incorrect. Got: %v, expected: %v!", validUntil.Unix(), tt.expectedValidUntil)
+			}
+		})
+	}
+
+	t.Run("test_generate_at_invalid_secret", func(t *testing.T) {
+		_, _, err := GenerateAt(TOTPConfig{Secret: "invalid_base32", Period: 30, Digits: 6, Hasher: sha512.New})
+		if err == nil {
+			t.Errorf("Generation with an invalid secret should return an error!")
+		}
+	})
+}
+
+func TestSecondsRemaining(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp int64
+		period    int64
+		expected  int64
+	}{
+		{name: "test_on_boundary", timestamp: 1629795960, period: 30, expected: 30},
+		{name: "test_one_second_in", timestamp: 1629795961, period: 30, expected: 29},
+		{name: "test_last_second", timestamp: 1629795989, period: 30, expected: 1},
+		{name: "test_zero_timestamp", timestamp: 0, period: 60, expected: 60},
+		{name: "test_other_period", timestamp: 1629795965, period: 60, expected: 55},
+		{name: "test_negative_timestamp", timestamp: -1, period: 30, expected: 1},
+		{name: "test_negative_boundary", timestamp: -30, period: 30, expected: 30},
+		{name: "test_zero_period", timestamp: 1629795965, period: 0, expected: 0},
+		{name: "test_negative_period", timestamp: 1629795965, period: -30, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remaining := SecondsRemaining(tt.timestamp, tt.period)
+			if remaining != tt.expected {
+				t.Errorf("Expected and actual seconds remaining are not the same! Expected: %d, got: %d!", tt.expected, remaining)
+			}
+		})
+	}
+}
+
+func TestWithWindow(t *testing.T) {
+	config := TOTPConfig{
+		Secret:         toBase32("The quick brown fox jumps over the lazy dog."),
+		Period:         60,
+		Timestamp:      1629795965000,
+		T0:             15,
+		Digits:         8,
+		Hasher:         sha256.New,
+		Algorithm:      SHA512,
+		TimestampUnit:  Milliseconds,
+		MinSecretBytes: 16,
+	}
+	validation := config.WithWindow(2)
+
+	if validation.Window != 2 {
+		t.Errorf("Window should be 2! Got: %v!", validation.Window)
+	}
+
+	// Every field of the configurations has to be copied, so new fields are not forgotten.
+	configValue, validationValue := reflect.ValueOf(config), reflect.ValueOf(validation)
+	for i := 0; i < configValue.NumField(); i++ {
+		name := configValue.Type().Field(i).Name
+		field := validationValue.FieldByName(name)
+		if !field.IsValid() {
+			t.Errorf("Field %s does not exist in the validation parameters!", name)
+			continue
+		}
+
+		expected, got := configValue.Field(i), field
+		if expected.Kind() == reflect.Func {
+			expected, got = reflect.ValueOf(expected.Pointer()), reflect.ValueOf(got.Pointer())
+		}
+		if expected.Interface() != got.Interface() {
+			t.Errorf("Field %s should be %v! Got: %v!", name, expected, got)
+		}
+	}
+
+	token, err := Generate(config)
+	if err != nil {
+		t.Errorf("Generation should not return error(s)! Got: %v!", err)
+	}
+	if valid, err := Verify(token, validation); err != nil || !valid {
+		t.Errorf("Token should be valid with the created validation parameters! Got: %v, %v!", valid, err)
+	}
+}
+
+func TestT0(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	generate := func(timestamp, t0 int64) string {
+		token, err := Generate(TOTPConfig{Secret: sharedSecret, Period: 30, Timestamp: timestamp, T0: t0, Digits: 10, Hasher: sha512.New})
+		if err != nil {
+			t.Errorf("Generation should not return error(s)! Got: %v!", err)
+		}
+		return token
+	}
+
+	t.Run("test_t0_shifts_periods", func(t *testing.T) {
+		// 1629795960 starts a period with T0 of 0, but it is in the middle of a period with T0 of 15.
+		if generate(1629795960, 0) == generate(1629795960, 15) {
+			t.Errorf("Tokens with different T0 should be different at the same timestamp!")
+		}
+
+		if generate(1629795960, 0) != generate(1629795975, 15) {
+			t.Errorf("Tokens with the same counter should be equal, regardless of T0!")
+		}
+	})
+
+	t.Run("test_t0_verify", func(t *testing.T) {
+		valid, err := Verify(generate(1629795975, 15), TOTPValidateConfig{Secret: sharedSecret, Period: 30, Timestamp: 1629795975, T0: 15, Digits: 10, Hasher: sha512.New})
+		if err != nil || !valid {
+			t.Errorf("Token with T0 should be valid! Got: %v, %v!", valid, err)
+		}
+	})
+
+	t.Run("test_t0_valid_until", func(t *testing.T) {
+		_, validUntil, err := GenerateAt(TOTPConfig{Secret: sharedSecret, Period: 30, Timestamp: 1629795975, T0: 15, Digits: 10, Hasher: sha512.New})
+		if err != nil || validUntil.Unix() != 1629796005 {
+			t.Errorf("Expiry of the token with T0 is incorrect. Got: %v, %v!", validUntil.Unix(), err)
+		}
+	})
+}
+
+func TestTimestampUnit(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	generate := func(timestamp int64, unit TimestampUnit) string {
+		token, err := Generate(TOTPConfig{Secret: sharedSecret, Period: 30, Timestamp: timestamp, Digits: 10, Hasher: sha512.New, TimestampUnit: unit})
+		if err != nil {
+			t.Errorf("Generation should not return error(s)! Got: %v!", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name         string
+		milliseconds int64
+		seconds      int64
+	}{
+		{name: "test_milliseconds_start_of_period", milliseconds: 1629795960000, seconds: 1629795960},
+		{name: "test_milliseconds_middle_of_period", milliseconds: 1629795965500, seconds: 1629795965},
+		{name: "test_milliseconds_end_of_period", milliseconds: 1629795989999, seconds: 1629795989},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := generate(tt.seconds, Seconds)
+			if token := generate(tt.milliseconds, Milliseconds); token != expected {
+				t.Errorf("Expected the same token as the seconds timestamp %s! Got: %s!", expected, token)
+			}
+
+			valid, err := Verify(expected, TOTPValidateConfig{Secret: sharedSecret, Period: 30, Timestamp: tt.milliseconds, Digits: 10, Hasher: sha512.New, TimestampUnit: Milliseconds})
+			if err != nil || !valid {
+				t.Errorf("Token should be valid with the milliseconds timestamp! Got: %v, %v!", valid, err)
+			}
+		})
+	}
+
+	t.Run("test_milliseconds_with_t0", func(t *testing.T) {
+		token, err := Generate(TOTPConfig{Secret: sharedSecret, Period: 30, Timestamp: 1629795975000, T0: 15, Digits: 10, Hasher: sha512.New, TimestampUnit: Milliseconds})
+		if err != nil || token != generate(1629795960, Seconds) {
+			t.Errorf("T0 should stay in seconds with a milliseconds timestamp! Got: %v, %v!", token, err)
+		}
+	})
+}
+
+func TestFormatForDisplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		groupSize int
+		sep       string
+		expected  string
+	}{
+		{name: "test_six_digits_groups_of_three", token: "205373", groupSize: 3, sep: " ", expected: "205 373"},
+		{name: "test_ten_digits_groups_of_four", token: "2053730166", groupSize: 4, sep: "-", expected: "2053-7301-66"},
+		{name: "test_ten_digits_groups_of_five", token: "2053730166", groupSize: 5, sep: "-", expected: "20537-30166"},
+		{name: "test_shorter_than_group", token: "2053", groupSize: 6, sep: "-", expected: "2053"},
+		{name: "test_non_positive_group_size", token: "2053730166", groupSize: 0, sep: "-", expected: "2053730166"},
+		{name: "test_empty_token", token: "", groupSize: 3, sep: "-", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted := FormatForDisplay(tt.token, tt.groupSize, tt.sep)
+			if formatted != tt.expected {
+				t.Errorf("Expected and actual formatted tokens are not the same! Expected: %s, got: %s!", tt.expected, formatted)
+			}
+		})
+	}
+}
+
 func TestVerify(t *testing.T) {
 	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
 	period := 30
@@ -264,73 +704,145 @@ func TestVerify(t *testing.T) {
 			},
 		},
 		{
-			name: "test_otp_10_seconds",
-			otp:  "2053730166", // OTP generated at 1629795960
+			name: "test_otp_grouped_with_spaces",
+			otp:  "205 373 0166", // OTP generated at 1629795960
 			totpValidation: TOTPValidateConfig{
 				Secret:    sharedSecret,
 				Period:    int64(period),
-				Timestamp: 1629795970,
+				Timestamp: 1629795965,
 				Digits:    10,
 				Hasher:    sha512.New,
 				Window:    1,
 			},
 		},
 		{
-			name: "test_otp_15_seconds",
-			otp:  "2053730166", // OTP generated at 1629795960
+			name: "test_otp_grouped_with_hyphens",
+			otp:  "205-373-0166", // OTP generated at 1629795960
 			totpValidation: TOTPValidateConfig{
 				Secret:    sharedSecret,
 				Period:    int64(period),
-				Timestamp: 1629795975,
+				Timestamp: 1629795965,
 				Digits:    10,
 				Hasher:    sha512.New,
 				Window:    1,
 			},
 		},
 		{
-			name: "test_otp_20_seconds",
-			otp:  "2053730166", // OTP generated at 1629795960
+			name: "test_otp_grouped_with_mixed_whitespaces",
+			otp:  " 20537\t30166\n", // OTP generated at 1629795960
 			totpValidation: TOTPValidateConfig{
 				Secret:    sharedSecret,
 				Period:    int64(period),
-				Timestamp: 1629795980,
+				Timestamp: 1629795965,
 				Digits:    10,
 				Hasher:    sha512.New,
 				Window:    1,
 			},
 		},
 		{
-			name: "test_otp_25_seconds",
+			name: "test_otp_padded_with_nbsp",
+			otp:  "\u00a02053730166\u00a0", // OTP generated at 1629795960
+			totpValidation: TOTPValidateConfig{
+				Secret:    sharedSecret,
+				Period:    int64(period),
+				Timestamp: 1629795965,
+				Digits:    10,
+				Hasher:    sha512.New,
+				Window:    1,
+			},
+		},
+		{
+			name: "test_otp_padded_with_zero_width_spaces",
+			otp:  "\u200b2053730166\u200b\ufeff", // OTP generated at 1629795960
+			totpValidation: TOTPValidateConfig{
+				Secret:    sharedSecret,
+				Period:    int64(period),
+				Timestamp: 1629795965,
+				Digits:    10,
+				Hasher:    sha512.New,
+				Window:    1,
+			},
+		},
+		{
+			name: "test_otp_grouped_with_zero_width_joiners",
+			otp:  "20537\u200d30166", // OTP generated at 1629795960
+			totpValidation: TOTPValidateConfig{
+				Secret:    sharedSecret,
+				Period:    int64(period),
+				Timestamp: 1629795965,
+				Digits:    10,
+				Hasher:    sha512.New,
+				Window:    1,
+			},
+		},
+		{
+			name: "test_otp_10_seconds",
 			otp:  "2053730166", // OTP generated at 1629795960
 			totpValidation: TOTPValidateConfig{
 				Secret:    sharedSecret,
 				Period:    int64(period),
-				Timestamp: 1629795985,
+				Timestamp: 1629795970,
 				Digits:    10,
 				Hasher:    sha512.New,
 				Window:    1,
 			},
 		},
 		{
-			name: "test_otp_30_seconds",
+			name: "test_otp_15_seconds",
 			otp:  "2053730166", // OTP generated at 1629795960
 			totpValidation: TOTPValidateConfig{
 				Secret:    sharedSecret,
 				Period:    int64(period),
-				Timestamp: 1629795989, // 1 second before 30 seconds
+				Timestamp: 1629795975,
 				Digits:    10,
 				Hasher:    sha512.New,
 				Window:    1,
 			},
 		},
-	}
-
-	for _, tt := range errorTests {
-		t.Run(tt.name, func(t *testing.T) {
-			valid, err := Verify(tt.otp, tt.totpValidation)
-			if err == nil {
-				t.Errorf("Test case should return an error! Got: %v, expected: %v!", err, valid)
-			}
+		{
+			name: "test_otp_20_seconds",
+			otp:  "2053730166", // OTP generated at 1629795960
+			totpValidation: TOTPValidateConfig{
+				Secret:    sharedSecret,
+				Period:    int64(period),
+				Timestamp: 1629795980,
+				Digits:    10,
+				Hasher:    sha512.New,
+				Window:    1,
+			},
+		},
+		{
+			name: "test_otp_25_seconds",
+			otp:  "2053730166", // OTP generated at 1629795960
+			totpValidation: TOTPValidateConfig{
+				Secret:    sharedSecret,
+				Period:    int64(period),
+				Timestamp: 1629795985,
+				Digits:    10,
+				Hasher:    sha512.New,
+				Window:    1,
+			},
+		},
+		{
+			name: "test_otp_30_seconds",
+			otp:  "2053730166", // OTP generated at 1629795960
+			totpValidation: TOTPValidateConfig{
+				Secret:    sharedSecret,
+				Period:    int64(period),
+				Timestamp: 1629795989, // 1 second before 30 seconds
+				Digits:    10,
+				Hasher:    sha512.New,
+				Window:    1,
+			},
+		},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := Verify(tt.otp, tt.totpValidation)
+			if err == nil {
+				t.Errorf("Test case should return an error! Got: %v, expected: %v!", err, valid)
+			}
 		})
 	}
 
@@ -360,3 +872,878 @@ func TestVerify(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyWindowPositions(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	timestamp := int64(1629795965)
+	window := int64(2)
+
+	verifiers := []struct {
+		name   string
+		verify func(string, TOTPValidateConfig) (bool, error)
+	}{
+		{name: "verify", verify: Verify},
+		{name: "verify_constant_time_all", verify: VerifyConstantTimeAll},
+	}
+
+	for _, verifier := range verifiers {
+		for offset := -window - 1; offset <= window+1; offset++ {
+			t.Run(fmt.Sprintf("test_%s_offset_%d", verifier.name, offset), func(t *testing.T) {
+				otp, err := Generate(TOTPConfig{
+					Secret:    sharedSecret,
+					Period:    30,
+					Timestamp: timestamp + offset*30,
+					Digits:    10,
+					Hasher:    sha512.New,
+				})
+				if err != nil {
+					t.Errorf("Generation should not return error(s)! Got: %v!", err)
+				}
+
+				valid, err := verifier.verify(otp, TOTPValidateConfig{
+					Secret:    sharedSecret,
+					Period:    30,
+					Timestamp: timestamp,
+					Digits:    10,
+					Hasher:    sha512.New,
+					Window:    window,
+				})
+				if err != nil {
+					t.Errorf("Verification should not return error(s)! Got: %v!", err)
+				}
+
+				expected := offset >= -window && offset <= window
+				if valid != expected {
+					t.Errorf("Result of the verification at offset %d is incorrect. Got: %v, expected: %v!", offset, valid, expected)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyAsymmetricWindow(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	timestamp := int64(1629795965)
+
+	tests := []struct {
+		name     string
+		offset   int64
+		expected bool
+	}{
+		{name: "test_past_code_within_window_before", offset: -2, expected: true},
+		{name: "test_past_code_beyond_window_before", offset: -3, expected: false},
+		{name: "test_current_code", offset: 0, expected: true},
+		{name: "test_future_code_beyond_window_after", offset: 1, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otp, err := Generate(TOTPConfig{
+				Secret:    sharedSecret,
+				Period:    30,
+				Timestamp: timestamp + tt.offset*30,
+				Digits:    10,
+				Hasher:    sha512.New,
+			})
+			if err != nil {
+				t.Errorf("Generation should not return error(s)! Got: %v!", err)
+			}
+
+			valid, err := Verify(otp, TOTPValidateConfig{
+				Secret:       sharedSecret,
+				Period:       30,
+				Timestamp:    timestamp,
+				Digits:       10,
+				Hasher:       sha512.New,
+				Window:       5, // ignored, as the asymmetric window is set.
+				WindowBefore: 2,
+				WindowAfter:  0,
+			})
+			if err != nil {
+				t.Errorf("Verification should not return error(s)! Got: %v!", err)
+			}
+
+			if valid != tt.expected {
+				t.Errorf("Result of the verification is incorrect. Got: %v, expected: %v!", valid, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVerifyPreviousSecret(t *testing.T) {
+	currentSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	previousSecret := toBase32("Pack my box with five dozen liquor jugs.")
+	timestamp := int64(1629795965)
+
+	previousOTP, err := Generate(TOTPConfig{Secret: previousSecret, Period: 30, Timestamp: timestamp, Digits: 10, Hasher: sha512.New})
+	if err != nil {
+		t.Errorf("Generation should not return error(s)! Got: %v!", err)
+	}
+
+	tests := []struct {
+		name           string
+		otp            string
+		previousSecret string
+		expected       SecretMatch
+	}{
+		{name: "test_current_secret", otp: "2053730166", previousSecret: previousSecret, expected: CurrentSecretMatch},
+		{name: "test_previous_secret", otp: previousOTP, previousSecret: previousSecret, expected: PreviousSecretMatch},
+		{name: "test_previous_secret_not_set", otp: previousOTP, previousSecret: "", expected: NoMatch},
+		{name: "test_no_secret_matches", otp: "1234567890", previousSecret: previousSecret, expected: NoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := TOTPValidateConfig{
+				Secret:         currentSecret,
+				PreviousSecret: tt.previousSecret,
+				Period:         30,
+				Timestamp:      timestamp,
+				Digits:         10,
+				Hasher:         sha512.New,
+				Window:         1,
+			}
+
+			match, err := VerifyMatch(tt.otp, options)
+			if err != nil {
+				t.Errorf("Verification should not return error(s)! Got: %v!", err)
+			}
+
+			if match != tt.expected {
+				t.Errorf("Matched secret is incorrect. Got: %v, expected: %v!", match, tt.expected)
+			}
+
+			valid, _ := Verify(tt.otp, options)
+			validConstantTime, _ := VerifyConstantTimeAll(tt.otp, options)
+			if valid != (tt.expected != NoMatch) || validConstantTime != valid {
+				t.Errorf("Result of the verification is incorrect. Got: %v and %v!", valid, validConstantTime)
+			}
+		})
+	}
+}
+
+func TestVerifyInvalidPreviousSecret(t *testing.T) {
+	// The OTP matches the current secret, but the previous secret is not valid base32.
+	options := TOTPValidateConfig{
+		Secret:         toBase32("The quick brown fox jumps over the lazy dog."),
+		PreviousSecret: "invalid_base32",
+		Period:         30,
+		Timestamp:      1629795965,
+		Digits:         10,
+		Hasher:         sha512.New,
+		Window:         1,
+	}
+
+	if valid, err := Verify("2053730166", options); err == nil || valid {
+		t.Errorf("Verification with an invalid previous secret should return an error! Got: %v, %v!", valid, err)
+	}
+
+	if valid, err := VerifyConstantTimeAll("2053730166", options); err == nil || valid {
+		t.Errorf("Exhaustive verification with an invalid previous secret should return an error! Got: %v, %v!", valid, err)
+	}
+}
+
+func TestVerifyHashers(t *testing.T) {
+	secret := toBase32("The quick brown fox jumps over the lazy dog.")
+	generateCode := func(hasher func() hash.Hash) string {
+		token, err := Generate(TOTPConfig{Secret: secret, Period: 30, Timestamp: 1629795965, Digits: 10, Hasher: hasher})
+		if err != nil {
+			t.Fatalf("Generation should not return error(s)! Got: %v!", err)
+		}
+
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		otp      string
+		hashers  []func() hash.Hash
+		expected int
+	}{
+		{name: "test_sha1_code_sha1_first", otp: generateCode(sha1.New), hashers: []func() hash.Hash{sha1.New, sha512.New}, expected: 0},
+		{name: "test_sha1_code_sha512_first", otp: generateCode(sha1.New), hashers: []func() hash.Hash{sha512.New, sha1.New}, expected: 1},
+		{name: "test_sha512_code", otp: generateCode(sha512.New), hashers: []func() hash.Hash{sha1.New, sha512.New}, expected: 1},
+		{name: "test_sha256_code_not_listed", otp: generateCode(sha256.New), hashers: []func() hash.Hash{sha1.New, sha512.New}, expected: -1},
+		{name: "test_empty_hashers_fallback", otp: generateCode(sha512.New), hashers: nil, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := TOTPValidateConfig{
+				Secret:    secret,
+				Period:    30,
+				Timestamp: 1629795965,
+				Digits:    10,
+				Hasher:    sha512.New,
+				Window:    1,
+				Hashers:   tt.hashers,
+			}
+
+			index, err := VerifyHasher(tt.otp, options)
+			if err != nil {
+				t.Errorf("Verification should not return error(s)! Got: %v!", err)
+			}
+
+			if index != tt.expected {
+				t.Errorf("Expected and actual hashers are not the same! Expected: %d, got: %d!", tt.expected, index)
+			}
+
+			valid, err := VerifyConstantTimeAll(tt.otp, options)
+			if err != nil || valid != (tt.expected != -1) {
+				t.Errorf("Result of the constant time verification is incorrect. Got: %v and %v!", valid, err)
+			}
+		})
+	}
+
+	t.Run("test_nil_hasher", func(t *testing.T) {
+		options := TOTPValidateConfig{
+			Secret:    secret,
+			Period:    30,
+			Timestamp: 1629795965,
+			Digits:    10,
+			Window:    1,
+			Hashers:   []func() hash.Hash{sha1.New, nil},
+		}
+
+		valid, err := Verify(generateCode(sha512.New), options)
+		if err == nil || valid {
+			t.Errorf("Nil hashers should return error(s)! Got: %v and %v!", valid, err)
+		}
+	})
+}
+
+func TestVerifyWithOffset(t *testing.T) {
+	secret := toBase32("The quick brown fox jumps over the lazy dog.")
+	for _, offset := range []int64{-2, -1, 0, 1, 2} {
+		t.Run(fmt.Sprintf("test_offset_%d", offset), func(t *testing.T) {
+			token, err := Generate(TOTPConfig{Secret: secret, Period: 30, Timestamp: 1629795965 + offset*30, Digits: 10, Hasher: sha512.New})
+			if err != nil {
+				t.Fatalf("Generation should not return error(s)! Got: %v!", err)
+			}
+
+			valid, matchedOffset, err := VerifyWithOffset(token, TOTPValidateConfig{Secret: secret, Period: 30, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New, Window: 2})
+			if err != nil || !valid {
+				t.Errorf("Token should be valid. Got: %v and %v!", valid, err)
+			}
+
+			if matchedOffset != offset {
+				t.Errorf("Expected and actual offsets are not the same! Expected: %d, got: %d!", offset, matchedOffset)
+			}
+		})
+	}
+}
+
+func TestVerifyMaxSkew(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	timestamp := int64(1629795965)
+	generate := func(timestamp, period int64) string {
+		token, err := Generate(TOTPConfig{Secret: sharedSecret, Period: period, Timestamp: timestamp, Digits: 6, Hasher: sha1.New})
+		if err != nil {
+			t.Errorf("Generation should not return error(s)! Got: %v!", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name           string
+		token          string
+		periods        []int64
+		maxSkewSeconds int64
+		expected       bool
+	}{
+		{name: "test_max_skew_disabled", token: generate(timestamp-30, 30), periods: []int64{30}, maxSkewSeconds: 0, expected: true},
+		{name: "test_max_skew_current_period", token: generate(timestamp, 30), periods: []int64{30}, maxSkewSeconds: 15, expected: true},
+		{name: "test_max_skew_previous_period_rejected", token: generate(timestamp-30, 30), periods: []int64{30}, maxSkewSeconds: 15, expected: false},
+		{name: "test_max_skew_next_period_rejected", token: generate(timestamp+30, 30), periods: []int64{30}, maxSkewSeconds: 15, expected: false},
+		{name: "test_max_skew_previous_period_allowed", token: generate(timestamp-30, 30), periods: []int64{30}, maxSkewSeconds: 30, expected: true},
+		{name: "test_max_skew_longer_period_rejected", token: generate(timestamp-60, 60), periods: []int64{30, 60}, maxSkewSeconds: 30, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := TOTPValidateConfig{Secret: sharedSecret, Periods: tt.periods, Timestamp: timestamp, Digits: 6, Hasher: sha1.New, Window: 1, MaxSkewSeconds: tt.maxSkewSeconds}
+
+			valid, err := Verify(tt.token, options)
+			if err != nil {
+				t.Errorf("Verification should not return error(s)! Got: %v!", err)
+			}
+			if valid != tt.expected {
+				t.Errorf("Expected validity %v! Got: %v!", tt.expected, valid)
+			}
+		})
+	}
+}
+
+func TestVerifyFor(t *testing.T) {
+	errUnknownUser := errors.New("unknown user")
+	secrets := map[string]string{
+		"kaede":  toBase32("kaedeKIMURA"),
+		"kimura": toBase32("kimuraKAEDE"),
+	}
+	lookup := func(userID string) (TOTPValidateConfig, error) {
+		secret, ok := secrets[userID]
+		if !ok {
+			return TOTPValidateConfig{}, errUnknownUser
+		}
+
+		return TOTPValidateConfig{Secret: secret, Period: 30, Timestamp: 1629795965, Digits: 6, Hasher: sha1.New, Window: 1}, nil
+	}
+
+	token, err := Generate(TOTPConfig{Secret: secrets["kaede"], Period: 30, Timestamp: 1629795965, Digits: 6, Hasher: sha1.New})
+	if err != nil {
+		t.Errorf("Generation should not return error(s)! Got: %v!", err)
+	}
+
+	tests := []struct {
+		name          string
+		userID        string
+		expectedValid bool
+		expectedError error
+	}{
+		{name: "test_verify_for_known_user", userID: "kaede", expectedValid: true},
+		{name: "test_verify_for_another_user", userID: "kimura", expectedValid: false},
+		{name: "test_verify_for_unknown_user", userID: "unknown", expectedValid: false, expectedError: errUnknownUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := VerifyFor(tt.userID, token, lookup)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("Expected error %v! Got: %v!", tt.expectedError, err)
+			}
+			if valid != tt.expectedValid {
+				t.Errorf("Expected validity %v! Got: %v!", tt.expectedValid, valid)
+			}
+		})
+	}
+
+	t.Run("test_verify_for_nil_lookup", func(t *testing.T) {
+		valid, err := VerifyFor("kaede", token, nil)
+		if valid || !errors.Is(err, ErrNilLookup) {
+			t.Errorf("Verification without a lookup should return 'ErrNilLookup'! Got: %v and %v!", valid, err)
+		}
+	})
+}
+
+func TestVerifyDetailed(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	timestamp := int64(1629795965)
+	generate := func(timestamp, period int64, hasher func() hash.Hash) string {
+		token, err := Generate(TOTPConfig{Secret: sharedSecret, Period: period, Timestamp: timestamp, Digits: 8, Hasher: hasher})
+		if err != nil {
+			t.Errorf("Generation should not return error(s)! Got: %v!", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		options  TOTPValidateConfig
+		expected VerifyResult
+	}{
+		{
+			name:     "test_detailed_previous_period",
+			token:    generate(timestamp-60, 60, sha256.New),
+			options:  TOTPValidateConfig{Secret: sharedSecret, Periods: []int64{30, 60}, Timestamp: timestamp, Digits: 8, Hashers: []func() hash.Hash{sha1.New, sha256.New}, Window: 1},
+			expected: VerifyResult{Valid: true, Offset: -1, Algorithm: SHA256, Period: 60},
+		},
+		{
+			name:     "test_detailed_next_period",
+			token:    generate(timestamp+30, 30, sha512.New),
+			options:  TOTPValidateConfig{Secret: sharedSecret, Period: 30, Timestamp: timestamp, Digits: 8, Algorithm: SHA512, Window: 1},
+			expected: VerifyResult{Valid: true, Offset: 1, Algorithm: SHA512, Period: 30},
+		},
+		{
+			name:     "test_detailed_custom_hasher",
+			token:    generate(timestamp, 30, md5.New),
+			options:  TOTPValidateConfig{Secret: sharedSecret, Period: 30, Timestamp: timestamp, Digits: 8, Hasher: md5.New},
+			expected: VerifyResult{Valid: true, Offset: 0, Algorithm: UnknownAlgorithm, Period: 30},
+		},
+		{
+			name:     "test_detailed_invalid",
+			token:    "00000000",
+			options:  TOTPValidateConfig{Secret: sharedSecret, Period: 30, Timestamp: timestamp, Digits: 8, Algorithm: SHA1, Window: 1},
+			expected: VerifyResult{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := VerifyDetailed(tt.token, tt.options)
+			if err != nil {
+				t.Errorf("Verification should not return error(s)! Got: %v!", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %+v! Got: %+v!", tt.expected, result)
+			}
+
+			valid, _ := Verify(tt.token, tt.options)
+			if valid != result.Valid {
+				t.Errorf("'Verify' should agree with 'VerifyDetailed'! Got: %v!", valid)
+			}
+		})
+	}
+}
+
+func TestVerifyMaxWindow(t *testing.T) {
+	secret := toBase32("The quick brown fox jumps over the lazy dog.")
+	tests := []struct {
+		name    string
+		options TOTPValidateConfig
+	}{
+		{name: "test_huge_window", options: TOTPValidateConfig{Window: 1e9}},
+		{name: "test_window_over_cap", options: TOTPValidateConfig{Window: MaxWindow + 1}},
+		{name: "test_huge_window_before", options: TOTPValidateConfig{WindowBefore: 1e9}},
+		{name: "test_huge_window_after", options: TOTPValidateConfig{WindowAfter: 1e9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Secret = secret
+			tt.options.Period = 30
+			tt.options.Timestamp = 1629795965
+			tt.options.Digits = 10
+			tt.options.Hasher = sha512.New
+
+			valid, err := Verify("1234567890", tt.options)
+			if err == nil {
+				t.Errorf("Verification with an oversized window should return an error! Got: %v!", err)
+			}
+
+			if valid {
+				t.Errorf("Result of the verification should be invalid. Got: %v!", valid)
+			}
+		})
+	}
+
+	t.Run("test_window_at_cap", func(t *testing.T) {
+		_, err := Verify("1234567890", TOTPValidateConfig{Secret: secret, Period: 30, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New, Window: MaxWindow})
+		if err != nil {
+			t.Errorf("Verification with the maximum window should not return error(s)! Got: %v!", err)
+		}
+	})
+}
+
+func TestVerifyNonDigitPasscode(t *testing.T) {
+	secret := toBase32("The quick brown fox jumps over the lazy dog.")
+	tests := []struct {
+		name string
+		otp  string
+	}{
+		{name: "test_trailing_letters", otp: "20537301a6"},
+		{name: "test_leading_sign", otp: "+205373016"},
+		{name: "test_punctuation", otp: "2053.73016"},
+		{name: "test_letters_after_normalization", otp: "2053 7301-ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := Verify(tt.otp, TOTPValidateConfig{Secret: secret, Period: 30, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New, Window: 1})
+			if !errors.Is(err, ErrNonDigitPasscode) {
+				t.Errorf("Verification should return 'ErrNonDigitPasscode'! Got: %v!", err)
+			}
+
+			if valid {
+				t.Errorf("Result of the verification should be invalid. Got: %v!", valid)
+			}
+		})
+	}
+}
+
+func TestGenerateInvalidPeriod(t *testing.T) {
+	secret := toBase32("12345678901234567890")
+
+	for _, period := range []int64{0, -30} {
+		config := TOTPConfig{Secret: secret, Period: period, Timestamp: 59, Digits: 6, Hasher: sha1.New}
+
+		if _, err := Generate(config); err == nil {
+			t.Errorf("Generation with a period of %d should return an error!", period)
+		}
+
+		if _, err := GenerateBytes([]byte("12345678901234567890"), config); err == nil {
+			t.Errorf("Generation of bytes with a period of %d should return an error!", period)
+		}
+
+		if _, _, err := GenerateAt(config); err == nil {
+			t.Errorf("Generation with the expiration with a period of %d should return an error!", period)
+		}
+	}
+}
+
+func TestFixedOffset(t *testing.T) {
+	// Counter 1 of RFC 4226, Appendix D, which has a dynamic offset of 11.
+	config := TOTPConfig{
+		Secret:    toBase32("12345678901234567890"),
+		Period:    30,
+		Timestamp: 59,
+		Digits:    6,
+		Hasher:    sha1.New,
+	}
+	offset := func(offset int) *int { return &offset }
+
+	t.Run("test_fixed_offset_tokens", func(t *testing.T) {
+		tests := []struct {
+			fixedOffset *int
+			expected    string
+		}{
+			{fixedOffset: nil, expected: "287082"},
+			{fixedOffset: offset(11), expected: "287082"},
+			{fixedOffset: offset(0), expected: "717529"},
+			{fixedOffset: offset(16), expected: "782699"},
+		}
+
+		for _, tt := range tests {
+			options := config
+			options.FixedOffset = tt.fixedOffset
+
+			// Tokens are deterministic, so generate them twice.
+			for i := 0; i < 2; i++ {
+				token, err := Generate(options)
+				if err != nil {
+					t.Errorf("Generation should not return error(s)! Got: %v!", err)
+				}
+				if token != tt.expected {
+					t.Errorf("Expected token %s! Got: %s!", tt.expected, token)
+				}
+			}
+		}
+	})
+
+	t.Run("test_fixed_offset_verify", func(t *testing.T) {
+		options := config
+		options.FixedOffset = offset(0)
+
+		valid, err := Verify("717529", options.WithWindow(0))
+		if err != nil || !valid {
+			t.Errorf("Token of the fixed offset should be valid! Got: %v, %v!", valid, err)
+		}
+
+		valid, err = Verify("287082", options.WithWindow(0))
+		if err != nil || valid {
+			t.Errorf("Token of the dynamic offset should be invalid! Got: %v, %v!", valid, err)
+		}
+	})
+
+	t.Run("test_fixed_offset_out_of_range", func(t *testing.T) {
+		tests := []struct {
+			hasher      func() hash.Hash
+			fixedOffset int
+			valid       bool
+		}{
+			{hasher: sha1.New, fixedOffset: -1, valid: false},
+			{hasher: sha1.New, fixedOffset: 17, valid: false},
+			{hasher: sha512.New, fixedOffset: 17, valid: true},
+			{hasher: sha512.New, fixedOffset: 60, valid: true},
+			{hasher: sha512.New, fixedOffset: 61, valid: false},
+		}
+
+		for _, tt := range tests {
+			options := config
+			options.Hasher = tt.hasher
+			options.FixedOffset = offset(tt.fixedOffset)
+
+			_, err := Generate(options)
+			if tt.valid && err != nil {
+				t.Errorf("Offset %d should be valid! Got: %v!", tt.fixedOffset, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("Offset %d should return error(s)!", tt.fixedOffset)
+			}
+
+			_, err = Verify("000000", options.WithWindow(0))
+			if !tt.valid && err == nil {
+				t.Errorf("Verification with offset %d should return error(s)!", tt.fixedOffset)
+			}
+		}
+	})
+}
+
+func TestMinSecretBytes(t *testing.T) {
+	tests := []struct {
+		name           string
+		secret         string
+		minSecretBytes int
+		expectedError  bool
+	}{
+		{name: "test_short_secret_strict", secret: toBase32("ab"), minSecretBytes: 16, expectedError: true},
+		{name: "test_short_secret_not_strict", secret: toBase32("ab"), minSecretBytes: 0, expectedError: false},
+		{name: "test_exact_secret_strict", secret: toBase32("12345678901234567890"), minSecretBytes: RecommendedSecretBytes, expectedError: false},
+		{name: "test_long_secret_strict", secret: toBase32("The quick brown fox jumps over the lazy dog."), minSecretBytes: RecommendedSecretBytes, expectedError: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := Generate(TOTPConfig{Secret: tt.secret, Period: 30, Timestamp: 1629795965, Digits: 6, Hasher: sha1.New, MinSecretBytes: tt.minSecretBytes})
+			if tt.expectedError != errors.Is(err, ErrSecretTooShort) {
+				t.Errorf("Generation should return 'ErrSecretTooShort' only for short secrets! Got: %v!", err)
+			}
+
+			_, err = GenerateBytes([]byte("ab"), TOTPConfig{Period: 30, Timestamp: 1629795965, Digits: 6, Hasher: sha1.New, MinSecretBytes: tt.minSecretBytes})
+			if (tt.minSecretBytes > 2) != errors.Is(err, ErrSecretTooShort) {
+				t.Errorf("Generation with bytes should return 'ErrSecretTooShort' only for short secrets! Got: %v!", err)
+			}
+
+			if tt.expectedError {
+				token = "123456"
+			}
+			valid, err := Verify(token, TOTPValidateConfig{Secret: tt.secret, Period: 30, Timestamp: 1629795965, Digits: 6, Hasher: sha1.New, MinSecretBytes: tt.minSecretBytes})
+			if tt.expectedError != errors.Is(err, ErrSecretTooShort) {
+				t.Errorf("Verification should return 'ErrSecretTooShort' only for short secrets! Got: %v!", err)
+			}
+
+			if valid == tt.expectedError {
+				t.Errorf("Result of the verification is not as expected. Got: %v!", valid)
+			}
+		})
+	}
+
+	t.Run("test_short_previous_secret_strict", func(t *testing.T) {
+		_, err := Verify("123456", TOTPValidateConfig{Secret: toBase32("12345678901234567890"), PreviousSecret: toBase32("ab"), Period: 30, Timestamp: 1629795965, Digits: 6, Hasher: sha1.New, MinSecretBytes: 16})
+		if !errors.Is(err, ErrSecretTooShort) {
+			t.Errorf("Verification should return 'ErrSecretTooShort' for a short previous secret! Got: %v!", err)
+		}
+	})
+}
+
+func TestVerifyPeriods(t *testing.T) {
+	secret := toBase32("The quick brown fox jumps over the lazy dog.")
+	token, err := Generate(TOTPConfig{Secret: secret, Period: 60, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New})
+	if err != nil {
+		t.Fatalf("Generation should not return error(s)! Got: %v!", err)
+	}
+
+	tests := []struct {
+		name     string
+		period   int64
+		periods  []int64
+		expected bool
+	}{
+		{name: "test_both_periods", period: 30, periods: []int64{30, 60}, expected: true},
+		{name: "test_both_periods_reversed", period: 30, periods: []int64{60, 30}, expected: true},
+		{name: "test_other_period_only", period: 60, periods: []int64{30}, expected: false},
+		{name: "test_empty_periods_fallback", period: 60, periods: nil, expected: true},
+		{name: "test_empty_periods_other_period", period: 30, periods: nil, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, err := Verify(token, TOTPValidateConfig{Secret: secret, Period: tt.period, Periods: tt.periods, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New})
+			if err != nil {
+				t.Errorf("Verification should not return error(s)! Got: %v!", err)
+			}
+
+			if valid != tt.expected {
+				t.Errorf("Expected and actual results are not the same! Expected: %v, got: %v!", tt.expected, valid)
+			}
+		})
+	}
+
+	t.Run("test_non_positive_period", func(t *testing.T) {
+		_, err := Verify(token, TOTPValidateConfig{Secret: secret, Periods: []int64{30, 0}, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New})
+		if err == nil {
+			t.Errorf("Verification with a non-positive period should return an error! Got: %v!", err)
+		}
+	})
+}
+
+func TestBytesAgreeWithStrings(t *testing.T) {
+	secrets := []string{
+		"The quick brown fox jumps over the lazy dog.",
+		"12345678901234567890",
+		"kaede",
+	}
+
+	for _, secret := range secrets {
+		t.Run(secret, func(t *testing.T) {
+			config := TOTPConfig{Secret: toBase32(secret), Period: 30, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New}
+			expected, err := Generate(config)
+			if err != nil {
+				t.Fatalf("Generation should not return error(s)! Got: %v!", err)
+			}
+
+			token, err := GenerateBytes([]byte(secret), config)
+			if err != nil {
+				t.Fatalf("Generation of bytes should not return error(s)! Got: %v!", err)
+			}
+
+			if token != expected {
+				t.Errorf("Expected and actual tokens are not the same! Expected: %s, got: %s!", expected, token)
+			}
+
+			validation := TOTPValidateConfig{Period: 30, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New, Window: 1}
+			valid, err := VerifyBytes(expected, []byte(secret), validation)
+			if err != nil || !valid {
+				t.Errorf("Token should be valid with the secret bytes. Got: %v and %v!", valid, err)
+			}
+
+			valid, err = VerifyBytes(expected, []byte("another secret"), validation)
+			if err != nil || valid {
+				t.Errorf("Token should be invalid with another secret. Got: %v and %v!", valid, err)
+			}
+		})
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	benchmarks := []struct {
+		name   string
+		hasher func() hash.Hash
+		digits int
+	}{
+		{name: "sha1_6_digits", hasher: sha1.New, digits: 6},
+		{name: "sha256_8_digits", hasher: sha256.New, digits: 8},
+		{name: "sha512_10_digits", hasher: sha512.New, digits: 10},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			options := TOTPConfig{
+				Secret:    toBase32("The quick brown fox jumps over the lazy dog."),
+				Period:    30,
+				Timestamp: 1629795965,
+				Digits:    bm.digits,
+				Hasher:    bm.hasher,
+			}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Generate(options)
+			}
+		})
+	}
+}
+
+func BenchmarkPad(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pad(1234, 10)
+	}
+}
+
+func BenchmarkVerifyLargeWindow(b *testing.B) {
+	options := TOTPValidateConfig{
+		Secret:    toBase32("The quick brown fox jumps over the lazy dog."),
+		Period:    30,
+		Timestamp: 1629795965,
+		Digits:    10,
+		Hasher:    sha512.New,
+		Window:    10,
+	}
+
+	for i := 0; i < b.N; i++ {
+		Verify("1234567890", options)
+	}
+}
+
+// Utility function to count how many HMACs are computed with a hasher. HMAC creates two hashes for every digest.
+func countingHasher(hasher func() hash.Hash, count *int) func() hash.Hash {
+	return func() hash.Hash {
+		*count++
+		return hasher()
+	}
+}
+
+func TestVerifyMemoizedTokens(t *testing.T) {
+	sharedSecret := toBase32("The quick brown fox jumps over the lazy dog.")
+	count := 0
+
+	// Periods of 1 and 2 seconds at the 2nd second give the counters 2 and 1, so both windows of 1 cover [1, 3] and [0, 2].
+	options := TOTPValidateConfig{
+		Secret:    sharedSecret,
+		Periods:   []int64{1, 2, 1},
+		Timestamp: 2,
+		Digits:    6,
+		Hasher:    countingHasher(sha1.New, &count),
+		Window:    1,
+	}
+
+	valid, err := VerifyConstantTimeAll("000000", options)
+	if err != nil || valid {
+		t.Errorf("Token should be invalid. Got: %v and %v!", valid, err)
+	}
+	if count/2 != 4 {
+		t.Errorf("Expected each of the 4 counters to be hashed once! Got: %d HMACs!", count/2)
+	}
+
+	token, err := Generate(TOTPConfig{Secret: sharedSecret, Period: 2, Timestamp: 0, Digits: 6, Hasher: sha1.New})
+	if err != nil {
+		t.Errorf("Error should be null! Got: %v!", err)
+	}
+	valid, err = Verify(token, options)
+	if err != nil || !valid {
+		t.Errorf("Token of the 0th counter should be valid. Got: %v and %v!", valid, err)
+	}
+}
+
+func BenchmarkVerifyOverlappingPeriods(b *testing.B) {
+	count := 0
+	options := TOTPValidateConfig{
+		Secret:    toBase32("The quick brown fox jumps over the lazy dog."),
+		Periods:   []int64{30, 30, 30},
+		Timestamp: 1629795965,
+		Digits:    10,
+		Hasher:    countingHasher(sha512.New, &count),
+		Window:    10,
+	}
+
+	for i := 0; i < b.N; i++ {
+		VerifyConstantTimeAll("1234567890", options)
+	}
+	b.ReportMetric(float64(count/2)/float64(b.N), "hmacs/op")
+}
+
+func TestGenerateProvisioningURI(t *testing.T) {
+	sharedSecret := toBase32("kaedeKIMURA")
+
+	failureTests := []struct {
+		name   string
+		config ProvisioningConfig
+	}{
+		{
+			name:   "test_empty_issuer",
+			config: ProvisioningConfig{AccountName: "kaede", Secret: sharedSecret, Period: 30, Digits: 10, Algorithm: SHA512},
+		},
+		{
+			name:   "test_invalid_base32",
+			config: ProvisioningConfig{Issuer: "Fullstack OTP", AccountName: "kaede", Secret: "invalid_base32", Period: 30, Digits: 10, Algorithm: SHA512},
+		},
+	}
+
+	for _, tt := range failureTests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, err := GenerateProvisioningURI(tt.config)
+			if err == nil {
+				t.Errorf("Test-cases should return error(s)! Got: %v!", uri)
+			}
+		})
+	}
+
+	t.Run("test_provisioning_uri", func(t *testing.T) {
+		uri, err := GenerateProvisioningURI(ProvisioningConfig{
+			Issuer:      "Fullstack OTP",
+			AccountName: "kaede",
+			Secret:      sharedSecret,
+			Period:      30,
+			Digits:      10,
+			Algorithm:   SHA512,
+		})
+		if err != nil {
+			t.Errorf("Test-cases should not return error(s)! Got: %v!", err)
+		}
+
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			t.Errorf("URI should be parseable! Got: %v!", err)
+		}
+
+		query := parsed.Query()
+		if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/Fullstack OTP:kaede" {
+			t.Errorf("URI label is not the same! Got: %v!", uri)
+		}
+
+		if query.Get("secret") != "NNQWKZDFJNEU2VKSIE" || query.Get("issuer") != "Fullstack OTP" || query.Get("algorithm") != "SHA512" || query.Get("digits") != "10" || query.Get("period") != "30" {
+			t.Errorf("URI parameters are not the same! Got: %v!", uri)
+		}
+	})
+}
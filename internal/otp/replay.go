@@ -0,0 +1,80 @@
+package otp
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// ReplayGuard is used to make OTPs single-use. 'Seen' reports whether a token has been seen before in the scope,
+// and marks it as seen for the given duration. The scope identifies the secret, so one guard can serve every user.
+type ReplayGuard interface {
+	Seen(scope, token string, ttl time.Duration) (bool, error)
+}
+
+// MemoryReplayGuard is an in-memory implementation of 'ReplayGuard', safe for concurrent use.
+// Expired tokens are kept in a heap ordered by their expiration, so they are removed without scanning every token.
+type MemoryReplayGuard struct {
+	mu     sync.Mutex
+	tokens map[replayKey]time.Time
+	expiry replayHeap
+	now    func() time.Time
+}
+
+// A token that has been seen in a scope.
+type replayKey struct {
+	scope string
+	token string
+}
+
+// An entry of the expiration heap.
+type replayEntry struct {
+	key       replayKey
+	expiresAt time.Time
+}
+
+// Min-heap of the seen tokens, with the token that expires first on top.
+type replayHeap []replayEntry
+
+func (h replayHeap) Len() int            { return len(h) }
+func (h replayHeap) Less(i, j int) bool  { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h replayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *replayHeap) Push(x interface{}) { *h = append(*h, x.(replayEntry)) }
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// This function will create a new, empty in-memory replay guard.
+func NewMemoryReplayGuard() *MemoryReplayGuard {
+	return &MemoryReplayGuard{
+		tokens: make(map[replayKey]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Seen reports whether the token has been seen in the scope and has not expired yet. If not, the token is marked as seen.
+func (g *MemoryReplayGuard) Seen(scope, token string, ttl time.Duration) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Remove expired tokens, so the guard does not grow indefinitely.
+	now := g.now()
+	for g.expiry.Len() > 0 && !now.Before(g.expiry[0].expiresAt) {
+		entry := heap.Pop(&g.expiry).(replayEntry)
+		if expiresAt, ok := g.tokens[entry.key]; ok && expiresAt.Equal(entry.expiresAt) {
+			delete(g.tokens, entry.key)
+		}
+	}
+
+	key := replayKey{scope: scope, token: token}
+	if _, ok := g.tokens[key]; ok {
+		return true, nil
+	}
+
+	g.tokens[key] = now.Add(ttl)
+	heap.Push(&g.expiry, replayEntry{key: key, expiresAt: now.Add(ttl)})
+	return false, nil
+}
@@ -0,0 +1,84 @@
+package otp
+
+import (
+	"crypto/sha512"
+	"testing"
+	"time"
+)
+
+func TestMemoryReplayGuard(t *testing.T) {
+	current := time.Unix(1629795965, 0)
+	guard := NewMemoryReplayGuard()
+	guard.now = func() time.Time { return current }
+
+	seen, err := guard.Seen("kaede", "123456", 90*time.Second)
+	if err != nil || seen {
+		t.Errorf("Token should not have been seen the first time. Got: %v and %v!", seen, err)
+	}
+
+	seen, err = guard.Seen("kaede", "123456", 90*time.Second)
+	if err != nil || !seen {
+		t.Errorf("Token should have been seen the second time. Got: %v and %v!", seen, err)
+	}
+
+	seen, err = guard.Seen("kaede", "654321", 90*time.Second)
+	if err != nil || seen {
+		t.Errorf("Another token should not have been seen. Got: %v and %v!", seen, err)
+	}
+
+	seen, err = guard.Seen("kimura", "123456", 90*time.Second)
+	if err != nil || seen {
+		t.Errorf("Token of another scope should not have been seen. Got: %v and %v!", seen, err)
+	}
+
+	current = current.Add(90 * time.Second)
+	seen, err = guard.Seen("kaede", "123456", 90*time.Second)
+	if err != nil || seen {
+		t.Errorf("Token should be forgotten after it expires. Got: %v and %v!", seen, err)
+	}
+
+	// Only the token that has just been marked again is left, as every other token has expired.
+	if len(guard.tokens) != 1 || guard.expiry.Len() != 1 {
+		t.Errorf("Expired tokens should be removed. Got: %d tokens and %d heap entries!", len(guard.tokens), guard.expiry.Len())
+	}
+}
+
+func TestVerifyReplayGuard(t *testing.T) {
+	secret := toBase32("The quick brown fox jumps over the lazy dog.")
+	token, err := Generate(TOTPConfig{Secret: secret, Period: 30, Timestamp: 1629795965, Digits: 10, Hasher: sha512.New})
+	if err != nil {
+		t.Fatalf("Generation should not return error(s)! Got: %v!", err)
+	}
+
+	options := TOTPValidateConfig{
+		Secret:      secret,
+		Period:      30,
+		Timestamp:   1629795965,
+		Digits:      10,
+		Hasher:      sha512.New,
+		Window:      1,
+		ReplayGuard: NewMemoryReplayGuard(),
+	}
+
+	valid, err := Verify(token, options)
+	if err != nil || !valid {
+		t.Errorf("Token should be valid the first time. Got: %v and %v!", valid, err)
+	}
+
+	valid, err = Verify(token, options)
+	if err != nil || valid {
+		t.Errorf("Token should be invalid when replayed. Got: %v and %v!", valid, err)
+	}
+
+	// Tokens are scoped by the current secret, so one guard can serve every user.
+	scope := replayScope([]byte("The quick brown fox jumps over the lazy dog."))
+	if _, ok := options.ReplayGuard.(*MemoryReplayGuard).tokens[replayKey{scope: scope, token: token}]; !ok {
+		t.Errorf("Token should be marked in the scope of the secret!")
+	}
+
+	// Invalid tokens are not marked, so they do not affect the guard.
+	valid, err = Verify("0000000000", options)
+	if err != nil || valid {
+		t.Errorf("Wrong token should be invalid. Got: %v and %v!", valid, err)
+	}
+}
@@ -0,0 +1,709 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of 'Store', for tests and single-node deployments.
+// Expired entries are never returned, and are removed periodically by a background sweeper.
+type MemoryStore struct {
+	mu                sync.Mutex
+	sessionExpiration time.Duration
+	sessions          map[string]memorySession
+	sequence          uint64
+	blacklist         map[string]time.Time
+	backupCodes       map[string]map[string]bool
+	attempts          map[string]memoryCounter
+	failures          map[string]memoryCounter
+	locks             map[string]time.Time
+	idempotency       map[string]memoryValue
+	audit             map[string][]AuditEvent
+	stop              chan struct{}
+	done              chan struct{}
+	closeOnce         sync.Once
+}
+
+// A session of the in-memory store, with the time it expires.
+type memorySession struct {
+	info      SessionInfo
+	data      []byte
+	expiresAt time.Time
+	sequence  uint64
+}
+
+// A value of the in-memory store, with the time it expires.
+type memoryValue struct {
+	value     string
+	expiresAt time.Time
+}
+
+// A counter of the in-memory store, with the time it is reset.
+type memoryCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a new in-memory store. Expired entries are swept every 'sweepInterval',
+// and the sweeper is stopped with 'Close'. A non-positive interval disables the sweeper.
+func NewMemoryStore(sessionExpiration, sweepInterval time.Duration) *MemoryStore {
+	store := &MemoryStore{
+		sessionExpiration: sessionExpiration,
+		sessions:          make(map[string]memorySession),
+		blacklist:         make(map[string]time.Time),
+		backupCodes:       make(map[string]map[string]bool),
+		attempts:          make(map[string]memoryCounter),
+		failures:          make(map[string]memoryCounter),
+		locks:             make(map[string]time.Time),
+		idempotency:       make(map[string]memoryValue),
+		audit:             make(map[string][]AuditEvent),
+		stop:              make(chan struct{}),
+	}
+
+	if sweepInterval > 0 {
+		store.done = make(chan struct{})
+		go store.sweeper(sweepInterval)
+	}
+
+	return store
+}
+
+// DefaultSessionExpiration is the expiration of the sessions of a store created with 'NewInMemory'.
+const DefaultSessionExpiration = 15 * time.Minute
+
+// NewInMemory creates a new in-memory store with the default session expiration, and a sweeper that removes
+// the expired sessions and OTPs every 'sweepInterval'. 'Close' has to be called to stop the sweeper.
+func NewInMemory(sweepInterval time.Duration) *MemoryStore {
+	return NewMemoryStore(DefaultSessionExpiration, sweepInterval)
+}
+
+// Utility function to run the sweeper until the store is closed.
+func (m *MemoryStore) sweeper(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sweep()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Utility function to remove all of the expired entries.
+func (m *MemoryStore) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := now()
+	for sessionID, session := range m.sessions {
+		if !current.Before(session.expiresAt) {
+			delete(m.sessions, sessionID)
+		}
+	}
+
+	for key, expiresAt := range m.blacklist {
+		if !current.Before(expiresAt) {
+			delete(m.blacklist, key)
+		}
+	}
+
+	for _, counters := range []map[string]memoryCounter{m.attempts, m.failures} {
+		for userID, counter := range counters {
+			if !current.Before(counter.expiresAt) {
+				delete(counters, userID)
+			}
+		}
+	}
+
+	for userID, expiresAt := range m.locks {
+		if !current.Before(expiresAt) {
+			delete(m.locks, userID)
+		}
+	}
+
+	for key, value := range m.idempotency {
+		if !current.Before(value.expiresAt) {
+			delete(m.idempotency, key)
+		}
+	}
+}
+
+// Utility function to get a session that has not expired yet.
+func (m *MemoryStore) session(sessionID string) (memorySession, bool) {
+	session, ok := m.sessions[sessionID]
+	if !ok || !now().Before(session.expiresAt) {
+		return memorySession{}, false
+	}
+
+	return session, true
+}
+
+// Set is to set a new session ID that is connected with the user ID, with the default expiration.
+func (m *MemoryStore) Set(ctx context.Context, sessionID, userID string, metadata Metadata) error {
+	return m.SetWithTTL(ctx, sessionID, userID, metadata, m.sessionExpiration)
+}
+
+// Create is to set a new session like 'Set', but only if the session ID is not used yet.
+// Returns 'ErrSessionExists' on a collision.
+func (m *MemoryStore) Create(ctx context.Context, sessionID, userID string, metadata Metadata) error {
+	return m.CreateWithLimit(ctx, sessionID, userID, metadata, 0)
+}
+
+// CreateWithLimit is to create a new session like 'Create', while evicting the oldest sessions of the user so
+// that the user has at most 'maxSessions' sessions, including the new one. Zero means that there is no limit.
+func (m *MemoryStore) CreateWithLimit(ctx context.Context, sessionID, userID string, metadata Metadata, maxSessions int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.sessionExpiration <= 0 {
+		return ErrInvalidTTL
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.session(sessionID); exists {
+		return ErrSessionExists
+	}
+
+	if maxSessions > 0 {
+		var live []memorySession
+		for id := range m.sessions {
+			if session, ok := m.session(id); ok && session.info.UserID == userID {
+				live = append(live, session)
+			}
+		}
+
+		sort.Slice(live, func(i, j int) bool { return live[i].sequence < live[j].sequence })
+		for i := 0; i < len(live)-maxSessions+1; i++ {
+			delete(m.sessions, live[i].info.SessionID)
+		}
+	}
+
+	m.setSession(sessionID, userID, metadata, m.sessionExpiration)
+	return nil
+}
+
+// SetWithTTL is to set a new session ID that is connected with the user ID, with a custom expiration.
+func (m *MemoryStore) SetWithTTL(ctx context.Context, sessionID, userID string, metadata Metadata, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setSession(sessionID, userID, metadata, ttl)
+	return nil
+}
+
+// Utility function to set a session. The lock has to be held by the caller.
+func (m *MemoryStore) setSession(sessionID, userID string, metadata Metadata, ttl time.Duration) {
+	current := now()
+	m.sessions[sessionID] = memorySession{
+		info: SessionInfo{
+			SessionID: sessionID,
+			UserID:    userID,
+			CreatedAt: time.Unix(current.Unix(), 0).UTC(),
+			UserAgent: metadata.UserAgent,
+			IP:        metadata.IP,
+			Role:      metadata.Role,
+		},
+		expiresAt: current.Add(ttl),
+		sequence:  m.sequence,
+	}
+	m.sequence++
+}
+
+// Get is to get the user ID that is associated with the session ID.
+func (m *MemoryStore) Get(ctx context.Context, sessionID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, _ := m.session(sessionID)
+	return session.info.UserID, nil
+}
+
+// GetMany is to get the user IDs of many session IDs at once. Missing sessions are omitted.
+func (m *MemoryStore) GetMany(ctx context.Context, sessionIDs []string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make(map[string]string, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		if session, ok := m.session(sessionID); ok {
+			users[sessionID] = session.info.UserID
+		}
+	}
+
+	return users, nil
+}
+
+// SetJSON is to store a JSON-serializable value in an existing session. Returns 'ErrSessionNotFound' if the session does not exist.
+func (m *MemoryStore) SetJSON(ctx context.Context, sessionID string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.session(sessionID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	session.data = data
+	m.sessions[sessionID] = session
+	return nil
+}
+
+// GetJSON is to read the value stored by 'SetJSON' into 'dst'.
+// Returns 'ErrSessionNotFound' if the session does not exist, or if it does not have a value.
+func (m *MemoryStore) GetJSON(ctx context.Context, sessionID string, dst interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	session, ok := m.session(sessionID)
+	m.mu.Unlock()
+	if !ok || session.data == nil {
+		return ErrSessionNotFound
+	}
+
+	return json.Unmarshal(session.data, dst)
+}
+
+// Role is to get the role of the user that is associated with the session ID.
+func (m *MemoryStore) Role(ctx context.Context, sessionID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, _ := m.session(sessionID)
+	return session.info.Role, nil
+}
+
+// Info is to get a session and the metadata of when and where it was created.
+// Returns an empty session info if the session does not exist.
+func (m *MemoryStore) Info(ctx context.Context, sessionID string) (SessionInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return SessionInfo{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, _ := m.session(sessionID)
+	return session.info, nil
+}
+
+// GetTTL is to get the remaining time until a session expires. Returns zero if the session does not exist.
+func (m *MemoryStore) GetTTL(ctx context.Context, sessionID string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.session(sessionID)
+	if !ok {
+		return 0, nil
+	}
+
+	return session.expiresAt.Sub(now()), nil
+}
+
+// Touch is to get the user ID that is associated with the session ID, and refresh the expiration of the session.
+func (m *MemoryStore) Touch(ctx context.Context, sessionID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.session(sessionID)
+	if !ok {
+		return "", nil
+	}
+
+	session.expiresAt = now().Add(m.sessionExpiration)
+	m.sessions[sessionID] = session
+
+	return session.info.UserID, nil
+}
+
+// Exists is to check whether the session ID is present.
+func (m *MemoryStore) Exists(ctx context.Context, sessionID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.session(sessionID)
+	return ok, nil
+}
+
+// Delete is to remove a session.
+func (m *MemoryStore) Delete(ctx context.Context, sessionID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+// All is to get all of the currently available sessions, ordered by their session IDs.
+func (m *MemoryStore) All(ctx context.Context) ([]SessionInfo, error) {
+	return m.filter(ctx, func(SessionInfo) bool { return true })
+}
+
+// AllForUser is to get all of the currently available sessions of a single user, oldest first, just like 'Service'.
+// Sessions created in the same second are ordered by their session IDs, as they are in the index of the Redis.
+func (m *MemoryStore) AllForUser(ctx context.Context, userID string) ([]SessionInfo, error) {
+	sessions, err := m.filter(ctx, func(info SessionInfo) bool { return info.UserID == userID })
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+// CountForUser is to count the currently available sessions of a single user.
+func (m *MemoryStore) CountForUser(ctx context.Context, userID string) (int, error) {
+	sessions, err := m.AllForUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sessions), nil
+}
+
+// Utility function to get the available sessions that satisfy a predicate.
+func (m *MemoryStore) filter(ctx context.Context, predicate func(SessionInfo) bool) ([]SessionInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []SessionInfo
+	for sessionID := range m.sessions {
+		if session, ok := m.session(sessionID); ok && predicate(session.info) {
+			sessions = append(sessions, session.info)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].SessionID < sessions[j].SessionID })
+	return sessions, nil
+}
+
+// BlacklistOTP is used to blacklist an OTP of a user, so it cannot be used again.
+func (m *MemoryStore) BlacklistOTP(ctx context.Context, userID, otp string, period, skew uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blacklist[fmt.Sprintf("%s:%s", userID, otp)] = now().Add(BlacklistTTL(period, skew))
+	return nil
+}
+
+// CheckBlacklistOTP is used to check whether an OTP of a user has been blacklisted.
+func (m *MemoryStore) CheckBlacklistOTP(ctx context.Context, userID, otp string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt, ok := m.blacklist[fmt.Sprintf("%s:%s", userID, otp)]
+	return ok && now().Before(expiresAt), nil
+}
+
+// RememberOTP is used to issue the same OTP for repeated requests with the same idempotency key.
+// Returns the stored OTP if there is one, or stores and returns 'otp' otherwise.
+func (m *MemoryStore) RememberOTP(ctx context.Context, userID, idempotencyKey, otp string, ttl time.Duration) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("%s:%s", userID, idempotencyKey)
+	if previous, ok := m.idempotency[key]; ok && now().Before(previous.expiresAt) {
+		return previous.value, true, nil
+	}
+
+	m.idempotency[key] = memoryValue{value: otp, expiresAt: now().Add(ttl)}
+	return otp, false, nil
+}
+
+// ForgetOTP is used to forget the OTP of an idempotency key, so the next request with the same key is not a replay.
+func (m *MemoryStore) ForgetOTP(ctx context.Context, userID, idempotencyKey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotency, fmt.Sprintf("%s:%s", userID, idempotencyKey))
+	return nil
+}
+
+// ClaimOTP is used to check and blacklist an OTP of a user at once. Returns false if the OTP has been used before.
+func (m *MemoryStore) ClaimOTP(ctx context.Context, userID, otp string, period, skew uint) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("%s:%s", userID, otp)
+	if expiresAt, ok := m.blacklist[key]; ok && now().Before(expiresAt) {
+		return false, nil
+	}
+
+	m.blacklist[key] = now().Add(BlacklistTTL(period, skew))
+	return true, nil
+}
+
+// StoreBackupCodes is used to store the hashes of the backup codes of a user, replacing the previous ones.
+func (m *MemoryStore) StoreBackupCodes(ctx context.Context, userID string, hashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(hashes) == 0 {
+		delete(m.backupCodes, userID)
+		return nil
+	}
+
+	codes := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		codes[hash] = true
+	}
+	m.backupCodes[userID] = codes
+
+	return nil
+}
+
+// ConsumeBackupCode is used to check if a backup code belongs to the user, and remove it if it does.
+func (m *MemoryStore) ConsumeBackupCode(ctx context.Context, userID, code string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := HashBackupCode(code)
+	if !m.backupCodes[userID][hash] {
+		return false, nil
+	}
+
+	delete(m.backupCodes[userID], hash)
+	return true, nil
+}
+
+// IncrementAttempts is used to count the verification attempts of a user in a fixed window.
+func (m *MemoryStore) IncrementAttempts(ctx context.Context, userID string, window time.Duration) (int64, error) {
+	return m.increment(ctx, m.attempts, userID, window)
+}
+
+// IncrementFailures is used to count the failed verifications of a user in a fixed window.
+func (m *MemoryStore) IncrementFailures(ctx context.Context, userID string, window time.Duration) (int64, error) {
+	return m.increment(ctx, m.failures, userID, window)
+}
+
+// ResetFailures is used to forget the failed verifications of a user.
+func (m *MemoryStore) ResetFailures(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, userID)
+	return nil
+}
+
+// Utility function to increment a counter that expires after a window, starting from the first increment.
+func (m *MemoryStore) increment(ctx context.Context, counters map[string]memoryCounter, userID string, window time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := now()
+	counter, ok := counters[userID]
+	if !ok || !current.Before(counter.expiresAt) {
+		counter = memoryCounter{expiresAt: current.Add(window)}
+	}
+
+	counter.count++
+	counters[userID] = counter
+
+	return counter.count, nil
+}
+
+// LockAccount is used to lock the account of a user for a duration. Non-positive durations are rejected, just like 'Service'.
+func (m *MemoryStore) LockAccount(ctx context.Context, userID string, duration time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return ErrInvalidDuration
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.locks[userID] = now().Add(duration)
+	return nil
+}
+
+// IsLocked is used to check whether the account of a user is locked.
+func (m *MemoryStore) IsLocked(ctx context.Context, userID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt, ok := m.locks[userID]
+	return ok && now().Before(expiresAt), nil
+}
+
+// AppendAudit is used to record an authentication event of a user, keeping only the most recent 'MaxAuditEvents'.
+func (m *MemoryStore) AppendAudit(ctx context.Context, userID string, event AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = now()
+	}
+
+	events := append([]AuditEvent{event}, m.audit[userID]...)
+	if len(events) > MaxAuditEvents {
+		events = events[:MaxAuditEvents]
+	}
+	m.audit[userID] = events
+
+	return nil
+}
+
+// GetAudit is used to get the 'n' most recent authentication events of a user, newest first.
+func (m *MemoryStore) GetAudit(ctx context.Context, userID string, n int) ([]AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := m.audit[userID]
+	if n < 0 {
+		n = 0
+	}
+	if n > len(events) {
+		n = len(events)
+	}
+
+	return append([]AuditEvent{}, events[:n]...), nil
+}
+
+// AttemptsTTL is used to get the remaining time until the verification attempts of a user are reset.
+func (m *MemoryStore) AttemptsTTL(ctx context.Context, userID string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter, ok := m.attempts[userID]
+	if !ok {
+		return 0, nil
+	}
+
+	ttl := counter.expiresAt.Sub(now())
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// Ping always succeeds, as the store is in the memory.
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close stops the sweeper, and waits for it to exit. It is safe to call more than once.
+func (m *MemoryStore) Close() error {
+	m.closeOnce.Do(func() { close(m.stop) })
+
+	// Wait for the sweeper to exit, so no goroutine is left behind once the store is closed.
+	if m.done != nil {
+		<-m.done
+	}
+
+	return nil
+}
@@ -0,0 +1,423 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+// Mocks the current time, and returns a function to move it forward.
+func mockClock(t *testing.T) func(time.Duration) {
+	current := time.Unix(1640995200, 0)
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = time.Now })
+
+	return func(d time.Duration) { current = current.Add(d) }
+}
+
+func TestMemoryStoreSessions(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	assert.Nil(t, store.Set(ctx, "1", "randomUser", metadata))
+	assert.Nil(t, store.SetWithTTL(ctx, "2", "anotherUser", metadata, time.Minute))
+	assert.Equal(t, ErrInvalidTTL, store.SetWithTTL(ctx, "3", "anotherUser", metadata, 0))
+
+	t.Run("test_get_session", func(t *testing.T) {
+		userID, err := store.Get(ctx, "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "randomUser", userID)
+
+		role, err := store.Role(ctx, "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "user", role)
+
+		info, err := store.Info(ctx, "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "randomUser", info.UserID)
+		assert.Equal(t, "Mozilla/5.0", info.UserAgent)
+
+		ttl, err := store.GetTTL(ctx, "2")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute, ttl)
+	})
+
+	t.Run("test_create_session", func(t *testing.T) {
+		assert.Equal(t, ErrSessionExists, store.Create(ctx, "1", "anotherUser", metadata))
+		assert.Nil(t, store.Create(ctx, "3", "anotherUser", metadata))
+
+		userID, err := store.Get(ctx, "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "randomUser", userID)
+		assert.Nil(t, store.Delete(ctx, "3"))
+	})
+
+	t.Run("test_get_many_sessions", func(t *testing.T) {
+		users, err := store.GetMany(ctx, []string{"1", "missing", "2"})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"1": "randomUser", "2": "anotherUser"}, users)
+	})
+
+	t.Run("test_all_sessions", func(t *testing.T) {
+		createdAt := time.Unix(1640995200, 0).UTC()
+		sessions, err := store.All(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, []SessionInfo{
+			{SessionID: "1", UserID: "randomUser", CreatedAt: createdAt, UserAgent: "Mozilla/5.0", IP: "127.0.0.1", Role: "user"},
+			{SessionID: "2", UserID: "anotherUser", CreatedAt: createdAt, UserAgent: "Mozilla/5.0", IP: "127.0.0.1", Role: "user"},
+		}, sessions)
+
+		sessions, err = store.AllForUser(ctx, "anotherUser")
+		assert.Nil(t, err)
+		assert.Len(t, sessions, 1)
+
+		count, err := store.CountForUser(ctx, "randomUser")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("test_session_expires", func(t *testing.T) {
+		advance(time.Minute)
+
+		exists, err := store.Exists(ctx, "2")
+		assert.Nil(t, err)
+		assert.False(t, exists)
+
+		userID, err := store.Get(ctx, "2")
+		assert.Nil(t, err)
+		assert.Equal(t, "", userID)
+
+		ttl, err := store.GetTTL(ctx, "2")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), ttl)
+	})
+
+	t.Run("test_touch_extends_session", func(t *testing.T) {
+		advance(10 * time.Minute)
+		userID, err := store.Touch(ctx, "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "randomUser", userID)
+
+		advance(10 * time.Minute)
+		exists, err := store.Exists(ctx, "1")
+		assert.Nil(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("test_delete_session", func(t *testing.T) {
+		assert.Nil(t, store.Delete(ctx, "1"))
+
+		exists, err := store.Exists(ctx, "1")
+		assert.Nil(t, err)
+		assert.False(t, exists)
+	})
+}
+
+func TestMemoryStoreCreateWithLimit(t *testing.T) {
+	mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	assert.Nil(t, store.Create(ctx, "1", "kaede", metadata))
+	assert.Nil(t, store.Create(ctx, "2", "kaede", metadata))
+	assert.Nil(t, store.Create(ctx, "3", "anotherUser", metadata))
+	assert.Equal(t, ErrSessionExists, store.CreateWithLimit(ctx, "2", "kaede", metadata, 1))
+	assert.Nil(t, store.CreateWithLimit(ctx, "4", "kaede", metadata, 2))
+
+	sessions, err := store.AllForUser(ctx, "kaede")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2", "4"}, []string{sessions[0].SessionID, sessions[1].SessionID})
+
+	count, err := store.CountForUser(ctx, "anotherUser")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	for _, ttl := range []time.Duration{0, -time.Minute} {
+		invalidStore := NewMemoryStore(ttl, 0)
+		assert.Equal(t, ErrInvalidTTL, invalidStore.Create(ctx, "1", "kaede", metadata))
+		assert.Equal(t, ErrInvalidTTL, invalidStore.CreateWithLimit(ctx, "1", "kaede", metadata, 1))
+		assert.Equal(t, ErrInvalidTTL, invalidStore.Set(ctx, "1", "kaede", metadata))
+
+		exists, err := invalidStore.Exists(ctx, "1")
+		assert.Nil(t, err)
+		assert.False(t, exists)
+	}
+}
+
+func TestMemoryStoreJSON(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+	value := map[string]string{"device": "phone"}
+
+	assert.Nil(t, store.Set(ctx, "1", "randomUser", metadata))
+	assert.Equal(t, ErrSessionNotFound, store.GetJSON(ctx, "1", &map[string]string{}))
+	assert.Nil(t, store.SetJSON(ctx, "1", value))
+
+	res := map[string]string{}
+	assert.Nil(t, store.GetJSON(ctx, "1", &res))
+	assert.Equal(t, value, res)
+
+	assert.Equal(t, ErrSessionNotFound, store.SetJSON(ctx, "missing", value))
+
+	advance(sessionExpiration)
+	assert.Equal(t, ErrSessionNotFound, store.GetJSON(ctx, "1", &res))
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	assert.Nil(t, store.Set(ctx, "1", "randomUser", metadata))
+	assert.Nil(t, store.BlacklistOTP(ctx, "kaede", "123", 30, 1))
+	_, err := store.IncrementAttempts(ctx, "kaede", time.Minute)
+	assert.Nil(t, err)
+
+	store.sweep()
+	assert.Len(t, store.sessions, 1)
+	assert.Len(t, store.blacklist, 1)
+	assert.Len(t, store.attempts, 1)
+
+	advance(sessionExpiration)
+	store.sweep()
+	assert.Empty(t, store.sessions)
+	assert.Empty(t, store.blacklist)
+	assert.Empty(t, store.attempts)
+}
+
+func TestMemoryStoreSweeper(t *testing.T) {
+	store := NewMemoryStore(time.Millisecond, time.Millisecond)
+	defer store.Close()
+
+	assert.Nil(t, store.Set(context.Background(), "1", "randomUser", metadata))
+	assert.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.sessions) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestNewInMemory(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// The sweeper reads the clock in another goroutine, so the clock is moved forward atomically.
+	var offset atomic.Int64
+	now = func() time.Time { return time.Unix(1640995200, 0).Add(time.Duration(offset.Load())) }
+	t.Cleanup(func() { now = time.Now })
+
+	store := NewInMemory(time.Millisecond)
+	ctx := context.Background()
+	assert.Nil(t, store.Set(ctx, "1", "randomUser", metadata))
+	assert.Nil(t, store.BlacklistOTP(ctx, "kaede", "123", 30, 1))
+
+	offset.Store(int64(BlacklistTTL(30, 1)))
+	assert.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.blacklist) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	// Sessions last longer than the blacklisted OTPs, so they are not swept yet.
+	exists, err := store.Exists(ctx, "1")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	assert.Nil(t, store.Close())
+	assert.Nil(t, store.Close())
+}
+
+func TestMemoryStoreBlacklist(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	assert.Nil(t, store.BlacklistOTP(ctx, "kaede", "123", 30, 1))
+
+	blacklisted, err := store.CheckBlacklistOTP(ctx, "kaede", "123")
+	assert.Nil(t, err)
+	assert.True(t, blacklisted)
+
+	blacklisted, err = store.CheckBlacklistOTP(ctx, "kimura", "123")
+	assert.Nil(t, err)
+	assert.False(t, blacklisted)
+
+	advance(BlacklistTTL(30, 1))
+	blacklisted, err = store.CheckBlacklistOTP(ctx, "kaede", "123")
+	assert.Nil(t, err)
+	assert.False(t, blacklisted)
+}
+
+func TestMemoryStoreClaimOTP(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	claimed, err := store.ClaimOTP(ctx, "kaede", "123", 30, 1)
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = store.ClaimOTP(ctx, "kaede", "123", 30, 1)
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+
+	blacklisted, err := store.CheckBlacklistOTP(ctx, "kaede", "123")
+	assert.Nil(t, err)
+	assert.True(t, blacklisted)
+
+	advance(BlacklistTTL(30, 1))
+	claimed, err = store.ClaimOTP(ctx, "kaede", "123", 30, 1)
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+}
+
+func TestMemoryStoreRememberOTP(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	otp, replayed, err := store.RememberOTP(ctx, "kaede", "key", "123", 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "123", otp)
+	assert.False(t, replayed)
+
+	otp, replayed, err = store.RememberOTP(ctx, "kaede", "key", "456", 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "123", otp)
+	assert.True(t, replayed)
+
+	// Keys are scoped to the user.
+	otp, replayed, err = store.RememberOTP(ctx, "nanami", "key", "456", 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "456", otp)
+	assert.False(t, replayed)
+
+	advance(30 * time.Second)
+	otp, replayed, err = store.RememberOTP(ctx, "kaede", "key", "789", 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "789", otp)
+	assert.False(t, replayed)
+
+	// Forgotten OTPs are not replayed.
+	assert.Nil(t, store.ForgetOTP(ctx, "kaede", "key"))
+	otp, replayed, err = store.RememberOTP(ctx, "kaede", "key", "000", 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "000", otp)
+	assert.False(t, replayed)
+}
+
+func TestMemoryStoreBackupCodes(t *testing.T) {
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	assert.Nil(t, store.StoreBackupCodes(ctx, "kaede", []string{HashBackupCode("code1"), HashBackupCode("code2")}))
+
+	consumed, err := store.ConsumeBackupCode(ctx, "kaede", "code1")
+	assert.Nil(t, err)
+	assert.True(t, consumed)
+
+	consumed, err = store.ConsumeBackupCode(ctx, "kaede", "code1")
+	assert.Nil(t, err)
+	assert.False(t, consumed)
+
+	assert.Nil(t, store.StoreBackupCodes(ctx, "kaede", nil))
+	consumed, err = store.ConsumeBackupCode(ctx, "kaede", "code2")
+	assert.Nil(t, err)
+	assert.False(t, consumed)
+}
+
+func TestMemoryStoreAttempts(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		attempts, err := store.IncrementAttempts(ctx, "kaede", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, i, attempts)
+	}
+
+	advance(20 * time.Second)
+	ttl, err := store.AttemptsTTL(ctx, "kaede")
+	assert.Nil(t, err)
+	assert.Equal(t, 40*time.Second, ttl)
+
+	advance(40 * time.Second)
+	attempts, err := store.IncrementAttempts(ctx, "kaede", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), attempts)
+}
+
+func TestMemoryStoreCancelledContext(t *testing.T) {
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, store.Set(ctx, "1", "randomUser", metadata), context.Canceled)
+	_, err := store.All(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, store.Ping(ctx), context.Canceled)
+}
+
+func TestMemoryStoreLockout(t *testing.T) {
+	advance := mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	failures, err := store.IncrementFailures(ctx, "kaede", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), failures)
+
+	assert.Nil(t, store.ResetFailures(ctx, "kaede"))
+	failures, err = store.IncrementFailures(ctx, "kaede", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), failures)
+
+	assert.Nil(t, store.LockAccount(ctx, "kaede", 5*time.Minute))
+	locked, err := store.IsLocked(ctx, "kaede")
+	assert.Nil(t, err)
+	assert.True(t, locked)
+
+	advance(5 * time.Minute)
+	locked, err = store.IsLocked(ctx, "kaede")
+	assert.Nil(t, err)
+	assert.False(t, locked)
+}
+
+func TestMemoryStoreAudit(t *testing.T) {
+	mockClock(t)
+	store := NewMemoryStore(sessionExpiration, 0)
+	ctx := context.Background()
+
+	for i := 0; i < MaxAuditEvents+5; i++ {
+		assert.Nil(t, store.AppendAudit(ctx, "kaede", AuditEvent{Type: AuditTypeLogin, IP: fmt.Sprint(i), Outcome: AuditOutcomeSuccess}))
+	}
+	assert.Nil(t, store.AppendAudit(ctx, "anotherUser", AuditEvent{Type: AuditTypeLogin, Outcome: AuditOutcomeFailure}))
+
+	t.Run("test_get_recent_audit", func(t *testing.T) {
+		events, err := store.GetAudit(ctx, "kaede", 2)
+		assert.Nil(t, err)
+		assert.Len(t, events, 2)
+		assert.Equal(t, fmt.Sprint(MaxAuditEvents+4), events[0].IP)
+		assert.Equal(t, now(), events[0].Timestamp)
+	})
+
+	t.Run("test_get_trimmed_audit", func(t *testing.T) {
+		events, err := store.GetAudit(ctx, "kaede", MaxAuditEvents*2)
+		assert.Nil(t, err)
+		assert.Len(t, events, MaxAuditEvents)
+		assert.Equal(t, "5", events[len(events)-1].IP)
+	})
+
+	t.Run("test_get_missing_audit", func(t *testing.T) {
+		events, err := store.GetAudit(ctx, "missing", 10)
+		assert.Nil(t, err)
+		assert.Empty(t, events)
+	})
+}
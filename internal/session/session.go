@@ -3,42 +3,174 @@ package session
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-// Everything on this codebase will use the background context.
-var ctx = context.Background()
+// Store represents the operations of a session storage. 'Service' stores everything in the Redis,
+// while 'MemoryStore' keeps everything in the memory of a single process.
+type Store interface {
+	Set(ctx context.Context, sessionID, userID string, metadata Metadata) error
+	Create(ctx context.Context, sessionID, userID string, metadata Metadata) error
+	CreateWithLimit(ctx context.Context, sessionID, userID string, metadata Metadata, maxSessions int) error
+	SetWithTTL(ctx context.Context, sessionID, userID string, metadata Metadata, ttl time.Duration) error
+	Get(ctx context.Context, sessionID string) (string, error)
+	GetMany(ctx context.Context, sessionIDs []string) (map[string]string, error)
+	SetJSON(ctx context.Context, sessionID string, v interface{}) error
+	GetJSON(ctx context.Context, sessionID string, dst interface{}) error
+	Role(ctx context.Context, sessionID string) (string, error)
+	Info(ctx context.Context, sessionID string) (SessionInfo, error)
+	GetTTL(ctx context.Context, sessionID string) (time.Duration, error)
+	Touch(ctx context.Context, sessionID string) (string, error)
+	Exists(ctx context.Context, sessionID string) (bool, error)
+	Delete(ctx context.Context, sessionID string) error
+	All(ctx context.Context) ([]SessionInfo, error)
+	AllForUser(ctx context.Context, userID string) ([]SessionInfo, error)
+	CountForUser(ctx context.Context, userID string) (int, error)
+	BlacklistOTP(ctx context.Context, userID, otp string, period, skew uint) error
+	CheckBlacklistOTP(ctx context.Context, userID, otp string) (bool, error)
+	ClaimOTP(ctx context.Context, userID, otp string, period, skew uint) (bool, error)
+	RememberOTP(ctx context.Context, userID, idempotencyKey, otp string, ttl time.Duration) (string, bool, error)
+	ForgetOTP(ctx context.Context, userID, idempotencyKey string) error
+	StoreBackupCodes(ctx context.Context, userID string, hashes []string) error
+	ConsumeBackupCode(ctx context.Context, userID, code string) (bool, error)
+	IncrementAttempts(ctx context.Context, userID string, window time.Duration) (int64, error)
+	AttemptsTTL(ctx context.Context, userID string) (time.Duration, error)
+	IncrementFailures(ctx context.Context, userID string, window time.Duration) (int64, error)
+	ResetFailures(ctx context.Context, userID string) error
+	LockAccount(ctx context.Context, userID string, duration time.Duration) error
+	IsLocked(ctx context.Context, userID string) (bool, error)
+	AppendAudit(ctx context.Context, userID string, event AuditEvent) error
+	GetAudit(ctx context.Context, userID string, n int) ([]AuditEvent, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
 
 // Service represents the dependency of this package.
 type Service struct {
 	redis             *redis.Client
 	sessionExpiration time.Duration
+	prefix            string
+}
+
+// DefaultPrefix is the prefix of the session keys in the Redis.
+const DefaultPrefix = "sess:"
+
+// ErrNilClient is returned by every operation of a service that is created without a Redis client.
+var ErrNilClient = errors.New("session: redis client is nil")
+
+// ErrSessionExists is returned when a session is created with a session ID that is already used.
+var ErrSessionExists = errors.New("session: session already exists")
+
+// ErrSessionNotFound is returned when a value is stored in or read from a session that does not exist.
+var ErrSessionNotFound = errors.New("session: session not found")
+
+// ErrInvalidTTL is returned when a session is set or created with a non-positive expiration, which would delete it right away.
+var ErrInvalidTTL = errors.New("session: ttl must be positive")
+
+// ErrInvalidDuration is returned when an account is locked for a non-positive duration, which Redis would keep forever.
+var ErrInvalidDuration = errors.New("session: duration must be positive")
+
+// Used to get the current time, overridable in tests.
+var now = time.Now
+
+// Used to generate the session IDs, overridable in tests.
+var randReader io.Reader = rand.Reader
+
+// Metadata represents the information about the client that created a session.
+type Metadata struct {
+	UserAgent string
+	IP        string
+	Role      string
+}
+
+// SessionInfo represents a session ID, its user, and the metadata of when and where it was created.
+type SessionInfo struct {
+	SessionID string    `json:"sessionId"`
+	UserID    string    `json:"userId"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserAgent string    `json:"userAgent"`
+	IP        string    `json:"ip"`
+	Role      string    `json:"role"`
 }
 
-// KeysAndUsers represents an object of a user and their session ID.
-type KeyAndUser struct {
-	SessionID string `json:"sessionId"`
-	UserID    string `json:"userId"`
+// AuditEvent represents an authentication event of a user, such as a login or a verification.
+type AuditEvent struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	IP        string    `json:"ip"`
+	Outcome   string    `json:"outcome"`
 }
 
+// Types and outcomes of the audit events.
+const (
+	AuditTypeLogin        = "login"
+	AuditTypeVerification = "verification"
+	AuditTypeRecovery     = "recovery"
+	AuditOutcomeSuccess   = "success"
+	AuditOutcomeFailure   = "failure"
+)
+
+// MaxAuditEvents is the number of the most recent audit events that are kept for every user.
+const MaxAuditEvents = 100
+
 // NewService creates a new service to be used to perform operations with the Redis.
+// A nil Redis client will not panic, but every operation will return 'ErrNilClient'.
 func New(redis *redis.Client, sessionExpiration time.Duration) *Service {
 	return &Service{
 		redis:             redis,
 		sessionExpiration: sessionExpiration,
+		prefix:            DefaultPrefix,
 	}
 }
 
+// NewWithPrefix creates a new service that stores the sessions, and the other data of the users, under a custom key prefix.
+// Useful for sharing a Redis database between multiple applications. Some operations use the keys of several sessions
+// at once, so on a Redis Cluster, the prefix has to be a hash tag, such as '{sess}:', to keep every key in one slot.
+func NewWithPrefix(redis *redis.Client, sessionExpiration time.Duration, prefix string) *Service {
+	service := New(redis, sessionExpiration)
+	service.prefix = prefix
+	return service
+}
+
+// Utility function to get the Redis key of a session ID.
+func (s *Service) key(sessionID string) string {
+	return s.prefix + sessionID
+}
+
+// Utility function to get the Redis key of the other data of a user, such as the attempts or the audit events, so it
+// is under the prefix as well. These keys always contain a colon after the prefix, unlike the generated session IDs.
+func (s *Service) userKey(kind string, parts ...string) string {
+	return s.prefix + kind + ":" + strings.Join(parts, ":")
+}
+
+// Utility function to get the Redis key of the index of the sessions of a user, which is a sorted set of the session IDs
+// scored by their creation time. The index has no expiration, as the sessions expire on their own, so the sessions
+// that no longer exist are pruned from it whenever it is read.
+func (s *Service) indexKey(userID string) string {
+	return s.userKey("user_sessions", userID)
+}
+
+// Utility function to check if a Redis key under the prefix belongs to a session, not to the other data of a user.
+func (s *Service) isSessionKey(redisKey string) bool {
+	return !strings.Contains(strings.TrimPrefix(redisKey, s.prefix), ":")
+}
+
 // GenerateSessionID is used to generate URL-safe, base64 encoded, secure generated random string.
 // 32 bytes should be enough for cryptographically safe generation (256 bits).
 // Will return an error if the system's secure random number generator fails to perform properly.
 func GenerateSessionID(numberOfBytes int) (string, error) {
 	b := make([]byte, numberOfBytes)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(randReader, b)
 	if err != nil {
 		return "", err
 	}
@@ -46,11 +178,38 @@ func GenerateSessionID(numberOfBytes int) (string, error) {
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
-// Set is to set a new session ID that is connected with the user ID.
-// Redis's 'SET' can't fail.
-func (s *Service) Set(sessionID, userID string) error {
-	redisKey := fmt.Sprintf("sess:%s", sessionID)
-	_, err := s.redis.Set(ctx, redisKey, userID, s.sessionExpiration).Result()
+// Set is to set a new session ID that is connected with the user ID, with the default expiration.
+// The session is stored as a hash, alongside the metadata of the client.
+func (s *Service) Set(ctx context.Context, sessionID, userID string, metadata Metadata) error {
+	return s.SetWithTTL(ctx, sessionID, userID, metadata, s.sessionExpiration)
+}
+
+// SetWithTTL is to set a new session ID that is connected with the user ID, with a custom expiration.
+// Useful for flows that need longer or shorter sessions than the default.
+func (s *Service) SetWithTTL(ctx context.Context, sessionID, userID string, metadata Metadata, ttl time.Duration) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	redisKey := s.key(sessionID)
+	createdAt := now().Unix()
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(
+			ctx,
+			redisKey,
+			"userId", userID,
+			"createdAt", strconv.FormatInt(createdAt, 10),
+			"userAgent", metadata.UserAgent,
+			"ip", metadata.IP,
+			"role", metadata.Role,
+		)
+		pipe.Expire(ctx, redisKey, ttl)
+		pipe.ZAdd(ctx, s.indexKey(userID), &redis.Z{Score: float64(createdAt), Member: sessionID})
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -58,10 +217,111 @@ func (s *Service) Set(sessionID, userID string) error {
 	return nil
 }
 
+// Script to create a session only if the session ID is not used yet, as the hash and its expiration cannot be set
+// with a single 'SET NX'. If the sessions of the user are limited, the oldest ones in the index of the user are
+// evicted in the same script, so concurrent logins are never able to go over the limit. Every key is declared in
+// 'KEYS', so the keys of the sessions in the index are read beforehand, and passed after the key of the index.
+// If the index has changed since then, nothing is done and -1 is returned, so the caller is able to try again.
+var createSessionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local limit = tonumber(ARGV[8])
+if limit > 0 then
+	local ids = redis.call("ZRANGE", KEYS[2], 0, -1)
+	if #ids ~= #KEYS - 2 then
+		return -1
+	end
+	for i, id in ipairs(ids) do
+		if KEYS[i + 2] ~= ARGV[9] .. id then
+			return -1
+		end
+	end
+	local live = {}
+	for i, id in ipairs(ids) do
+		if redis.call("HGET", KEYS[i + 2], "userId") == ARGV[1] then
+			table.insert(live, i)
+		else
+			redis.call("ZREM", KEYS[2], id)
+		end
+	end
+	for i = 1, #live - limit + 1 do
+		redis.call("DEL", KEYS[live[i] + 2])
+		redis.call("ZREM", KEYS[2], ids[live[i]])
+	end
+end
+redis.call("HSET", KEYS[1], "userId", ARGV[1], "createdAt", ARGV[2], "userAgent", ARGV[3], "ip", ARGV[4], "role", ARGV[5])
+redis.call("PEXPIRE", KEYS[1], ARGV[6])
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[7])
+return 1
+`)
+
+// Create is to set a new session like 'Set', but only if the session ID is not used yet, with both the check and the
+// creation done atomically. Returns 'ErrSessionExists' on a collision, so the caller is able to generate a new ID.
+func (s *Service) Create(ctx context.Context, sessionID, userID string, metadata Metadata) error {
+	return s.CreateWithLimit(ctx, sessionID, userID, metadata, 0)
+}
+
+// CreateWithLimit is to create a new session like 'Create', while evicting the oldest sessions of the user so
+// that the user has at most 'maxSessions' sessions, including the new one. Zero means that there is no limit.
+// If the sessions of the user change while they are being evicted, the creation is tried again.
+func (s *Service) CreateWithLimit(ctx context.Context, sessionID, userID string, metadata Metadata, maxSessions int) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+	if s.sessionExpiration <= 0 {
+		return ErrInvalidTTL
+	}
+
+	args := []interface{}{
+		userID,
+		strconv.FormatInt(now().Unix(), 10),
+		metadata.UserAgent,
+		metadata.IP,
+		metadata.Role,
+		s.sessionExpiration.Milliseconds(),
+		sessionID,
+		maxSessions,
+		s.prefix,
+	}
+	for {
+		keys := []string{s.key(sessionID), s.indexKey(userID)}
+		if maxSessions > 0 {
+			sessionIDs, err := s.redis.ZRange(ctx, s.indexKey(userID), 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			for _, id := range sessionIDs {
+				keys = append(keys, s.key(id))
+			}
+		}
+
+		created, err := createSessionScript.Run(ctx, s.redis, keys, args...).Int()
+		if err != nil {
+			return err
+		}
+		switch created {
+		case -1:
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			continue
+		case 0:
+			return ErrSessionExists
+		default:
+			return nil
+		}
+	}
+}
+
 // Get is to get the user ID that is associated with the session ID.
-func (s *Service) Get(sessionID string) (string, error) {
-	redisKey := fmt.Sprintf("sess:%s", sessionID)
-	res, err := s.redis.Get(ctx, redisKey).Result()
+func (s *Service) Get(ctx context.Context, sessionID string) (string, error) {
+	if s.redis == nil {
+		return "", ErrNilClient
+	}
+
+	redisKey := s.key(sessionID)
+	res, err := s.redis.HGet(ctx, redisKey, "userId").Result()
 	if err != nil && err == redis.Nil {
 		return "", nil
 	}
@@ -72,14 +332,205 @@ func (s *Service) Get(sessionID string) (string, error) {
 	return res, nil
 }
 
-// All is to get all of the currently available sessions.
-func (s *Service) All() ([]KeyAndUser, error) {
+// Script to set the value of a session only if the session exists, so a session without any expiration is never created.
+var setDataScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "data", ARGV[1])
+return 1
+`)
+
+// SetJSON is to store a JSON-serializable value in an existing session, such as a richer session payload.
+// The expiration of the session is kept. Returns 'ErrSessionNotFound' if the session does not exist.
+func (s *Service) SetJSON(ctx context.Context, sessionID string, v interface{}) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	set, err := setDataScript.Run(ctx, s.redis, []string{s.key(sessionID)}, data).Int()
+	if err != nil {
+		return err
+	}
+	if set == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// GetJSON is to read the value stored by 'SetJSON' into 'dst'.
+// Returns 'ErrSessionNotFound' if the session does not exist, or if it does not have a value.
+func (s *Service) GetJSON(ctx context.Context, sessionID string, dst interface{}) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	data, err := s.redis.HGet(ctx, s.key(sessionID), "data").Bytes()
+	if err != nil && err == redis.Nil {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dst)
+}
+
+// GetMany is to get the user IDs of many session IDs in a single round trip. Missing sessions are omitted.
+func (s *Service) GetMany(ctx context.Context, sessionIDs []string) (map[string]string, error) {
+	if s.redis == nil {
+		return nil, ErrNilClient
+	}
+
+	// Sessions are hashes, so 'MGET' cannot be used. Every 'HGET' is pipelined instead.
+	cmds := make([]*redis.StringCmd, len(sessionIDs))
+	_, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, sessionID := range sessionIDs {
+			cmds[i] = pipe.HGet(ctx, s.key(sessionID), "userId")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	users := make(map[string]string, len(sessionIDs))
+	for i, cmd := range cmds {
+		userID, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		users[sessionIDs[i]] = userID
+	}
+
+	return users, nil
+}
+
+// Role is to get the role of the user that is associated with the session ID.
+func (s *Service) Role(ctx context.Context, sessionID string) (string, error) {
+	if s.redis == nil {
+		return "", ErrNilClient
+	}
+
+	res, err := s.redis.HGet(ctx, s.key(sessionID), "role").Result()
+	if err != nil && err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return res, nil
+}
+
+// Info is to get a session and the metadata of when and where it was created.
+// Returns an empty session info if the session does not exist.
+func (s *Service) Info(ctx context.Context, sessionID string) (SessionInfo, error) {
+	if s.redis == nil {
+		return SessionInfo{}, ErrNilClient
+	}
+
+	fields, err := s.redis.HGetAll(ctx, s.key(sessionID)).Result()
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	if len(fields) == 0 {
+		return SessionInfo{}, nil
+	}
+
+	return parseSessionInfo(sessionID, fields), nil
+}
+
+// GetTTL is to get the remaining time until a session expires. Returns zero if the session does not exist.
+func (s *Service) GetTTL(ctx context.Context, sessionID string) (time.Duration, error) {
+	if s.redis == nil {
+		return 0, ErrNilClient
+	}
+
+	ttl, err := s.redis.TTL(ctx, s.key(sessionID)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// Negative values mean the key does not exist or it does not expire.
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// Touch is to get the user ID that is associated with the session ID, and refresh the expiration of the session.
+// Both commands are sent in a single transaction, so an active session never expires between them.
+func (s *Service) Touch(ctx context.Context, sessionID string) (string, error) {
+	if s.redis == nil {
+		return "", ErrNilClient
+	}
+
+	redisKey := s.key(sessionID)
+	var userID *redis.StringCmd
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		userID = pipe.HGet(ctx, redisKey, "userId")
+		pipe.Expire(ctx, redisKey, s.sessionExpiration)
+		return nil
+	})
+	if err != nil && err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return userID.Val(), nil
+}
+
+// Exists is to check whether the session ID is present, without fetching the session itself.
+func (s *Service) Exists(ctx context.Context, sessionID string) (bool, error) {
+	if s.redis == nil {
+		return false, ErrNilClient
+	}
+
+	redisKey := s.key(sessionID)
+	res, err := s.redis.Exists(ctx, redisKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+// Delete is to remove a session, for example when the user logs out.
+func (s *Service) Delete(ctx context.Context, sessionID string) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	return s.redis.Del(ctx, s.key(sessionID)).Err()
+}
+
+// All is to get all of the currently available sessions, with their metadata.
+func (s *Service) All(ctx context.Context) ([]SessionInfo, error) {
+	if s.redis == nil {
+		return nil, ErrNilClient
+	}
+
 	var keysCollection []string
-	var keysAndUsers []KeyAndUser
+	var sessions []SessionInfo
+	var cursor uint64
 
 	for {
 		// Iteratively get all the keys.
-		keys, cursor, err := s.redis.Scan(ctx, 0, "sess:*", 10).Result()
+		keys, nextCursor, err := s.redis.Scan(ctx, cursor, s.prefix+"*", 10).Result()
 		if err != nil && err == redis.Nil {
 			return nil, nil
 		}
@@ -89,27 +540,161 @@ func (s *Service) All() ([]KeyAndUser, error) {
 
 		// Append to this variable every time we get a new result.
 		keysCollection = append(keysCollection, keys...)
+		cursor = nextCursor
 		if cursor == 0 {
 			break
 		}
 	}
 
 	for i := 0; i < len(keysCollection); i += 1 {
-		// Get all users and append them to an object, with their session data.
-		user, err := s.redis.Get(ctx, keysCollection[i]).Result()
+		if !s.isSessionKey(keysCollection[i]) {
+			continue
+		}
+
+		// Get all sessions and their metadata. A session might have expired after being scanned.
+		fields, err := s.redis.HGetAll(ctx, keysCollection[i]).Result()
 		if err != nil {
 			return nil, err
 		}
+		if len(fields) == 0 {
+			continue
+		}
 
-		keysAndUsers = append(keysAndUsers, KeyAndUser{keysCollection[i], user})
+		sessions = append(sessions, parseSessionInfo(strings.TrimPrefix(keysCollection[i], s.prefix), fields))
 	}
 
-	return keysAndUsers, nil
+	return sessions, nil
 }
 
-// BlacklistOTP is used to blacklist OTPs in the Redis database, according to the RFC 6238.
-func (s *Service) BlacklistOTP(otp string) error {
-	_, err := s.redis.SAdd(ctx, "blacklisted_otps", otp).Result()
+// AllForUser is to get all of the currently available sessions of a single user, with their metadata, oldest first.
+// Only the sessions in the index of the user are fetched, instead of scanning every session.
+func (s *Service) AllForUser(ctx context.Context, userID string) ([]SessionInfo, error) {
+	if s.redis == nil {
+		return nil, ErrNilClient
+	}
+
+	indexKey := s.indexKey(userID)
+	sessionIDs, err := s.redis.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(sessionIDs))
+	_, err = s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, sessionID := range sessionIDs {
+			cmds[i] = pipe.HGetAll(ctx, s.key(sessionID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sessions that have expired, or have been replaced by a session of another user, are pruned from the index.
+	var userSessions []SessionInfo
+	var staleSessionIDs []interface{}
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if fields["userId"] != userID {
+			staleSessionIDs = append(staleSessionIDs, sessionIDs[i])
+			continue
+		}
+
+		userSessions = append(userSessions, parseSessionInfo(sessionIDs[i], fields))
+	}
+
+	if len(staleSessionIDs) > 0 {
+		if err := s.redis.ZRem(ctx, indexKey, staleSessionIDs...).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return userSessions, nil
+}
+
+// CountForUser is to count the currently available sessions of a single user, without fetching their metadata.
+// Sessions that have expired, or have been replaced by a session of another user, are pruned from the index.
+func (s *Service) CountForUser(ctx context.Context, userID string) (int, error) {
+	if s.redis == nil {
+		return 0, ErrNilClient
+	}
+
+	indexKey := s.indexKey(userID)
+	sessionIDs, err := s.redis.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(sessionIDs) == 0 {
+		return 0, nil
+	}
+
+	// Sessions that do not exist return 'redis.Nil', so the errors are checked for every command instead.
+	cmds := make([]*redis.StringCmd, len(sessionIDs))
+	s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, sessionID := range sessionIDs {
+			cmds[i] = pipe.HGet(ctx, s.key(sessionID), "userId")
+		}
+		return nil
+	})
+
+	count := 0
+	var staleSessionIDs []interface{}
+	for i, cmd := range cmds {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			return 0, err
+		}
+		if cmd.Val() != userID {
+			staleSessionIDs = append(staleSessionIDs, sessionIDs[i])
+			continue
+		}
+
+		count++
+	}
+
+	if len(staleSessionIDs) > 0 {
+		if err := s.redis.ZRem(ctx, indexKey, staleSessionIDs...).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// Utility function to convert the fields of a session hash into a 'SessionInfo'.
+func parseSessionInfo(sessionID string, fields map[string]string) SessionInfo {
+	info := SessionInfo{
+		SessionID: sessionID,
+		UserID:    fields["userId"],
+		UserAgent: fields["userAgent"],
+		IP:        fields["ip"],
+		Role:      fields["role"],
+	}
+
+	if createdAt, err := strconv.ParseInt(fields["createdAt"], 10, 64); err == nil {
+		info.CreatedAt = time.Unix(createdAt, 0).UTC()
+	}
+
+	return info
+}
+
+// BlacklistTTL is the duration an OTP has to be blacklisted for. After the whole skew window has passed, the OTP
+// cannot be replayed successfully anyway.
+func BlacklistTTL(period, skew uint) time.Duration {
+	return time.Duration(period*(2*skew+1)) * time.Second
+}
+
+// BlacklistOTP is used to blacklist OTPs of a user in the Redis database, according to the RFC 6238.
+// The OTP will expire by itself after the skew window has passed.
+func (s *Service) BlacklistOTP(ctx context.Context, userID, otp string, period, skew uint) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	redisKey := s.userKey("used_otp", userID, otp)
+	_, err := s.redis.Set(ctx, redisKey, "1", BlacklistTTL(period, skew)).Result()
 	if err != nil {
 		return err
 	}
@@ -117,12 +702,282 @@ func (s *Service) BlacklistOTP(otp string) error {
 	return nil
 }
 
-// CheckBlacklistOTP is used to check if the OTP has been used before.
-func (s *Service) CheckBlacklistOTP(otp string) (bool, error) {
-	res, err := s.redis.SIsMember(ctx, "blacklisted_otps", otp).Result()
+// CheckBlacklistOTP is used to check if the OTP has been used before by the user.
+func (s *Service) CheckBlacklistOTP(ctx context.Context, userID, otp string) (bool, error) {
+	if s.redis == nil {
+		return false, ErrNilClient
+	}
+
+	redisKey := s.userKey("used_otp", userID, otp)
+	res, err := s.redis.Exists(ctx, redisKey).Result()
 	if err != nil {
 		return false, err
 	}
 
-	return res, nil
+	return res == 1, nil
+}
+
+// RememberOTP is used to issue the same OTP for repeated requests with the same idempotency key, such as double-clicked
+// logins. The OTP is stored under the key of the user for 'ttl', unless an OTP is already stored, which is returned instead.
+// Reports whether the returned OTP is a replay of a previous request, so it is not delivered again.
+func (s *Service) RememberOTP(ctx context.Context, userID, idempotencyKey, otp string, ttl time.Duration) (string, bool, error) {
+	if s.redis == nil {
+		return "", false, ErrNilClient
+	}
+
+	redisKey := s.userKey("idem", userID, idempotencyKey)
+	stored, err := s.redis.SetNX(ctx, redisKey, otp, ttl).Result()
+	if err != nil {
+		return "", false, err
+	}
+	if stored {
+		return otp, false, nil
+	}
+
+	// The previous OTP might have just expired, in which case the new one is used.
+	previous, err := s.redis.Get(ctx, redisKey).Result()
+	if err != nil && err == redis.Nil {
+		return otp, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return previous, true, nil
+}
+
+// ForgetOTP is used to forget the OTP of an idempotency key, such as when it could not be delivered, so the next
+// request with the same key is not treated as a replay.
+func (s *Service) ForgetOTP(ctx context.Context, userID, idempotencyKey string) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	return s.redis.Del(ctx, s.userKey("idem", userID, idempotencyKey)).Err()
+}
+
+// ClaimOTP is used to check and blacklist an OTP of a user atomically with 'SET NX', so only one of the concurrent
+// requests with the same OTP is able to use it. Returns false if the OTP has been used before.
+func (s *Service) ClaimOTP(ctx context.Context, userID, otp string, period, skew uint) (bool, error) {
+	if s.redis == nil {
+		return false, ErrNilClient
+	}
+
+	redisKey := s.userKey("used_otp", userID, otp)
+	claimed, err := s.redis.SetNX(ctx, redisKey, "1", BlacklistTTL(period, skew)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return claimed, nil
+}
+
+// HashBackupCode is used to hash a backup code, so the Redis never holds the backup codes in plaintext.
+func HashBackupCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// StoreBackupCodes is used to store the hashes of the backup codes of a user, replacing the previous ones.
+// Use 'HashBackupCode' to hash the backup codes.
+func (s *Service) StoreBackupCodes(ctx context.Context, userID string, hashes []string) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	redisKey := s.userKey("backup_codes", userID)
+	members := make([]interface{}, len(hashes))
+	for i, hash := range hashes {
+		members[i] = hash
+	}
+
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisKey)
+		if len(members) > 0 {
+			pipe.SAdd(ctx, redisKey, members...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeBackupCode is used to check if a backup code belongs to the user, and remove it if it does.
+// Checking and removing is done atomically with 'SREM', so a backup code can only be used once.
+func (s *Service) ConsumeBackupCode(ctx context.Context, userID, code string) (bool, error) {
+	if s.redis == nil {
+		return false, ErrNilClient
+	}
+
+	redisKey := s.userKey("backup_codes", userID)
+	res, err := s.redis.SRem(ctx, redisKey, HashBackupCode(code)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+// IncrementAttempts is used to count the verification attempts of a user in a fixed window.
+// The window starts on the first attempt, and the counter is reset after it has passed.
+func (s *Service) IncrementAttempts(ctx context.Context, userID string, window time.Duration) (int64, error) {
+	return s.increment(ctx, s.userKey("attempts", userID), window)
+}
+
+// IncrementFailures is used to count the failed verifications of a user in a fixed window, to lock the account
+// after too many of them. The window starts on the first failure, and the counter is reset after it has passed.
+func (s *Service) IncrementFailures(ctx context.Context, userID string, window time.Duration) (int64, error) {
+	return s.increment(ctx, s.userKey("failures", userID), window)
+}
+
+// ResetFailures is used to forget the failed verifications of a user, for example after a successful login.
+func (s *Service) ResetFailures(ctx context.Context, userID string) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	return s.redis.Del(ctx, s.userKey("failures", userID)).Err()
+}
+
+// Script to increment a counter, and set its expiration on the first increment, atomically. Counters that somehow
+// have no expiration get one as well, so a user is never rate limited or locked out forever.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 or redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// Utility function to increment a counter that expires after a window, starting from the first increment.
+func (s *Service) increment(ctx context.Context, redisKey string, window time.Duration) (int64, error) {
+	if s.redis == nil {
+		return 0, ErrNilClient
+	}
+
+	return incrementScript.Run(ctx, s.redis, []string{redisKey}, window.Milliseconds()).Int64()
+}
+
+// LockAccount is used to lock the account of a user for a duration, so the user cannot log in even with a valid OTP.
+// Non-positive durations are rejected with 'ErrInvalidDuration', as the lock would never expire.
+func (s *Service) LockAccount(ctx context.Context, userID string, duration time.Duration) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+	if duration <= 0 {
+		return ErrInvalidDuration
+	}
+
+	return s.redis.Set(ctx, s.userKey("locked", userID), "1", duration).Err()
+}
+
+// IsLocked is used to check whether the account of a user is locked. Locks are removed automatically once they expire.
+func (s *Service) IsLocked(ctx context.Context, userID string) (bool, error) {
+	if s.redis == nil {
+		return false, ErrNilClient
+	}
+
+	res, err := s.redis.Exists(ctx, s.userKey("locked", userID)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+// AppendAudit is used to record an authentication event of a user. Events are stored as JSON, newest first,
+// and only the most recent 'MaxAuditEvents' are kept. A zero timestamp is set to the current time.
+func (s *Service) AppendAudit(ctx context.Context, userID string, event AuditEvent) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = now()
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	redisKey := s.userKey("audit", userID)
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, redisKey, encoded)
+		pipe.LTrim(ctx, redisKey, 0, MaxAuditEvents-1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAudit is used to get the 'n' most recent authentication events of a user, newest first.
+func (s *Service) GetAudit(ctx context.Context, userID string, n int) ([]AuditEvent, error) {
+	if s.redis == nil {
+		return nil, ErrNilClient
+	}
+	if n <= 0 {
+		return []AuditEvent{}, nil
+	}
+
+	res, err := s.redis.LRange(ctx, s.userKey("audit", userID), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]AuditEvent, 0, len(res))
+	for _, encoded := range res {
+		event := AuditEvent{}
+		if err := json.Unmarshal([]byte(encoded), &event); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// AttemptsTTL is used to get the remaining time until the verification attempts of a user are reset.
+// Returns zero if there are no attempts being counted.
+func (s *Service) AttemptsTTL(ctx context.Context, userID string) (time.Duration, error) {
+	if s.redis == nil {
+		return 0, ErrNilClient
+	}
+
+	redisKey := s.userKey("attempts", userID)
+	ttl, err := s.redis.TTL(ctx, redisKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// Negative values mean the key does not exist or it does not expire.
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// Ping is used to check whether the Redis is reachable.
+func (s *Service) Ping(ctx context.Context) error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	return s.redis.Ping(ctx).Err()
+}
+
+// Close is used to close the Redis client.
+func (s *Service) Close() error {
+	if s.redis == nil {
+		return ErrNilClient
+	}
+
+	return s.redis.Close()
 }
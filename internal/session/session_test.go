@@ -2,12 +2,20 @@
 package session
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
 )
@@ -15,7 +23,64 @@ import (
 // Default is 15 minutes for the cache.
 var sessionExpiration = time.Minute * 15
 
+// Metadata of the mocked client.
+var metadata = Metadata{UserAgent: "Mozilla/5.0", IP: "127.0.0.1", Role: "user"}
+
+// Mocks the current time, so 'createdAt' is always the same.
+func mockNow(t *testing.T) {
+	now = func() time.Time { return time.Unix(1640995200, 0) }
+	t.Cleanup(func() { now = time.Now })
+}
+
+// A reader that always fails, to simulate a broken random number generator.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("random number generator is broken")
+}
+
+// Mocks the random source of the session IDs.
+func mockRandReader(t *testing.T, reader io.Reader) {
+	randReader = reader
+	t.Cleanup(func() { randReader = rand.Reader })
+}
+
+// Utility function to expect a session hash to be written with the given TTL.
+func expectSetSession(mock redismock.ClientMock, sessionKey string, ttl time.Duration) *redismock.ExpectedSlice {
+	mock.ExpectTxPipeline()
+	mock.ExpectHSet(sessionKey, "userId", "randomUser", "createdAt", "1640995200", "userAgent", "Mozilla/5.0", "ip", "127.0.0.1", "role", "user").SetVal(5)
+	mock.ExpectExpire(sessionKey, ttl).SetVal(true)
+	mock.ExpectZAdd("sess:user_sessions:randomUser", &redis.Z{Score: 1640995200, Member: strings.TrimPrefix(sessionKey, "sess:")}).SetVal(1)
+	return mock.ExpectTxPipelineExec()
+}
+
+func TestGenerateSessionID(t *testing.T) {
+	t.Run("test_generate_session_id_fixed", func(t *testing.T) {
+		mockRandReader(t, bytes.NewReader(bytes.Repeat([]byte{0xff}, 6)))
+
+		sessionID, err := GenerateSessionID(6)
+		assert.Nil(t, err)
+		assert.Equal(t, "////////", sessionID)
+	})
+
+	t.Run("test_generate_session_id_fail", func(t *testing.T) {
+		mockRandReader(t, failingReader{})
+
+		sessionID, err := GenerateSessionID(32)
+		assert.EqualError(t, err, "random number generator is broken")
+		assert.Equal(t, "", sessionID)
+	})
+
+	t.Run("test_generate_session_id_short_read", func(t *testing.T) {
+		mockRandReader(t, bytes.NewReader([]byte{1, 2, 3}))
+
+		_, err := GenerateSessionID(32)
+		assert.NotNil(t, err)
+	})
+}
+
 func TestSet(t *testing.T) {
+	mockNow(t)
 	rdb, mock := redismock.NewClientMock()
 	service := New(rdb, sessionExpiration)
 	sessionID, err := GenerateSessionID(32)
@@ -25,18 +90,199 @@ func TestSet(t *testing.T) {
 	sessionKey := fmt.Sprintf("sess:%s", sessionID)
 
 	t.Run("test_set_key_success", func(t *testing.T) {
-		mock.ExpectSet(sessionKey, "randomUser", sessionExpiration).SetVal("")
+		expectSetSession(mock, sessionKey, sessionExpiration)
 
-		err := service.Set(sessionID, "randomUser")
+		err := service.Set(context.Background(), sessionID, "randomUser", metadata)
 		assert.Equal(t, nil, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("test_set_key_failure", func(t *testing.T) {
-		mock.ExpectSet(sessionKey, "randomUser", sessionExpiration).SetErr(errors.New("Expect an error!"))
+		expectSetSession(mock, sessionKey, sessionExpiration).SetErr(errors.New("Expect an error!"))
+
+		err := service.Set(context.Background(), sessionID, "randomUser", metadata)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCreate(t *testing.T) {
+	mockNow(t)
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+	args := []interface{}{"randomUser", "1640995200", "Mozilla/5.0", "127.0.0.1", "user", sessionExpiration.Milliseconds(), "1", 0, "sess:"}
+	keys := []string{"sess:1", "sess:user_sessions:randomUser"}
+
+	t.Run("test_create_success", func(t *testing.T) {
+		mock.ExpectEvalSha(createSessionScript.Hash(), keys, args...).SetVal(int64(1))
+
+		err := service.Create(context.Background(), "1", "randomUser", metadata)
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_create_collision", func(t *testing.T) {
+		mock.ExpectEvalSha(createSessionScript.Hash(), keys, args...).SetVal(int64(0))
+
+		err := service.Create(context.Background(), "1", "randomUser", metadata)
+		assert.Equal(t, ErrSessionExists, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_create_with_limit_declares_keys", func(t *testing.T) {
+		limitArgs := append(append([]interface{}{}, args[:7]...), 2, "sess:")
+		mock.ExpectZRange("sess:user_sessions:randomUser", 0, -1).SetVal([]string{"2"})
+		mock.ExpectEvalSha(createSessionScript.Hash(), append(keys, "sess:2"), limitArgs...).SetVal(int64(1))
+
+		err := service.CreateWithLimit(context.Background(), "1", "randomUser", metadata, 2)
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_create_with_limit_retries_on_change", func(t *testing.T) {
+		limitArgs := append(append([]interface{}{}, args[:7]...), 2, "sess:")
+		mock.ExpectZRange("sess:user_sessions:randomUser", 0, -1).SetVal([]string{"2"})
+		mock.ExpectEvalSha(createSessionScript.Hash(), append(keys, "sess:2"), limitArgs...).SetVal(int64(-1))
+		mock.ExpectZRange("sess:user_sessions:randomUser", 0, -1).SetVal([]string{"2", "3"})
+		mock.ExpectEvalSha(createSessionScript.Hash(), append(keys, "sess:2", "sess:3"), limitArgs...).SetVal(int64(1))
+
+		err := service.CreateWithLimit(context.Background(), "1", "randomUser", metadata, 2)
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_create_fail", func(t *testing.T) {
+		mock.ExpectEvalSha(createSessionScript.Hash(), keys, args...).SetErr(errors.New("An error!"))
+
+		err := service.Create(context.Background(), "1", "randomUser", metadata)
+		assert.EqualError(t, err, "An error!")
+	})
+
+	t.Run("test_create_miniredis", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer mr.Close()
+		service := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration)
+
+		assert.Nil(t, service.Create(context.Background(), "1", "randomUser", metadata))
+		assert.Equal(t, ErrSessionExists, service.Create(context.Background(), "1", "anotherUser", metadata))
+
+		info, err := service.Info(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, SessionInfo{SessionID: "1", UserID: "randomUser", CreatedAt: time.Unix(1640995200, 0).UTC(), UserAgent: "Mozilla/5.0", IP: "127.0.0.1", Role: "user"}, info)
+		assert.Equal(t, sessionExpiration, mr.TTL("sess:1"))
+	})
+
+	t.Run("test_create_invalid_ttl", func(t *testing.T) {
+		for _, ttl := range []time.Duration{0, -time.Minute} {
+			service := New(rdb, ttl)
+			assert.Equal(t, ErrInvalidTTL, service.Create(context.Background(), "1", "randomUser", metadata))
+			assert.Equal(t, ErrInvalidTTL, service.CreateWithLimit(context.Background(), "1", "randomUser", metadata, 1))
+		}
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateWithLimit(t *testing.T) {
+	mockNow(t)
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer mr.Close()
+	service := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration)
+	ctx := context.Background()
+
+	t.Run("test_create_evicts_oldest", func(t *testing.T) {
+		mr.FlushAll()
+		for i, sessionID := range []string{"1", "2", "3"} {
+			assert.Nil(t, service.Set(ctx, sessionID, "kaede", metadata))
+			mr.ZAdd("sess:user_sessions:kaede", float64(i), sessionID)
+		}
+		mr.ZAdd("sess:user_sessions:kaede", 3, "expired")
+
+		assert.Nil(t, service.CreateWithLimit(ctx, "4", "kaede", metadata, 2))
+
+		mr.ZAdd("sess:user_sessions:kaede", 5, "gone")
+		count, err := service.CountForUser(ctx, "kaede")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+
+		sessions, err := service.AllForUser(ctx, "kaede")
+		assert.Nil(t, err)
+		assert.Len(t, sessions, 2)
+		assert.False(t, mr.Exists("sess:1"))
+		assert.False(t, mr.Exists("sess:2"))
+		assert.True(t, mr.Exists("sess:3"))
+		assert.True(t, mr.Exists("sess:4"))
+	})
+
+	t.Run("test_create_collision_evicts_nothing", func(t *testing.T) {
+		mr.FlushAll()
+		assert.Nil(t, service.CreateWithLimit(ctx, "1", "kaede", metadata, 1))
+		assert.Equal(t, ErrSessionExists, service.CreateWithLimit(ctx, "1", "kaede", metadata, 1))
+		assert.True(t, mr.Exists("sess:1"))
+	})
+
+	t.Run("test_concurrent_create_within_limit", func(t *testing.T) {
+		mr.FlushAll()
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.Nil(t, service.CreateWithLimit(ctx, fmt.Sprintf("session-%d", i), "kaede", metadata, 3))
+			}(i)
+		}
+		wg.Wait()
+
+		count, err := service.CountForUser(ctx, "kaede")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, count)
+	})
+}
+
+func TestSetWithTTL(t *testing.T) {
+	mockNow(t)
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+	sessionID, err := GenerateSessionID(32)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	sessionKey := fmt.Sprintf("sess:%s", sessionID)
+
+	t.Run("test_set_default_ttl", func(t *testing.T) {
+		expectSetSession(mock, sessionKey, sessionExpiration)
+
+		err := service.Set(context.Background(), sessionID, "randomUser", metadata)
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_set_override_ttl", func(t *testing.T) {
+		expectSetSession(mock, sessionKey, time.Hour*24)
+
+		err := service.SetWithTTL(context.Background(), sessionID, "randomUser", metadata, time.Hour*24)
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_set_override_ttl_failure", func(t *testing.T) {
+		expectSetSession(mock, sessionKey, time.Minute).SetErr(errors.New("Expect an error!"))
 
-		err := service.Set(sessionID, "randomUser")
+		err := service.SetWithTTL(context.Background(), sessionID, "randomUser", metadata, time.Minute)
 		assert.NotNil(t, err)
 	})
+
+	t.Run("test_set_non_positive_ttl", func(t *testing.T) {
+		for _, ttl := range []time.Duration{0, -time.Minute} {
+			err := service.SetWithTTL(context.Background(), sessionID, "randomUser", metadata, ttl)
+			assert.Equal(t, ErrInvalidTTL, err)
+		}
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
 }
 
 func TestGet(t *testing.T) {
@@ -49,9 +295,9 @@ func TestGet(t *testing.T) {
 	sessionKey := fmt.Sprintf("sess:%s", sessionID)
 
 	t.Run("test_get_key_success", func(t *testing.T) {
-		mock.ExpectGet(sessionKey).SetVal("randomUser")
+		mock.ExpectHGet(sessionKey, "userId").SetVal("randomUser")
 
-		res, err := service.Get(sessionID)
+		res, err := service.Get(context.Background(), sessionID)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -60,9 +306,9 @@ func TestGet(t *testing.T) {
 	})
 
 	t.Run("test_get_key_fail_nil", func(t *testing.T) {
-		mock.ExpectGet(sessionKey).RedisNil()
+		mock.ExpectHGet(sessionKey, "userId").RedisNil()
 
-		res, err := service.Get(sessionID)
+		res, err := service.Get(context.Background(), sessionID)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -71,88 +317,1041 @@ func TestGet(t *testing.T) {
 	})
 
 	t.Run("test_get_key_fail_err", func(t *testing.T) {
-		mock.ExpectGet(sessionKey).SetErr(errors.New("Expect an error!"))
+		mock.ExpectHGet(sessionKey, "userId").SetErr(errors.New("Expect an error!"))
 
-		_, err := service.Get(sessionID)
+		_, err := service.Get(context.Background(), sessionID)
 		assert.Equal(t, "Expect an error!", err.Error())
 	})
 }
 
-func TestAll(t *testing.T) {
+func TestGetMany(t *testing.T) {
 	rdb, mock := redismock.NewClientMock()
 	service := New(rdb, sessionExpiration)
 
-	t.Run("test_get_keys_null", func(t *testing.T) {
-		mock.ExpectScan(0, "sess:*", 10).RedisNil()
+	t.Run("test_get_many_success", func(t *testing.T) {
+		mock.ExpectHGet("sess:1", "userId").SetVal("kaede")
+		mock.ExpectHGet("sess:3", "userId").SetVal("kimura")
+
+		res, err := service.GetMany(context.Background(), []string{"1", "3"})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"1": "kaede", "3": "kimura"}, res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
 
-		res, err := service.All()
+	t.Run("test_get_many_mixed", func(t *testing.T) {
+		// 'redismock' fails every command of a pipeline once one of them fails, so a real Redis is used instead.
+		mr, err := miniredis.Run()
 		if err != nil {
 			log.Fatal(err.Error())
 		}
+		defer mr.Close()
+
+		service := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration)
+		mr.HSet("sess:1", "userId", "kaede")
+		mr.HSet("sess:3", "userId", "kimura")
 
-		assert.Equal(t, []KeyAndUser(nil), res)
+		res, err := service.GetMany(context.Background(), []string{"1", "2", "3", "4"})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"1": "kaede", "3": "kimura"}, res)
 	})
 
-	t.Run("test_get_keys_success", func(t *testing.T) {
-		expectedOutput := []KeyAndUser{{"sess:1", "mock-user"}, {"sess:2", "mock-user"}}
-		mockSessions := []string{"sess:1", "sess:2"}
+	t.Run("test_get_many_fail_err", func(t *testing.T) {
+		mock.ExpectHGet("sess:1", "userId").SetErr(errors.New("Expect an error!"))
 
-		mock.ExpectScan(0, "sess:*", 10).SetVal(mockSessions, 0)
-		mock.ExpectGet("sess:1").SetVal("mock-user")
-		mock.ExpectGet("sess:2").SetVal("mock-user")
+		_, err := service.GetMany(context.Background(), []string{"1"})
+		assert.Equal(t, "Expect an error!", err.Error())
+	})
+}
 
-		res, err := service.All()
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+func TestJSON(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer mr.Close()
+	service := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration)
 
-		assert.Equal(t, expectedOutput, res)
+	type payload struct {
+		Roles    []string  `json:"roles"`
+		Device   string    `json:"device"`
+		IssuedAt time.Time `json:"issuedAt"`
+	}
+	value := payload{Roles: []string{"user", "admin"}, Device: "phone", IssuedAt: time.Unix(1640995200, 0).UTC()}
+
+	t.Run("test_json_round_trip", func(t *testing.T) {
+		assert.Nil(t, service.Set(context.Background(), "1", "randomUser", metadata))
+		mr.SetTTL("sess:1", time.Minute)
+		assert.Nil(t, service.SetJSON(context.Background(), "1", value))
+
+		res := payload{}
+		assert.Nil(t, service.GetJSON(context.Background(), "1", &res))
+		assert.Equal(t, value, res)
+
+		// The session itself and its expiration are kept.
+		userID, err := service.Get(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "randomUser", userID)
+		assert.Equal(t, time.Minute, mr.TTL("sess:1"))
+	})
+
+	t.Run("test_json_without_value", func(t *testing.T) {
+		assert.Nil(t, service.Set(context.Background(), "2", "randomUser", metadata))
+
+		err := service.GetJSON(context.Background(), "2", &payload{})
+		assert.Equal(t, ErrSessionNotFound, err)
+	})
+
+	t.Run("test_json_missing_session", func(t *testing.T) {
+		assert.Equal(t, ErrSessionNotFound, service.SetJSON(context.Background(), "missing", value))
+		assert.False(t, mr.Exists("sess:missing"))
+
+		err := service.GetJSON(context.Background(), "missing", &payload{})
+		assert.Equal(t, ErrSessionNotFound, err)
+	})
+
+	t.Run("test_json_unserializable", func(t *testing.T) {
+		assert.NotNil(t, service.SetJSON(context.Background(), "1", make(chan int)))
 	})
 }
 
-func TestBlacklistOTP(t *testing.T) {
+func TestRole(t *testing.T) {
 	rdb, mock := redismock.NewClientMock()
 	service := New(rdb, sessionExpiration)
 
-	t.Run("test_blacklist_otp_success", func(t *testing.T) {
-		mock.ExpectSAdd("blacklisted_otps", "123").SetVal(1)
+	t.Run("test_role_success", func(t *testing.T) {
+		mock.ExpectHGet("sess:1", "role").SetVal("admin")
 
-		err := service.BlacklistOTP("123")
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+		res, err := service.Role(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "admin", res)
+	})
 
+	t.Run("test_role_fail_nil", func(t *testing.T) {
+		mock.ExpectHGet("sess:1", "role").RedisNil()
+
+		res, err := service.Role(context.Background(), "1")
 		assert.Nil(t, err)
+		assert.Equal(t, "", res)
 	})
 
-	t.Run("test_blacklist_otp_fail", func(t *testing.T) {
-		mock.ExpectSAdd("blacklisted_otps", "123").SetErr(errors.New("An error!"))
+	t.Run("test_role_fail_err", func(t *testing.T) {
+		mock.ExpectHGet("sess:1", "role").SetErr(errors.New("Expect an error!"))
+
+		_, err := service.Role(context.Background(), "1")
+		assert.Equal(t, "Expect an error!", err.Error())
+	})
+}
+
+func TestInfo(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_info_success", func(t *testing.T) {
+		mock.ExpectHGetAll("sess:1").SetVal(map[string]string{"userId": "kaede", "createdAt": "1640995200", "role": "admin"})
+
+		res, err := service.Info(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, SessionInfo{SessionID: "1", UserID: "kaede", CreatedAt: time.Unix(1640995200, 0).UTC(), Role: "admin"}, res)
+	})
 
-		err := service.BlacklistOTP("123")
+	t.Run("test_info_not_found", func(t *testing.T) {
+		mock.ExpectHGetAll("sess:1").SetVal(map[string]string{})
+
+		res, err := service.Info(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, SessionInfo{}, res)
+	})
+
+	t.Run("test_info_fail", func(t *testing.T) {
+		mock.ExpectHGetAll("sess:1").SetErr(errors.New("An error!"))
+
+		_, err := service.Info(context.Background(), "1")
 		assert.NotNil(t, err)
 	})
 }
 
-func TestCheckBlacklistOTP(t *testing.T) {
+func TestGetTTL(t *testing.T) {
 	rdb, mock := redismock.NewClientMock()
 	service := New(rdb, sessionExpiration)
 
-	t.Run("test_check_blacklist_otp_success", func(t *testing.T) {
-		mock.ExpectSIsMember("blacklisted_otps", "123").SetVal(true)
+	t.Run("test_get_ttl", func(t *testing.T) {
+		mock.ExpectTTL("sess:1").SetVal(42 * time.Second)
+
+		res, err := service.GetTTL(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, 42*time.Second, res)
+	})
+
+	t.Run("test_get_ttl_no_key", func(t *testing.T) {
+		mock.ExpectTTL("sess:1").SetVal(-2)
+
+		res, err := service.GetTTL(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), res)
+	})
+
+	t.Run("test_get_ttl_fail", func(t *testing.T) {
+		mock.ExpectTTL("sess:1").SetErr(errors.New("An error!"))
+
+		_, err := service.GetTTL(context.Background(), "1")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestTouch(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+	sessionID, err := GenerateSessionID(32)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	sessionKey := fmt.Sprintf("sess:%s", sessionID)
+
+	t.Run("test_touch_success", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectHGet(sessionKey, "userId").SetVal("randomUser")
+		mock.ExpectExpire(sessionKey, sessionExpiration).SetVal(true)
+		mock.ExpectTxPipelineExec()
+
+		res, err := service.Touch(context.Background(), sessionID)
+		assert.Nil(t, err)
+		assert.Equal(t, "randomUser", res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_touch_not_found", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectHGet(sessionKey, "userId").RedisNil()
+
+		res, err := service.Touch(context.Background(), sessionID)
+		assert.Nil(t, err)
+		assert.Equal(t, "", res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_touch_fail_err", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectHGet(sessionKey, "userId").SetVal("randomUser")
+		mock.ExpectExpire(sessionKey, sessionExpiration).SetVal(true)
+		mock.ExpectTxPipelineExec().SetErr(errors.New("Expect an error!"))
+
+		_, err := service.Touch(context.Background(), sessionID)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestExists(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+	sessionID, err := GenerateSessionID(32)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	sessionKey := fmt.Sprintf("sess:%s", sessionID)
+
+	t.Run("test_exists_present", func(t *testing.T) {
+		mock.ExpectExists(sessionKey).SetVal(1)
+
+		res, err := service.Exists(context.Background(), sessionID)
+		assert.Nil(t, err)
+		assert.True(t, res)
+	})
+
+	t.Run("test_exists_absent", func(t *testing.T) {
+		mock.ExpectExists(sessionKey).SetVal(0)
+
+		res, err := service.Exists(context.Background(), sessionID)
+		assert.Nil(t, err)
+		assert.False(t, res)
+	})
+
+	t.Run("test_exists_fail_err", func(t *testing.T) {
+		mock.ExpectExists(sessionKey).SetErr(errors.New("Expect an error!"))
+
+		_, err := service.Exists(context.Background(), sessionID)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestDelete(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_delete_success", func(t *testing.T) {
+		mock.ExpectDel("sess:1").SetVal(1)
+		assert.Nil(t, service.Delete(context.Background(), "1"))
+	})
+
+	t.Run("test_delete_fail_err", func(t *testing.T) {
+		mock.ExpectDel("sess:1").SetErr(errors.New("Expect an error!"))
+		assert.Equal(t, "Expect an error!", service.Delete(context.Background(), "1").Error())
+	})
+}
+
+func TestPing(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_ping_success", func(t *testing.T) {
+		mock.ExpectPing().SetVal("PONG")
+		assert.Nil(t, service.Ping(context.Background()))
+	})
+
+	t.Run("test_ping_fail_err", func(t *testing.T) {
+		mock.ExpectPing().SetErr(errors.New("Expect an error!"))
+		assert.Equal(t, "Expect an error!", service.Ping(context.Background()).Error())
+	})
+}
+
+func TestAll(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_get_keys_null", func(t *testing.T) {
+		mock.ExpectScan(0, "sess:*", 10).RedisNil()
 
-		res, err := service.CheckBlacklistOTP("123")
+		res, err := service.All(context.Background())
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 
-		assert.Nil(t, err)
-		assert.Equal(t, true, res)
+		assert.Equal(t, []SessionInfo(nil), res)
 	})
 
-	t.Run("test_check_blacklist_otp_fail", func(t *testing.T) {
-		mock.ExpectSIsMember("blacklisted_otps", "123").SetErr(errors.New("An error!"))
+	t.Run("test_get_keys_success", func(t *testing.T) {
+		createdAt := time.Unix(1640995200, 0).UTC()
+		expectedOutput := []SessionInfo{
+			{SessionID: "1", UserID: "mock-user", CreatedAt: createdAt, UserAgent: "Mozilla/5.0", IP: "127.0.0.1", Role: "admin"},
+			{SessionID: "2", UserID: "mock-user", CreatedAt: createdAt, UserAgent: "curl/7.79.1", IP: "10.0.0.1"},
+		}
+
+		mock.ExpectScan(0, "sess:*", 10).SetVal([]string{"sess:1"}, 5)
+		mock.ExpectScan(5, "sess:*", 10).SetVal([]string{"sess:2", "sess:attempts:mock-user", "sess:3"}, 0)
+		mock.ExpectHGetAll("sess:1").SetVal(map[string]string{"userId": "mock-user", "createdAt": "1640995200", "userAgent": "Mozilla/5.0", "ip": "127.0.0.1", "role": "admin"})
+		mock.ExpectHGetAll("sess:2").SetVal(map[string]string{"userId": "mock-user", "createdAt": "1640995200", "userAgent": "curl/7.79.1", "ip": "10.0.0.1"})
+		mock.ExpectHGetAll("sess:3").SetVal(map[string]string{})
+
+		res, err := service.All(context.Background())
+		if err != nil {
+			log.Fatal(err.Error())
+		}
 
-		_, err := service.CheckBlacklistOTP("123")
+		assert.Equal(t, expectedOutput, res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_get_keys_fail_err", func(t *testing.T) {
+		mock.ExpectScan(0, "sess:*", 10).SetVal([]string{"sess:1"}, 0)
+		mock.ExpectHGetAll("sess:1").SetErr(errors.New("Expect an error!"))
+
+		_, err := service.All(context.Background())
 		assert.NotNil(t, err)
 	})
 }
+
+func TestAllForUser(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_get_user_keys_success", func(t *testing.T) {
+		mock.ExpectZRange("sess:user_sessions:kaede", 0, -1).SetVal([]string{"1", "2", "3"})
+		mock.ExpectHGetAll("sess:1").SetVal(map[string]string{"userId": "kaede", "ip": "127.0.0.1"})
+		mock.ExpectHGetAll("sess:2").SetVal(map[string]string{})
+		mock.ExpectHGetAll("sess:3").SetVal(map[string]string{"userId": "kaede", "ip": "10.0.0.2"})
+		mock.ExpectZRem("sess:user_sessions:kaede", "2").SetVal(1)
+
+		res, err := service.AllForUser(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.Equal(t, []SessionInfo{{SessionID: "1", UserID: "kaede", IP: "127.0.0.1"}, {SessionID: "3", UserID: "kaede", IP: "10.0.0.2"}}, res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_get_user_keys_none", func(t *testing.T) {
+		mock.ExpectZRange("sess:user_sessions:kaede", 0, -1).SetVal([]string{})
+
+		res, err := service.AllForUser(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.Empty(t, res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_get_user_keys_fail_err", func(t *testing.T) {
+		mock.ExpectZRange("sess:user_sessions:kaede", 0, -1).SetErr(errors.New("Expect an error!"))
+
+		_, err := service.AllForUser(context.Background(), "kaede")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("test_get_user_keys_miniredis", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer mr.Close()
+		service := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration)
+
+		assert.Nil(t, service.Create(context.Background(), "1", "kaede", metadata))
+		assert.Nil(t, service.Set(context.Background(), "2", "kaede", metadata))
+		assert.Nil(t, service.Set(context.Background(), "3", "another-user", metadata))
+
+		// Deleted sessions and sessions replaced by another user are pruned from the index.
+		assert.Nil(t, service.Set(context.Background(), "4", "kaede", metadata))
+		assert.Nil(t, service.Set(context.Background(), "4", "another-user", metadata))
+		assert.Nil(t, service.Delete(context.Background(), "2"))
+
+		res, err := service.AllForUser(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.Len(t, res, 1)
+		assert.Equal(t, "1", res[0].SessionID)
+
+		members, err := mr.ZMembers("sess:user_sessions:kaede")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1"}, members)
+	})
+}
+
+func TestCountForUser(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_count_user_sessions", func(t *testing.T) {
+		mock.ExpectZRange("sess:user_sessions:kaede", 0, -1).SetVal([]string{"1", "2", "3"})
+		mock.ExpectHGet("sess:1", "userId").SetVal("kaede")
+		mock.ExpectHGet("sess:2", "userId").SetVal("anotherUser")
+		mock.ExpectHGet("sess:3", "userId").SetVal("kaede")
+		mock.ExpectZRem("sess:user_sessions:kaede", "2").SetVal(1)
+
+		count, err := service.CountForUser(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_count_user_sessions_fail", func(t *testing.T) {
+		mock.ExpectZRange("sess:user_sessions:kaede", 0, -1).SetErr(errors.New("Expect an error!"))
+
+		_, err := service.CountForUser(context.Background(), "kaede")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("test_count_user_sessions_hget_fail", func(t *testing.T) {
+		mock.ExpectZRange("sess:user_sessions:kaede", 0, -1).SetVal([]string{"1", "2"})
+		mock.ExpectHGet("sess:1", "userId").RedisNil()
+		mock.ExpectHGet("sess:2", "userId").SetErr(errors.New("Expect an error!"))
+
+		_, err := service.CountForUser(context.Background(), "kaede")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAllForUserOrder(t *testing.T) {
+	current := time.Unix(1640995200, 0)
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = time.Now })
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer mr.Close()
+
+	stores := map[string]Store{
+		"redis":  New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration),
+		"memory": NewMemoryStore(sessionExpiration, 0),
+	}
+
+	// Session IDs are created in the reverse order, and two of them in the same second.
+	for _, sessionID := range []string{"c", "b", "a"} {
+		for _, store := range stores {
+			assert.Nil(t, store.Create(context.Background(), sessionID, "kaede", metadata))
+		}
+		if sessionID != "b" {
+			current = current.Add(time.Second)
+		}
+	}
+
+	for storeName, store := range stores {
+		sessions, err := store.AllForUser(context.Background(), "kaede")
+		assert.Nil(t, err)
+
+		var sessionIDs []string
+		for _, session := range sessions {
+			sessionIDs = append(sessionIDs, session.SessionID)
+		}
+		assert.Equal(t, []string{"c", "a", "b"}, sessionIDs, storeName)
+	}
+}
+
+func TestNewWithPrefix(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := NewWithPrefix(rdb, sessionExpiration, "custom:")
+
+	t.Run("test_get_with_prefix", func(t *testing.T) {
+		mock.ExpectHGet("custom:1", "userId").SetVal("randomUser")
+
+		res, err := service.Get(context.Background(), "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "randomUser", res)
+	})
+
+	t.Run("test_all_with_prefix", func(t *testing.T) {
+		mock.ExpectScan(0, "custom:*", 10).SetVal([]string{"custom:1"}, 0)
+		mock.ExpectHGetAll("custom:1").SetVal(map[string]string{"userId": "randomUser"})
+
+		res, err := service.All(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, []SessionInfo{{SessionID: "1", UserID: "randomUser"}}, res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestBlacklistTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		period   uint
+		skew     uint
+		expected time.Duration
+	}{
+		{name: "test_no_skew", period: 30, skew: 0, expected: 30 * time.Second},
+		{name: "test_default_skew", period: 30, skew: 1, expected: 90 * time.Second},
+		{name: "test_larger_skew", period: 60, skew: 2, expected: 300 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, BlacklistTTL(tt.period, tt.skew))
+		})
+	}
+}
+
+func TestBlacklistOTP(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_blacklist_otp_success", func(t *testing.T) {
+		mock.ExpectSet("sess:used_otp:kaede:123", "1", 90*time.Second).SetVal("OK")
+
+		err := service.BlacklistOTP(context.Background(), "kaede", "123", 30, 1)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_blacklist_otp_fail", func(t *testing.T) {
+		mock.ExpectSet("sess:used_otp:kaede:123", "1", 90*time.Second).SetErr(errors.New("An error!"))
+
+		err := service.BlacklistOTP(context.Background(), "kaede", "123", 30, 1)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCheckBlacklistOTP(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_check_blacklist_otp_success", func(t *testing.T) {
+		mock.ExpectExists("sess:used_otp:kaede:123").SetVal(1)
+
+		res, err := service.CheckBlacklistOTP(context.Background(), "kaede", "123")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		assert.Nil(t, err)
+		assert.Equal(t, true, res)
+	})
+
+	t.Run("test_check_blacklist_otp_not_used", func(t *testing.T) {
+		mock.ExpectExists("sess:used_otp:kaede:123").SetVal(0)
+
+		res, err := service.CheckBlacklistOTP(context.Background(), "kaede", "123")
+		assert.Nil(t, err)
+		assert.Equal(t, false, res)
+	})
+
+	t.Run("test_check_blacklist_otp_fail", func(t *testing.T) {
+		mock.ExpectExists("sess:used_otp:kaede:123").SetErr(errors.New("An error!"))
+
+		_, err := service.CheckBlacklistOTP(context.Background(), "kaede", "123")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestClaimOTP(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_claim_otp_first_claim", func(t *testing.T) {
+		mock.ExpectSetNX("sess:used_otp:kaede:123", "1", 90*time.Second).SetVal(true)
+
+		claimed, err := service.ClaimOTP(context.Background(), "kaede", "123", 30, 1)
+		assert.Nil(t, err)
+		assert.True(t, claimed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_claim_otp_second_claim", func(t *testing.T) {
+		mock.ExpectSetNX("sess:used_otp:kaede:123", "1", 90*time.Second).SetVal(false)
+
+		claimed, err := service.ClaimOTP(context.Background(), "kaede", "123", 30, 1)
+		assert.Nil(t, err)
+		assert.False(t, claimed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_claim_otp_fail", func(t *testing.T) {
+		mock.ExpectSetNX("sess:used_otp:kaede:123", "1", 90*time.Second).SetErr(errors.New("An error!"))
+
+		claimed, err := service.ClaimOTP(context.Background(), "kaede", "123", 30, 1)
+		assert.NotNil(t, err)
+		assert.False(t, claimed)
+	})
+}
+
+func TestRememberOTP(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_remember_otp_first_request", func(t *testing.T) {
+		mock.ExpectSetNX("sess:idem:kaede:key", "123", 30*time.Second).SetVal(true)
+
+		otp, replayed, err := service.RememberOTP(context.Background(), "kaede", "key", "123", 30*time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "123", otp)
+		assert.False(t, replayed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_remember_otp_repeated_request", func(t *testing.T) {
+		mock.ExpectSetNX("sess:idem:kaede:key", "456", 30*time.Second).SetVal(false)
+		mock.ExpectGet("sess:idem:kaede:key").SetVal("123")
+
+		otp, replayed, err := service.RememberOTP(context.Background(), "kaede", "key", "456", 30*time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "123", otp)
+		assert.True(t, replayed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_remember_otp_expired_in_between", func(t *testing.T) {
+		mock.ExpectSetNX("sess:idem:kaede:key", "456", 30*time.Second).SetVal(false)
+		mock.ExpectGet("sess:idem:kaede:key").RedisNil()
+
+		otp, replayed, err := service.RememberOTP(context.Background(), "kaede", "key", "456", 30*time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "456", otp)
+		assert.False(t, replayed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_remember_otp_fail", func(t *testing.T) {
+		mock.ExpectSetNX("sess:idem:kaede:key", "123", 30*time.Second).SetErr(errors.New("An error!"))
+
+		otp, _, err := service.RememberOTP(context.Background(), "kaede", "key", "123", 30*time.Second)
+		assert.NotNil(t, err)
+		assert.Empty(t, otp)
+	})
+}
+
+func TestForgetOTP(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_forget_otp_success", func(t *testing.T) {
+		mock.ExpectDel("sess:idem:kaede:key").SetVal(1)
+
+		assert.Nil(t, service.ForgetOTP(context.Background(), "kaede", "key"))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_forget_otp_fail", func(t *testing.T) {
+		mock.ExpectDel("sess:idem:kaede:key").SetErr(errors.New("An error!"))
+
+		assert.NotNil(t, service.ForgetOTP(context.Background(), "kaede", "key"))
+	})
+}
+
+func TestHashBackupCode(t *testing.T) {
+	assert.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", HashBackupCode("test"))
+	assert.NotEqual(t, HashBackupCode("abcdefgh"), HashBackupCode("abcdefgi"))
+}
+
+func TestStoreBackupCodes(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+	hashes := []string{HashBackupCode("abcdefgh"), HashBackupCode("ijklmnop")}
+
+	t.Run("test_store_backup_codes_success", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectDel("sess:backup_codes:kaede").SetVal(0)
+		mock.ExpectSAdd("sess:backup_codes:kaede", hashes[0], hashes[1]).SetVal(2)
+		mock.ExpectTxPipelineExec()
+
+		err := service.StoreBackupCodes(context.Background(), "kaede", hashes)
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_store_no_backup_codes", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectDel("sess:backup_codes:kaede").SetVal(1)
+		mock.ExpectTxPipelineExec()
+
+		err := service.StoreBackupCodes(context.Background(), "kaede", nil)
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_store_backup_codes_fail", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectDel("sess:backup_codes:kaede").SetVal(0)
+		mock.ExpectSAdd("sess:backup_codes:kaede", hashes[0], hashes[1]).SetVal(2)
+		mock.ExpectTxPipelineExec().SetErr(errors.New("An error!"))
+
+		err := service.StoreBackupCodes(context.Background(), "kaede", hashes)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestConsumeBackupCode(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+	hash := HashBackupCode("abcdefgh")
+
+	t.Run("test_consume_valid_backup_code", func(t *testing.T) {
+		mock.ExpectSRem("sess:backup_codes:kaede", hash).SetVal(1)
+
+		res, err := service.ConsumeBackupCode(context.Background(), "kaede", "abcdefgh")
+		assert.Nil(t, err)
+		assert.True(t, res)
+	})
+
+	t.Run("test_consume_backup_code_reuse", func(t *testing.T) {
+		mock.ExpectSRem("sess:backup_codes:kaede", hash).SetVal(0)
+
+		res, err := service.ConsumeBackupCode(context.Background(), "kaede", "abcdefgh")
+		assert.Nil(t, err)
+		assert.False(t, res)
+	})
+
+	t.Run("test_consume_backup_code_fail", func(t *testing.T) {
+		mock.ExpectSRem("sess:backup_codes:kaede", hash).SetErr(errors.New("An error!"))
+
+		_, err := service.ConsumeBackupCode(context.Background(), "kaede", "abcdefgh")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestIncrementAttempts(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_increment_attempts", func(t *testing.T) {
+		mock.ExpectEvalSha(incrementScript.Hash(), []string{"sess:attempts:kaede"}, int64(60000)).SetVal(int64(2))
+
+		res, err := service.IncrementAttempts(context.Background(), "kaede", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_increment_attempts_fail", func(t *testing.T) {
+		mock.ExpectEvalSha(incrementScript.Hash(), []string{"sess:attempts:kaede"}, int64(60000)).SetErr(errors.New("An error!"))
+
+		_, err := service.IncrementAttempts(context.Background(), "kaede", time.Minute)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("test_increment_attempts_miniredis", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer mr.Close()
+		service := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration)
+
+		// The first attempt starts the window, and the next ones keep it.
+		res, err := service.IncrementAttempts(context.Background(), "kaede", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), res)
+		mr.FastForward(30 * time.Second)
+
+		res, err = service.IncrementAttempts(context.Background(), "kaede", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), res)
+		assert.Equal(t, 30*time.Second, mr.TTL("sess:attempts:kaede"))
+
+		// The counter is reset after the window has passed.
+		mr.FastForward(30 * time.Second)
+		res, err = service.IncrementAttempts(context.Background(), "kaede", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), res)
+
+		// Counters without an expiration get one, so they do not stay forever.
+		mr.Set("sess:attempts:kimura", "5")
+		res, err = service.IncrementAttempts(context.Background(), "kimura", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(6), res)
+		assert.Equal(t, time.Minute, mr.TTL("sess:attempts:kimura"))
+	})
+}
+
+func TestFailures(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_first_failure_starts_window", func(t *testing.T) {
+		mock.ExpectEvalSha(incrementScript.Hash(), []string{"sess:failures:kaede"}, int64(60000)).SetVal(int64(1))
+
+		res, err := service.IncrementFailures(context.Background(), "kaede", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), res)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_reset_failures", func(t *testing.T) {
+		mock.ExpectDel("sess:failures:kaede").SetVal(1)
+
+		assert.Nil(t, service.ResetFailures(context.Background(), "kaede"))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestLockAccount(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_lock_account", func(t *testing.T) {
+		mock.ExpectSet("sess:locked:kaede", "1", 5*time.Minute).SetVal("OK")
+
+		assert.Nil(t, service.LockAccount(context.Background(), "kaede", 5*time.Minute))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_is_locked", func(t *testing.T) {
+		mock.ExpectExists("sess:locked:kaede").SetVal(1)
+
+		locked, err := service.IsLocked(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.True(t, locked)
+	})
+
+	t.Run("test_is_not_locked", func(t *testing.T) {
+		mock.ExpectExists("sess:locked:kaede").SetVal(0)
+
+		locked, err := service.IsLocked(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.False(t, locked)
+	})
+
+	t.Run("test_is_locked_fail", func(t *testing.T) {
+		mock.ExpectExists("sess:locked:kaede").SetErr(errors.New("An error!"))
+
+		_, err := service.IsLocked(context.Background(), "kaede")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("test_stores_agree_on_duration", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer mr.Close()
+
+		stores := map[string]Store{
+			"redis":  New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration),
+			"memory": NewMemoryStore(sessionExpiration, 0),
+		}
+
+		tests := []struct {
+			name           string
+			duration       time.Duration
+			expectedErr    error
+			expectedLocked bool
+		}{
+			{name: "test_positive_duration", duration: time.Minute, expectedErr: nil, expectedLocked: true},
+			{name: "test_zero_duration", duration: 0, expectedErr: ErrInvalidDuration, expectedLocked: false},
+			{name: "test_negative_duration", duration: -time.Minute, expectedErr: ErrInvalidDuration, expectedLocked: false},
+		}
+
+		for _, tt := range tests {
+			for storeName, store := range stores {
+				userID := storeName + tt.name
+				assert.Equal(t, tt.expectedErr, store.LockAccount(context.Background(), userID, tt.duration), storeName)
+
+				locked, err := store.IsLocked(context.Background(), userID)
+				assert.Nil(t, err)
+				assert.Equal(t, tt.expectedLocked, locked, storeName)
+			}
+		}
+
+		// Only the valid lock is in the Redis, and it expires.
+		assert.Equal(t, []string{"sess:locked:redistest_positive_duration"}, mr.Keys())
+		assert.Equal(t, time.Minute, mr.TTL("sess:locked:redistest_positive_duration"))
+	})
+}
+
+func TestAttemptsTTL(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+
+	t.Run("test_attempts_ttl", func(t *testing.T) {
+		mock.ExpectTTL("sess:attempts:kaede").SetVal(42 * time.Second)
+
+		res, err := service.AttemptsTTL(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.Equal(t, 42*time.Second, res)
+	})
+
+	t.Run("test_attempts_ttl_no_key", func(t *testing.T) {
+		mock.ExpectTTL("sess:attempts:kaede").SetVal(-2)
+
+		res, err := service.AttemptsTTL(context.Background(), "kaede")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), res)
+	})
+
+	t.Run("test_attempts_ttl_fail", func(t *testing.T) {
+		mock.ExpectTTL("sess:attempts:kaede").SetErr(errors.New("An error!"))
+
+		_, err := service.AttemptsTTL(context.Background(), "kaede")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAudit(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	service := New(rdb, sessionExpiration)
+	event := AuditEvent{Type: AuditTypeLogin, Timestamp: time.Unix(1640995200, 0).UTC(), IP: "127.0.0.1", Outcome: AuditOutcomeSuccess}
+	encoded := `{"type":"login","timestamp":"2022-01-01T00:00:00Z","ip":"127.0.0.1","outcome":"success"}`
+
+	t.Run("test_append_audit", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectLPush("sess:audit:kaede", []byte(encoded)).SetVal(1)
+		mock.ExpectLTrim("sess:audit:kaede", 0, MaxAuditEvents-1).SetVal("OK")
+		mock.ExpectTxPipelineExec()
+
+		assert.Nil(t, service.AppendAudit(context.Background(), "kaede", event))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_append_audit_fail", func(t *testing.T) {
+		mock.ExpectTxPipeline()
+		mock.ExpectLPush("sess:audit:kaede", []byte(encoded)).SetVal(1)
+		mock.ExpectLTrim("sess:audit:kaede", 0, MaxAuditEvents-1).SetVal("OK")
+		mock.ExpectTxPipelineExec().SetErr(errors.New("An error!"))
+
+		assert.NotNil(t, service.AppendAudit(context.Background(), "kaede", event))
+	})
+
+	t.Run("test_get_audit", func(t *testing.T) {
+		mock.ExpectLRange("sess:audit:kaede", 0, 4).SetVal([]string{encoded})
+
+		events, err := service.GetAudit(context.Background(), "kaede", 5)
+		assert.Nil(t, err)
+		assert.Equal(t, []AuditEvent{event}, events)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test_get_audit_fail", func(t *testing.T) {
+		mock.ExpectLRange("sess:audit:kaede", 0, 4).SetErr(errors.New("An error!"))
+
+		_, err := service.GetAudit(context.Background(), "kaede", 5)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("test_get_audit_trimmed", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer mr.Close()
+		service := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessionExpiration)
+
+		for i := 0; i < MaxAuditEvents+5; i++ {
+			event := AuditEvent{Type: AuditTypeVerification, Timestamp: time.Unix(int64(i), 0).UTC(), Outcome: AuditOutcomeFailure}
+			assert.Nil(t, service.AppendAudit(context.Background(), "kaede", event))
+		}
+
+		events, err := service.GetAudit(context.Background(), "kaede", MaxAuditEvents*2)
+		assert.Nil(t, err)
+		assert.Len(t, events, MaxAuditEvents)
+		assert.Equal(t, time.Unix(MaxAuditEvents+4, 0).UTC(), events[0].Timestamp)
+		assert.Equal(t, time.Unix(5, 0).UTC(), events[len(events)-1].Timestamp)
+	})
+}
+
+func TestNilClient(t *testing.T) {
+	service := New(nil, sessionExpiration)
+
+	t.Run("test_set_nil_client", func(t *testing.T) {
+		assert.Equal(t, ErrNilClient, service.Set(context.Background(), "sessionID", "randomUser", metadata))
+	})
+
+	t.Run("test_get_nil_client", func(t *testing.T) {
+		_, err := service.Get(context.Background(), "sessionID")
+		assert.Equal(t, ErrNilClient, err)
+	})
+
+	t.Run("test_exists_nil_client", func(t *testing.T) {
+		_, err := service.Exists(context.Background(), "sessionID")
+		assert.Equal(t, ErrNilClient, err)
+	})
+
+	t.Run("test_json_nil_client", func(t *testing.T) {
+		assert.Equal(t, ErrNilClient, service.SetJSON(context.Background(), "sessionID", "value"))
+		assert.Equal(t, ErrNilClient, service.GetJSON(context.Background(), "sessionID", new(string)))
+	})
+
+	t.Run("test_all_nil_client", func(t *testing.T) {
+		_, err := service.All(context.Background())
+		assert.Equal(t, ErrNilClient, err)
+	})
+
+	t.Run("test_blacklist_nil_client", func(t *testing.T) {
+		assert.Equal(t, ErrNilClient, service.BlacklistOTP(context.Background(), "kaede", "123", 30, 1))
+
+		_, err := service.CheckBlacklistOTP(context.Background(), "kaede", "123")
+		assert.Equal(t, ErrNilClient, err)
+
+		_, err = service.ClaimOTP(context.Background(), "kaede", "123", 30, 1)
+		assert.Equal(t, ErrNilClient, err)
+
+		_, _, err = service.RememberOTP(context.Background(), "kaede", "key", "123", 30*time.Second)
+		assert.Equal(t, ErrNilClient, err)
+
+		err = service.ForgetOTP(context.Background(), "kaede", "key")
+		assert.Equal(t, ErrNilClient, err)
+	})
+}
+
+func TestCancelledContext(t *testing.T) {
+	// The context is checked before a connection is taken, so no Redis is needed here.
+	service := New(redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"}), sessionExpiration)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("test_set_cancelled_context", func(t *testing.T) {
+		assert.ErrorIs(t, service.Set(ctx, "sessionID", "randomUser", metadata), context.Canceled)
+	})
+
+	t.Run("test_get_cancelled_context", func(t *testing.T) {
+		_, err := service.Get(ctx, "sessionID")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("test_all_cancelled_context", func(t *testing.T) {
+		_, err := service.All(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("test_blacklist_cancelled_context", func(t *testing.T) {
+		assert.ErrorIs(t, service.BlacklistOTP(ctx, "kaede", "123", 30, 1), context.Canceled)
+
+		_, err := service.CheckBlacklistOTP(ctx, "kaede", "123")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}